@@ -0,0 +1,200 @@
+package block
+
+import (
+	"bufio"
+	"bytes"
+	ecc "elliptic_curve"
+	"fmt"
+	"io"
+	"math/big"
+	tx "transaction"
+)
+
+// coinbase witness has one item, the reserved value of 32 bytes
+const WITNESS_RESERVED_VALUE_LENGTH = 32
+
+/*
+full block is the header followed by the transactions:
+header(80) | transaction count(varint) | transactions
+
+the first transaction is the coinbase, the header commits to all of the
+transactions by the merkle root of their ids
+*/
+type Block struct {
+	header       *BlockHeader
+	transactions []*tx.Transaction
+}
+
+func InitBlock(header *BlockHeader, transactions []*tx.Transaction) *Block {
+	return &Block{
+		header:       header,
+		transactions: transactions,
+	}
+}
+
+func ParseBlock(binary []byte) (*Block, error) {
+	reader := bufio.NewReader(bytes.NewReader(binary))
+	header, err := readBlockHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := tx.ReadVarint(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read transaction count: %w", err)
+	}
+	/*
+		every transaction takes at least 60 bytes, a count more than the
+		remaining bytes is a malformed block, we don't allocate for it
+	*/
+	if count.Cmp(big.NewInt(int64(len(binary)))) > 0 {
+		return nil, fmt.Errorf("%w: transaction count %v", ErrTruncatedInput, count)
+	}
+	transactions := make([]*tx.Transaction, 0, count.Int64())
+	for i := 0; i < int(count.Int64()); i++ {
+		transaction, err := tx.ReadTransaction(reader)
+		if err != nil {
+			return nil, fmt.Errorf("read transaction %d: %w", i, err)
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	if _, err := reader.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("%w: bytes left after %d transactions", ErrTrailingData, len(transactions))
+	}
+	return InitBlock(header, transactions), nil
+}
+
+func (b *Block) Serialize() []byte {
+	result := b.header.Serialize()
+	result = append(result, tx.EncodeVarint(big.NewInt(int64(len(b.transactions))))...)
+	for _, transaction := range b.transactions {
+		result = append(result, transaction.Serialize()...)
+	}
+	return result
+}
+
+func (b *Block) Header() *BlockHeader {
+	return b.header
+}
+
+func (b *Block) Transactions() []*tx.Transaction {
+	return b.transactions
+}
+
+func (b *Block) String() string {
+	return fmt.Sprintf("%s transactions: %d\n", b.header, len(b.transactions))
+}
+
+func MerkleParent(left []byte, right []byte) []byte {
+	// hashes are in little endian as they are in the serialized data
+	return ecc.Hash256(string(append(append([]byte{}, left...), right...)))
+}
+
+func MerkleParentLevel(hashes [][]byte) [][]byte {
+	/*
+		hash every pair of neighbours, if the count is odd the last hash
+		pairs with itself
+	*/
+	if len(hashes)%2 == 1 {
+		hashes = append(hashes[0:len(hashes):len(hashes)], hashes[len(hashes)-1])
+	}
+	parents := make([][]byte, 0, len(hashes)/2)
+	for i := 0; i < len(hashes); i += 2 {
+		parents = append(parents, MerkleParent(hashes[i], hashes[i+1]))
+	}
+	return parents
+}
+
+func MerkleRoot(hashes [][]byte) []byte {
+	// root of the hashes in little endian, nil for no hash
+	if len(hashes) == 0 {
+		return nil
+	}
+	for len(hashes) > 1 {
+		hashes = MerkleParentLevel(hashes)
+	}
+	return hashes[0]
+}
+
+func (b *Block) ComputeMerkleRoot() []byte {
+	// merkle root of the transaction ids, big endian as it is in the header
+	hashes := make([][]byte, 0, len(b.transactions))
+	for _, transaction := range b.transactions {
+		hashes = append(hashes, tx.ReverseByteSlice(transaction.Hash()))
+	}
+	return tx.ReverseByteSlice(MerkleRoot(hashes))
+}
+
+func (b *Block) CheckMerkleRoot() bool {
+	return len(b.transactions) > 0 && bytes.Equal(b.ComputeMerkleRoot(), b.header.merkleRoot)
+}
+
+func (b *Block) ComputeWitnessRoot() []byte {
+	/*
+		merkle root of wtxids, the coinbase can't commit to its own wtxid,
+		it is replaced with 32 zero bytes, result is in little endian as it
+		is used in the commitment
+	*/
+	hashes := make([][]byte, 0, len(b.transactions))
+	for i, transaction := range b.transactions {
+		if i == 0 {
+			hashes = append(hashes, make([]byte, 32))
+			continue
+		}
+		hashes = append(hashes, tx.ReverseByteSlice(transaction.WitnessHash()))
+	}
+	return MerkleRoot(hashes)
+}
+
+func WitnessCommitment(witnessRoot []byte, reservedValue []byte) []byte {
+	return ecc.Hash256(string(append(append([]byte{}, witnessRoot...), reservedValue...)))
+}
+
+func (b *Block) CheckWitnessCommitment() error {
+	/*
+		BIP141, if the coinbase has the commitment output, its witness should
+		be exactly the 32 bytes reserved value, and the commitment is
+		hash256(witness root | reserved value). A block without commitment
+		should not have any witness data
+	*/
+	if len(b.transactions) == 0 {
+		return ErrNoTransactions
+	}
+	coinbase := b.transactions[0]
+	commitment, ok := coinbase.WitnessCommitment()
+	if !ok {
+		for i, transaction := range b.transactions {
+			if transaction.IsSegwit() {
+				return fmt.Errorf("%w: transaction %d has witness but no commitment", ErrWitnessCommitment, i)
+			}
+		}
+		return nil
+	}
+
+	witness := coinbase.Inputs()[0].Witness()
+	if len(witness) != 1 || len(witness[0]) != WITNESS_RESERVED_VALUE_LENGTH {
+		return fmt.Errorf("%w: coinbase witness should be the %d bytes reserved value",
+			ErrWitnessCommitment, WITNESS_RESERVED_VALUE_LENGTH)
+	}
+	expected := WitnessCommitment(b.ComputeWitnessRoot(), witness[0])
+	if !bytes.Equal(commitment, expected) {
+		return fmt.Errorf("%w: coinbase commits to %x but got %x", ErrWitnessCommitment, commitment, expected)
+	}
+	return nil
+}
+
+func (b *Block) Validate() error {
+	// checks not relying on the chain, proof of work, merkle root and witness commitment
+	if len(b.transactions) == 0 {
+		return ErrNoTransactions
+	}
+	if !b.header.CheckProofOfWork() {
+		return fmt.Errorf("%w: block %s", ErrProofOfWork, b.header.ID())
+	}
+	if !b.CheckMerkleRoot() {
+		return fmt.Errorf("%w: header has %x but got %x", ErrMerkleRootMismatch,
+			b.header.merkleRoot, b.ComputeMerkleRoot())
+	}
+	return b.CheckWitnessCommitment()
+}
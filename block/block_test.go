@@ -0,0 +1,166 @@
+package block
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	tx "transaction"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	GENESIS_COINBASE = "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+	// signed native p2wpkh example from BIP143
+	SEGWIT_TRANSACTION = "01000000000102fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f00000000494830450221008b9d1dc26ba6a9cb62127b02742fa9d754cd3bebf337f7a55d114c8e5cdd30be022040529b194ba3f9281a99f2b1c0a19c0489bc22ede944ccf4ecbab4cc618ef3ed01eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac000247304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d5447a12fb1366d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d43c212a8caed02de67eebee0121025476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee635711000000"
+	// legacy transaction from chapter 5 of the book
+	LEGACY_TRANSACTION = "0100000001813f79011acb80925dfe69b3def355fe914bd1d96a3f5f71bf8303c6a989c7d1000000006b483045022100ed81ff192e75a3fd2304004dcadb746fa5e24c5031ccfcf21320b0277457c98f02207a986d955c6e0cb35d446a89d3f56100f4d7f67801c31967743a9c8e10615bed01210349fc4e631e3624a545de3f89f5d8684c7b8138bd94bdd531d2e213bf016b278afeffffff02a135ef01000000001976a914bc3b654dca7e56b04dca18f2566cdaf02e8d9ada88ac99c39800000000001976a9141c4bc762dd5423e332166702cb75f40df79fea1288ac19430600"
+	REGTEST_BITS       = "ffff7f20"
+)
+
+func TestMerkleRoot(t *testing.T) {
+	left, _ := hex.DecodeString("c117ea8ec828342f4dfb0ad6bd140e03a50720ece40169ee38bdc15d9eb64cf5")
+	right, _ := hex.DecodeString("c131474164b412e3406696da1ee20ab0fc9bf41c8f05fa8ceea7a08d672d7cc5")
+	assert.Equal(t, "8b30c5ba100f6f2e5ad1e2a742e5020491240f8eb514fe97c713c31718ad7ecd", fmt.Sprintf("%x", MerkleParent(left, right)))
+
+	hexHashes := []string{
+		"c117ea8ec828342f4dfb0ad6bd140e03a50720ece40169ee38bdc15d9eb64cf5",
+		"c131474164b412e3406696da1ee20ab0fc9bf41c8f05fa8ceea7a08d672d7cc5",
+		"f391da6ecfeed1814efae39e7fcb3838ae0b02c02ae7d0a5848a66947c0727b0",
+		"3d238a92a94532b946c90e19c49351c763696cff3db400485b813aecb8a13181",
+		"10092f2633be5f3ce349bf9ddbde36caa3dd10dfa0ec8106bce23acbff637dae",
+		"7d37b3d54fa6a64869084bfd2e831309118b9e833610e6228adacdbd1b4ba161",
+		"8118a77e542892fe15ae3fc771a4abfd2f5d5d5997544c3487ac36b5c85170fc",
+		"dff6879848c2c9b62fe652720b8df5272093acfaa45a43cdb3696fe2466a3877",
+		"b825c0745f46ac58f7d3759e6dc535a1fec7820377f24d4c2c6ad2cc55c0cb59",
+		"95513952a04bd8992721e9b7e2937f1c04ba31e0469fbe615a78197f68f52b7c",
+		"2e6d722e5e4dbdf2447ddecc9f7dabb8e299bae921c99ad5b0184cd9eb8e5908",
+		"b13a750047bc0bdceb2473e5fe488c2596d7a7124b4e716fdd29b046ef99bbf0",
+	}
+	hashes := make([][]byte, 0)
+	for _, h := range hexHashes {
+		hash, _ := hex.DecodeString(h)
+		hashes = append(hashes, hash)
+	}
+	assert.Equal(t, "acbcab8bcc1af95d8d563b77d24c3d19b18f1486383d75a5085c4e86c86beed6", fmt.Sprintf("%x", MerkleRoot(hashes)))
+	// odd level doesn't change the given slice
+	assert.Equal(t, 6, len(MerkleParentLevel(hashes[0:11])))
+	assert.Equal(t, hexHashes[11], fmt.Sprintf("%x", hashes[11]))
+	assert.Nil(t, MerkleRoot(nil))
+}
+
+func TestParseGenesisBlock(t *testing.T) {
+	raw, _ := hex.DecodeString(GENESIS_HEADER + "01" + GENESIS_COINBASE)
+	block, err := ParseBlock(raw)
+	assert.Nil(t, err)
+	fmt.Printf("%s", block)
+
+	assert.Equal(t, 1, len(block.Transactions()))
+	assert.Equal(t, "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b", block.Transactions()[0].ID())
+	assert.Equal(t, fmt.Sprintf("%x", block.Header().MerkleRoot()), fmt.Sprintf("%x", block.ComputeMerkleRoot()))
+	assert.True(t, block.CheckMerkleRoot())
+	assert.Nil(t, block.Validate())
+	assert.Equal(t, raw, block.Serialize())
+
+	_, err = ParseBlock(append(raw, 0x00))
+	assert.True(t, errors.Is(err, ErrTrailingData))
+	_, err = ParseBlock(raw[0 : len(raw)-1])
+	assert.True(t, errors.Is(err, ErrTruncatedInput))
+}
+
+func coinbaseWithCommitment(commitment []byte) string {
+	/*
+		version | marker, flag | null outpoint | scriptSig of height 1 |
+		sequence | two outputs, the second is the commitment | witness with
+		32 zero bytes reserved value | lock time
+	*/
+	return "01000000" + "0001" + "01" + strings.Repeat("00", 32) + "ffffffff" + "025101" + "ffffffff" +
+		"02" + "00f2052a01000000" + "0151" + "0000000000000000" + "26" + "6a24aa21a9ed" + fmt.Sprintf("%x", commitment) +
+		"01" + "20" + strings.Repeat("00", 32) + "00000000"
+}
+
+func mineBlock(t *testing.T, transactions []string) *Block {
+	// regtest bits, about half of the nonces meet the target
+	raw := ""
+	parsed := make([]*tx.Transaction, 0)
+	for _, transaction := range transactions {
+		raw += transaction
+		binary, _ := hex.DecodeString(transaction)
+		parsedTx, err := tx.ParseTransaction(binary)
+		assert.Nil(t, err)
+		parsed = append(parsed, parsedTx)
+	}
+	merkleRoot := InitBlock(nil, parsed).ComputeMerkleRoot()
+	bits, _ := hex.DecodeString(REGTEST_BITS)
+	prevBlock, _ := hex.DecodeString(strings.Repeat("00", 32))
+	var header *BlockHeader
+	for nonce := byte(0); ; nonce++ {
+		header = InitBlockHeader(tx.LittleEndianToBigInt([]byte{0, 0, 0, 0x20}, tx.LITTLE_ENDIAN_4_BYTES),
+			prevBlock, merkleRoot, tx.LittleEndianToBigInt([]byte{0x29, 0xab, 0x5f, 0x49}, tx.LITTLE_ENDIAN_4_BYTES),
+			bits, []byte{nonce, 0, 0, 0})
+		if header.CheckProofOfWork() {
+			break
+		}
+	}
+	binary, _ := hex.DecodeString(fmt.Sprintf("%x", header.Serialize()) + fmt.Sprintf("%02x", len(transactions)) + raw)
+	block, err := ParseBlock(binary)
+	assert.Nil(t, err)
+	return block
+}
+
+func TestWitnessCommitment(t *testing.T) {
+	segwitTx, _ := hex.DecodeString(SEGWIT_TRANSACTION)
+	parsed, _ := tx.ParseTransaction(segwitTx)
+	legacyTx, _ := hex.DecodeString(LEGACY_TRANSACTION)
+	legacy, _ := tx.ParseTransaction(legacyTx)
+
+	// coinbase wtxid is zero, wtxid of legacy transaction is the same as its txid
+	witnessRoot := MerkleRoot([][]byte{make([]byte, 32), tx.ReverseByteSlice(parsed.WitnessHash()),
+		tx.ReverseByteSlice(legacy.WitnessHash())})
+	assert.Equal(t, legacy.ID(), legacy.WitnessID())
+	commitment := WitnessCommitment(witnessRoot, make([]byte, 32))
+
+	block := mineBlock(t, []string{coinbaseWithCommitment(commitment), SEGWIT_TRANSACTION, LEGACY_TRANSACTION})
+	assert.Equal(t, 3, len(block.Transactions()))
+	assert.True(t, block.CheckMerkleRoot())
+	assert.Nil(t, block.Validate())
+	assert.Equal(t, witnessRoot, block.ComputeWitnessRoot())
+
+	// the third transaction is not the one in the commitment
+	wrong := mineBlock(t, []string{coinbaseWithCommitment(commitment), SEGWIT_TRANSACTION, SEGWIT_TRANSACTION})
+	assert.True(t, wrong.CheckMerkleRoot())
+	assert.True(t, errors.Is(wrong.Validate(), ErrWitnessCommitment))
+
+	// witness data without commitment in the coinbase
+	raw, _ := hex.DecodeString(GENESIS_COINBASE)
+	genesisTx, _ := tx.ParseTransaction(raw)
+	block = InitBlock(nil, []*tx.Transaction{genesisTx, parsed})
+	assert.True(t, errors.Is(block.CheckWitnessCommitment(), ErrWitnessCommitment))
+	block = InitBlock(nil, []*tx.Transaction{genesisTx})
+	assert.Nil(t, block.CheckWitnessCommitment())
+}
+
+func TestValidateBlock(t *testing.T) {
+	raw, _ := hex.DecodeString(GENESIS_HEADER + "01" + GENESIS_COINBASE)
+	block, _ := ParseBlock(raw)
+
+	// merkle root is changed
+	header := block.Header().Serialize()
+	header[36] ^= 0x01
+	tampered, _ := ParseBlockHeader(header)
+	assert.False(t, InitBlock(tampered, block.Transactions()).CheckMerkleRoot())
+	assert.True(t, errors.Is(InitBlock(tampered, block.Transactions()).Validate(), ErrProofOfWork))
+
+	bits, _ := hex.DecodeString(REGTEST_BITS)
+	for nonce := byte(0); ; nonce++ {
+		tampered = InitBlockHeader(tampered.Version(), tampered.PrevBlock(), tampered.MerkleRoot(),
+			tampered.Timestamp(), bits, []byte{nonce, 0, 0, 0})
+		if tampered.CheckProofOfWork() {
+			break
+		}
+	}
+	assert.True(t, errors.Is(InitBlock(tampered, block.Transactions()).Validate(), ErrMerkleRootMismatch))
+	assert.True(t, errors.Is(InitBlock(tampered, nil).Validate(), ErrNoTransactions))
+}
@@ -0,0 +1,27 @@
+package block
+
+import (
+	"errors"
+	tx "transaction"
+)
+
+/*
+errors returned when parsing and validating blocks, they are wrapped with
+details, check them by errors.Is
+*/
+var (
+	ErrTruncatedInput     = tx.ErrTruncatedInput
+	ErrTrailingData       = errors.New("trailing data after block")
+	ErrInvalidBits        = errors.New("invalid bits")
+	ErrNoTransactions     = errors.New("block has no transaction")
+	ErrProofOfWork        = errors.New("proof of work failed")
+	ErrMerkleRootMismatch = errors.New("merkle root mismatch")
+	ErrWitnessCommitment  = errors.New("invalid witness commitment")
+)
+
+// errors returned when validating a chain of headers
+var (
+	ErrBrokenChain         = errors.New("header doesn't follow the previous one")
+	ErrUnexpectedBits      = errors.New("unexpected bits")
+	ErrInsufficientHeaders = errors.New("insufficient headers")
+)
@@ -0,0 +1,205 @@
+package block
+
+import (
+	"bufio"
+	"bytes"
+	ecc "elliptic_curve"
+	"fmt"
+	"math/big"
+	tx "transaction"
+)
+
+const (
+	BLOCK_HEADER_SIZE = 80
+	// bits of the lowest difficulty, difficulty 1 is the target of it
+	LOWEST_BITS = 0x1d00ffff
+)
+
+/*
+block header is 80 bytes:
+version(4, little endian) | previous block hash(32, little endian) |
+merkle root(32, little endian) | timestamp(4, little endian) |
+bits(4) | nonce(4)
+
+hash256 of the header should be less than the target encoded in bits,
+miners change the nonce (and the coinbase which changes the merkle root)
+until they find such a header, this is the proof of work
+*/
+type BlockHeader struct {
+	version    *big.Int
+	prevBlock  []byte // big endian as it is displayed
+	merkleRoot []byte // big endian as it is displayed
+	timestamp  *big.Int
+	bits       []byte // as it is serialized, the last byte is the exponent
+	nonce      []byte
+}
+
+func InitBlockHeader(version *big.Int, prevBlock []byte, merkleRoot []byte, timestamp *big.Int,
+	bits []byte, nonce []byte) *BlockHeader {
+	return &BlockHeader{
+		version:    version,
+		prevBlock:  prevBlock,
+		merkleRoot: merkleRoot,
+		timestamp:  timestamp,
+		bits:       bits,
+		nonce:      nonce,
+	}
+}
+
+func ParseBlockHeader(binary []byte) (*BlockHeader, error) {
+	return readBlockHeader(bufio.NewReader(bytes.NewReader(binary)))
+}
+
+func readBlockHeader(reader *bufio.Reader) (*BlockHeader, error) {
+	raw, err := tx.ReadBytes(reader, BLOCK_HEADER_SIZE)
+	if err != nil {
+		return nil, fmt.Errorf("read block header: %w", err)
+	}
+	return &BlockHeader{
+		version:    tx.LittleEndianToBigInt(raw[0:4], tx.LITTLE_ENDIAN_4_BYTES),
+		prevBlock:  tx.ReverseByteSlice(raw[4:36]),
+		merkleRoot: tx.ReverseByteSlice(raw[36:68]),
+		timestamp:  tx.LittleEndianToBigInt(raw[68:72], tx.LITTLE_ENDIAN_4_BYTES),
+		bits:       raw[72:76],
+		nonce:      raw[76:80],
+	}, nil
+}
+
+func (b *BlockHeader) Serialize() []byte {
+	result := make([]byte, 0, BLOCK_HEADER_SIZE)
+	result = append(result, tx.BigIntToLittleEndian(b.version, tx.LITTLE_ENDIAN_4_BYTES)...)
+	result = append(result, tx.ReverseByteSlice(b.prevBlock)...)
+	result = append(result, tx.ReverseByteSlice(b.merkleRoot)...)
+	result = append(result, tx.BigIntToLittleEndian(b.timestamp, tx.LITTLE_ENDIAN_4_BYTES)...)
+	result = append(result, b.bits...)
+	result = append(result, b.nonce...)
+	return result
+}
+
+func (b *BlockHeader) Hash() []byte {
+	// hash256 of the header, displayed in big endian like transaction id
+	return tx.ReverseByteSlice(ecc.Hash256(string(b.Serialize())))
+}
+
+func (b *BlockHeader) ID() string {
+	return fmt.Sprintf("%x", b.Hash())
+}
+
+func (b *BlockHeader) String() string {
+	return fmt.Sprintf("block: %s\n version: %v\n previous block: %x\n merkle root: %x\n timestamp: %v\n bits: %x\n nonce: %x\n",
+		b.ID(), b.version, b.prevBlock, b.merkleRoot, b.timestamp, b.bits, b.nonce)
+}
+
+func (b *BlockHeader) Version() *big.Int {
+	return b.version
+}
+
+func (b *BlockHeader) PrevBlock() []byte {
+	return b.prevBlock
+}
+
+func (b *BlockHeader) MerkleRoot() []byte {
+	return b.merkleRoot
+}
+
+func (b *BlockHeader) Timestamp() *big.Int {
+	return b.timestamp
+}
+
+func (b *BlockHeader) Bits() []byte {
+	return b.bits
+}
+
+func (b *BlockHeader) Nonce() []byte {
+	return b.nonce
+}
+
+func BitsToTarget(bits []byte) (*big.Int, error) {
+	/*
+		bits is a compact form of 256 bits target, the last byte is the
+		exponent and the first three bytes are the coefficient in little
+		endian:
+		target = coefficient * 256^(exponent - 3)
+		bit 0x800000 of the coefficient is the sign, target can't be
+		negative, and it should be less than 2^256
+	*/
+	if len(bits) != 4 {
+		return nil, fmt.Errorf("%w: bits length %d", ErrInvalidBits, len(bits))
+	}
+	exponent := int(bits[3])
+	coefficient := new(big.Int).SetBytes([]byte{bits[2], bits[1], bits[0]})
+	if bits[2]&0x80 != 0 {
+		if coefficient.Cmp(big.NewInt(0x800000)) != 0 {
+			return nil, fmt.Errorf("%w: negative target %x", ErrInvalidBits, bits)
+		}
+		// only the sign bit, the target is zero
+		coefficient.SetInt64(0)
+	}
+
+	target := new(big.Int)
+	if exponent <= 3 {
+		target.Rsh(coefficient, uint(8*(3-exponent)))
+	} else {
+		target.Lsh(coefficient, uint(8*(exponent-3)))
+	}
+	if target.BitLen() > 256 {
+		return nil, fmt.Errorf("%w: target overflow %x", ErrInvalidBits, bits)
+	}
+	return target, nil
+}
+
+func TargetToBits(target *big.Int) []byte {
+	/*
+		exponent is the length of the target in bytes, coefficient is the
+		first three bytes, if the first byte >= 0x80 it looks like the sign
+		bit, then we put a zero byte at the head and the exponent is one more
+	*/
+	raw := target.Bytes()
+	exponent := len(raw)
+	var coefficient []byte
+	if len(raw) > 0 && raw[0] >= 0x80 {
+		exponent += 1
+		coefficient = append([]byte{0x00}, raw...)
+	} else {
+		coefficient = raw
+	}
+	// pad or cut to three bytes, lower bytes are lost
+	coefficient = append(coefficient, 0x00, 0x00, 0x00)[0:3]
+	return []byte{coefficient[2], coefficient[1], coefficient[0], byte(exponent)}
+}
+
+func (b *BlockHeader) Target() (*big.Int, error) {
+	return BitsToTarget(b.bits)
+}
+
+func (b *BlockHeader) Difficulty() float64 {
+	/*
+		how many times harder than the lowest difficulty(bits 0x1d00ffff):
+		difficulty = 0xffff * 256^(0x1d - 3) / target
+	*/
+	target, err := b.Target()
+	if err != nil || target.Sign() == 0 {
+		return 0
+	}
+	lowest, _ := BitsToTarget(bitsFromUint32(LOWEST_BITS))
+	difficulty, _ := new(big.Float).Quo(new(big.Float).SetInt(lowest), new(big.Float).SetInt(target)).Float64()
+	return difficulty
+}
+
+func bitsFromUint32(bits uint32) []byte {
+	// 0x1d00ffff -> ffff001d as it is serialized
+	return []byte{byte(bits), byte(bits >> 8), byte(bits >> 16), byte(bits >> 24)}
+}
+
+func (b *BlockHeader) CheckProofOfWork() bool {
+	/*
+		hash256 of the header as a little endian number should be less than
+		or equal to the target, a zero target can't be met by any header
+	*/
+	target, err := b.Target()
+	if err != nil || target.Sign() == 0 {
+		return false
+	}
+	proof := new(big.Int).SetBytes(b.Hash())
+	return proof.Cmp(target) <= 0
+}
@@ -0,0 +1,102 @@
+package block
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	BOOK_HEADER    = "020000208ec39428b17323fa0ddec8e887b4a7c53b8c0a0a220cfd0000000000000000005b0750fce0a889502d40508d39576821155e9c9e3f5c3157f961db38fd8b25be1e77a759e93c0118a4ffd71d"
+	GENESIS_HEADER = "0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d1dac2b7c"
+)
+
+func TestParseBlockHeader(t *testing.T) {
+	raw, _ := hex.DecodeString(BOOK_HEADER)
+	header, err := ParseBlockHeader(raw)
+	assert.Nil(t, err)
+	fmt.Printf("%s", header)
+
+	assert.Equal(t, big.NewInt(0x20000002), header.Version())
+	assert.Equal(t, "000000000000000000fd0c220a0a8c3bc5a7b487e8c8de0dfa2373b12894c38e", fmt.Sprintf("%x", header.PrevBlock()))
+	assert.Equal(t, "be258bfd38db61f957315c3f9e9c5e15216857398d50402d5089a8e0fc50075b", fmt.Sprintf("%x", header.MerkleRoot()))
+	assert.Equal(t, big.NewInt(0x59a7771e), header.Timestamp())
+	assert.Equal(t, "e93c0118", fmt.Sprintf("%x", header.Bits()))
+	assert.Equal(t, "a4ffd71d", fmt.Sprintf("%x", header.Nonce()))
+
+	assert.Equal(t, BOOK_HEADER, fmt.Sprintf("%x", header.Serialize()))
+	assert.Equal(t, "0000000000000000007e9e4c586439b0cdbe13b1370bdd9435d76a644d047523", header.ID())
+
+	genesis, _ := hex.DecodeString(GENESIS_HEADER)
+	header, err = ParseBlockHeader(genesis)
+	assert.Nil(t, err)
+	assert.Equal(t, "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f", header.ID())
+	assert.Equal(t, GENESIS_HEADER, fmt.Sprintf("%x", header.Serialize()))
+
+	_, err = ParseBlockHeader(raw[0:79])
+	assert.True(t, errors.Is(err, ErrTruncatedInput))
+}
+
+func TestBitsToTarget(t *testing.T) {
+	bits, _ := hex.DecodeString("e93c0118")
+	target, err := BitsToTarget(bits)
+	assert.Nil(t, err)
+	assert.Equal(t, "0000000000000000013ce9000000000000000000000000000000000000000000", fmt.Sprintf("%064x", target))
+	assert.Equal(t, bits, TargetToBits(target))
+
+	lowest, err := BitsToTarget(bitsFromUint32(LOWEST_BITS))
+	assert.Nil(t, err)
+	assert.Equal(t, "00000000ffff0000000000000000000000000000000000000000000000000000", fmt.Sprintf("%064x", lowest))
+	assert.Equal(t, bitsFromUint32(LOWEST_BITS), TargetToBits(lowest))
+
+	// the first byte 0x80 would be the sign bit, the coefficient is shifted
+	target, _ = new(big.Int).SetString("80000000000000000000000000000000000000000000000000", 16)
+	assert.Equal(t, "0080001a", fmt.Sprintf("%x", TargetToBits(target)))
+	back, err := BitsToTarget(TargetToBits(target))
+	assert.Nil(t, err)
+	assert.Equal(t, target, back)
+
+	// negative and overflowing targets
+	_, err = BitsToTarget(bitsFromUint32(0x04923456))
+	assert.True(t, errors.Is(err, ErrInvalidBits))
+	_, err = BitsToTarget(bitsFromUint32(0xff123456))
+	assert.True(t, errors.Is(err, ErrInvalidBits))
+	_, err = BitsToTarget([]byte{0xff, 0xff})
+	assert.True(t, errors.Is(err, ErrInvalidBits))
+
+	// small exponent drops the lower bytes of the coefficient
+	target, err = BitsToTarget(bitsFromUint32(0x02123456))
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(0x1234), target)
+}
+
+func TestDifficulty(t *testing.T) {
+	raw, _ := hex.DecodeString(BOOK_HEADER)
+	header, _ := ParseBlockHeader(raw)
+	difficulty := header.Difficulty()
+	fmt.Printf("difficulty: %v\n", difficulty)
+	assert.InDelta(t, 888171856257.3206, difficulty, 0.001)
+
+	genesis, _ := hex.DecodeString(GENESIS_HEADER)
+	header, _ = ParseBlockHeader(genesis)
+	assert.Equal(t, 1.0, header.Difficulty())
+}
+
+func TestCheckProofOfWork(t *testing.T) {
+	raw, _ := hex.DecodeString("04000000fbedbbf0cfdaf278c094f187f2eb987c86a199da22bbb20400000000000000007b7697b29129648fa08b4bcd13c9d5e60abb973a1efac9c8d573c71c807c56c3d6213557faa80518c3737ec1")
+	header, err := ParseBlockHeader(raw)
+	assert.Nil(t, err)
+	assert.True(t, header.CheckProofOfWork())
+
+	raw[len(raw)-1] = 0xc0
+	header, _ = ParseBlockHeader(raw)
+	assert.False(t, header.CheckProofOfWork())
+
+	genesis, _ := hex.DecodeString(GENESIS_HEADER)
+	header, _ = ParseBlockHeader(genesis)
+	assert.True(t, header.CheckProofOfWork())
+}
@@ -0,0 +1,154 @@
+package block
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	tx "transaction"
+)
+
+const (
+	// difficulty is adjusted every 2016 blocks, that is two weeks for 10 minutes a block
+	RETARGET_INTERVAL = 2016
+	TARGET_SPACING    = 10 * 60
+	TARGET_TIMESPAN   = 14 * 24 * 60 * 60
+	// the target can move at most 4 times in one adjustment
+	RETARGET_CLAMP = 4
+	// testnet block more than 20 minutes after the previous one can use the lowest difficulty
+	MIN_DIFFICULTY_SPACING = 2 * TARGET_SPACING
+	// easiest bits of regtest, about half of the hashes meet it
+	REGTEST_LOWEST_BITS = 0x207fffff
+)
+
+/*
+ChainParams are the rules of proof of work for a network:
+PowLimitBits is the easiest target allowed
+AllowMinDifficulty lets a block use PowLimitBits when it comes more than
+20 minutes after the previous one, it is the testnet rule
+NoRetargeting keeps the bits forever, it is the regtest rule
+*/
+type ChainParams struct {
+	PowLimitBits       []byte
+	AllowMinDifficulty bool
+	NoRetargeting      bool
+}
+
+func NetworkParams(network tx.Network) *ChainParams {
+	switch network {
+	case tx.TESTNET:
+		return &ChainParams{PowLimitBits: bitsFromUint32(LOWEST_BITS), AllowMinDifficulty: true}
+	case tx.REGTEST:
+		return &ChainParams{PowLimitBits: bitsFromUint32(REGTEST_LOWEST_BITS), AllowMinDifficulty: true, NoRetargeting: true}
+	}
+	return &ChainParams{PowLimitBits: bitsFromUint32(LOWEST_BITS)}
+}
+
+func (p *ChainParams) CalculateNewBits(previousBits []byte, timeDifferential int64) ([]byte, error) {
+	/*
+		time differential is how long the last period took, it is clamped
+		into [two weeks / 4, two weeks * 4], then:
+		new target = previous target * time differential / two weeks
+		and it can't be easier than the pow limit
+	*/
+	if timeDifferential > TARGET_TIMESPAN*RETARGET_CLAMP {
+		timeDifferential = TARGET_TIMESPAN * RETARGET_CLAMP
+	}
+	if timeDifferential < TARGET_TIMESPAN/RETARGET_CLAMP {
+		timeDifferential = TARGET_TIMESPAN / RETARGET_CLAMP
+	}
+	target, err := BitsToTarget(previousBits)
+	if err != nil {
+		return nil, err
+	}
+	powLimit, err := BitsToTarget(p.PowLimitBits)
+	if err != nil {
+		return nil, err
+	}
+	newTarget := new(big.Int).Mul(target, big.NewInt(timeDifferential))
+	newTarget.Div(newTarget, big.NewInt(TARGET_TIMESPAN))
+	if newTarget.Cmp(powLimit) > 0 {
+		newTarget = powLimit
+	}
+	return TargetToBits(newTarget), nil
+}
+
+func (p *ChainParams) NextWorkRequired(chain []*BlockHeader, lastHeight int64, timestamp int64) ([]byte, error) {
+	/*
+		bits of the block following the chain, chain is the consecutive
+		headers ending with the one at lastHeight, timestamp is the time of
+		the new block.
+
+		The first block of every period gets new bits, the time differential
+		is between the first and the last block of the previous period, that
+		is 2015 blocks instead of 2016, the off by one is in the consensus
+		since the beginning, so the chain should have at least the last 2016
+		headers for it. Other blocks keep the bits of the previous one.
+
+		On testnet a block can use the lowest difficulty 20 minutes after
+		the previous one, the blocks following it go back to the bits of the
+		last block which is not of the lowest difficulty, or the first block
+		of the period
+	*/
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("%w: empty chain", ErrInsufficientHeaders)
+	}
+	last := chain[len(chain)-1]
+
+	if (lastHeight+1)%RETARGET_INTERVAL != 0 {
+		if !p.AllowMinDifficulty {
+			return last.bits, nil
+		}
+		if timestamp > last.timestamp.Int64()+MIN_DIFFICULTY_SPACING {
+			return p.PowLimitBits, nil
+		}
+		idx, height := len(chain)-1, lastHeight
+		for idx > 0 && height%RETARGET_INTERVAL != 0 && bytes.Equal(chain[idx].bits, p.PowLimitBits) {
+			idx, height = idx-1, height-1
+		}
+		// the chain starts inside a run of lowest difficulty blocks, the bits before it are unknown
+		if height%RETARGET_INTERVAL != 0 && bytes.Equal(chain[idx].bits, p.PowLimitBits) {
+			return nil, fmt.Errorf("%w: lowest difficulty blocks go back beyond the first header at height %d",
+				ErrInsufficientHeaders, height)
+		}
+		return chain[idx].bits, nil
+	}
+
+	if p.NoRetargeting {
+		return last.bits, nil
+	}
+	firstIdx := len(chain) - RETARGET_INTERVAL
+	if firstIdx < 0 {
+		return nil, fmt.Errorf("%w: retarget at height %d needs %d headers but got %d",
+			ErrInsufficientHeaders, lastHeight+1, RETARGET_INTERVAL, len(chain))
+	}
+	first := chain[firstIdx]
+	return p.CalculateNewBits(last.bits, last.timestamp.Int64()-first.timestamp.Int64())
+}
+
+func (p *ChainParams) ValidateHeaderChain(headers []*BlockHeader, firstHeight int64) error {
+	/*
+		the first header is trusted, like a checkpoint, every following one
+		should link to the previous header, have the bits required by the
+		chain before it, and meet the target of its bits
+	*/
+	for i := 1; i < len(headers); i++ {
+		header := headers[i]
+		height := firstHeight + int64(i)
+		if !bytes.Equal(header.prevBlock, headers[i-1].Hash()) {
+			return fmt.Errorf("%w: header %s at height %d doesn't follow %s",
+				ErrBrokenChain, header.ID(), height, headers[i-1].ID())
+		}
+		bits, err := p.NextWorkRequired(headers[0:i], height-1, header.timestamp.Int64())
+		if err != nil {
+			return fmt.Errorf("header at height %d: %w", height, err)
+		}
+		if !bytes.Equal(header.bits, bits) {
+			return fmt.Errorf("%w: header %s at height %d has bits %x but should be %x",
+				ErrUnexpectedBits, header.ID(), height, header.bits, bits)
+		}
+		if !header.CheckProofOfWork() {
+			return fmt.Errorf("%w: header %s at height %d", ErrProofOfWork, header.ID(), height)
+		}
+	}
+	return nil
+}
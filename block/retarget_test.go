@@ -0,0 +1,147 @@
+package block
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	tx "transaction"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mineHeader(prev *BlockHeader, timestamp int64, bits []byte) *BlockHeader {
+	// easy bits only, the nonce is counted until the header meets the target
+	merkleRoot := make([]byte, 32)
+	for nonce := uint32(0); ; nonce++ {
+		header := InitBlockHeader(big.NewInt(0x20000000), prev.Hash(), merkleRoot, big.NewInt(timestamp),
+			bits, tx.BigIntToLittleEndian(big.NewInt(int64(nonce)), tx.LITTLE_ENDIAN_4_BYTES))
+		if header.CheckProofOfWork() {
+			return header
+		}
+	}
+}
+
+func TestCalculateNewBits(t *testing.T) {
+	first, _ := hex.DecodeString("000000203471101bbda3fe307664b3283a9ef0e97d9a38a7eacd8800000000000000000010c8aba8479bbaa5e0848152fd3c2289ca50e1c3e58c9a4faaafbdf5803c5448ddb845597e8b0118e43a81d3")
+	last, _ := hex.DecodeString("02000020f1472d9db4b563c35f97c428ac903f23b7fc055d1cfc26000000000000000000b3f449fcbe1bc4cfbcb8283a0d2c037f961a3fdf2b8bedc144973735eea707e1264258597e8b0118e5f00474")
+	firstHeader, _ := ParseBlockHeader(first)
+	lastHeader, _ := ParseBlockHeader(last)
+	timeDifferential := lastHeader.Timestamp().Int64() - firstHeader.Timestamp().Int64()
+
+	params := NetworkParams(tx.MAINNET)
+	bits, err := params.CalculateNewBits(lastHeader.Bits(), timeDifferential)
+	assert.Nil(t, err)
+	assert.Equal(t, "308d0118", fmt.Sprintf("%x", bits))
+
+	// clamped to 4 times in both directions
+	bits, _ = params.CalculateNewBits(bitsFromUint32(0x1b0404cb), TARGET_TIMESPAN*10)
+	assert.Equal(t, bitsFromUint32(0x1b10132c), bits)
+	bits, _ = params.CalculateNewBits(bitsFromUint32(0x1b0404cb), 1)
+	assert.Equal(t, bitsFromUint32(0x1b010132), bits)
+
+	// never easier than the pow limit
+	bits, _ = params.CalculateNewBits(bitsFromUint32(LOWEST_BITS), TARGET_TIMESPAN*2)
+	assert.Equal(t, bitsFromUint32(LOWEST_BITS), bits)
+}
+
+func TestRetarget(t *testing.T) {
+	// retarget rules with an easy pow limit so that headers can be mined in the test
+	params := &ChainParams{PowLimitBits: bitsFromUint32(REGTEST_LOWEST_BITS)}
+	genesis, _ := hex.DecodeString(GENESIS_HEADER)
+	first, _ := ParseBlockHeader(genesis)
+	first.bits = params.PowLimitBits
+
+	// blocks of 150 seconds, 2015 of them take less than two weeks / 4
+	chain := []*BlockHeader{first}
+	timestamp := first.Timestamp().Int64()
+	for height := int64(1); height < RETARGET_INTERVAL; height++ {
+		timestamp += 150
+		bits, err := params.NextWorkRequired(chain, height-1, timestamp)
+		assert.Nil(t, err)
+		chain = append(chain, mineHeader(chain[len(chain)-1], timestamp, bits))
+	}
+	bits, err := params.NextWorkRequired(chain, RETARGET_INTERVAL-1, timestamp+150)
+	assert.Nil(t, err)
+	assert.Equal(t, bitsFromUint32(0x201fffff), bits)
+	chain = append(chain, mineHeader(chain[len(chain)-1], timestamp+150, bits))
+	assert.Nil(t, params.ValidateHeaderChain(chain, 0))
+
+	// off by one, the 2016 blocks from the first one are needed, not 2017
+	_, err = params.NextWorkRequired(chain[1:RETARGET_INTERVAL], RETARGET_INTERVAL-1, timestamp+150)
+	assert.True(t, errors.Is(err, ErrInsufficientHeaders))
+
+	// the first block of the period keeps the old bits
+	wrong := mineHeader(chain[RETARGET_INTERVAL-1], timestamp+150, params.PowLimitBits)
+	err = params.ValidateHeaderChain(append(chain[0:RETARGET_INTERVAL:RETARGET_INTERVAL], wrong), 0)
+	assert.True(t, errors.Is(err, ErrUnexpectedBits))
+
+	// the chain is broken
+	err = params.ValidateHeaderChain([]*BlockHeader{chain[0], chain[2]}, 0)
+	assert.True(t, errors.Is(err, ErrBrokenChain))
+
+	// no retargeting on regtest
+	bits, err = NetworkParams(tx.REGTEST).NextWorkRequired(chain[0:RETARGET_INTERVAL], RETARGET_INTERVAL-1, timestamp)
+	assert.Nil(t, err)
+	assert.Equal(t, params.PowLimitBits, bits)
+}
+
+func TestMinDifficulty(t *testing.T) {
+	// testnet rule with an easy pow limit so that headers can be mined in the test
+	params := &ChainParams{PowLimitBits: bitsFromUint32(REGTEST_LOWEST_BITS), AllowMinDifficulty: true}
+	normalBits := bitsFromUint32(0x201fffff)
+	genesis, _ := hex.DecodeString(GENESIS_HEADER)
+	first, _ := ParseBlockHeader(genesis)
+	first.bits = normalBits
+	timestamp := first.Timestamp().Int64()
+
+	// more than 20 minutes later, the lowest difficulty is allowed
+	second := mineHeader(first, timestamp+MIN_DIFFICULTY_SPACING+1, params.PowLimitBits)
+	// then the bits of the last block not of the lowest difficulty
+	third := mineHeader(second, timestamp+MIN_DIFFICULTY_SPACING+60, normalBits)
+	assert.Nil(t, params.ValidateHeaderChain([]*BlockHeader{first, second, third}, 1))
+
+	// exactly 20 minutes is not enough
+	wrong := mineHeader(first, timestamp+MIN_DIFFICULTY_SPACING, params.PowLimitBits)
+	err := params.ValidateHeaderChain([]*BlockHeader{first, wrong}, 1)
+	assert.True(t, errors.Is(err, ErrUnexpectedBits))
+
+	wrong = mineHeader(second, timestamp+MIN_DIFFICULTY_SPACING+60, params.PowLimitBits)
+	err = params.ValidateHeaderChain([]*BlockHeader{first, second, wrong}, 1)
+	assert.True(t, errors.Is(err, ErrUnexpectedBits))
+
+	// mainnet doesn't have the rule
+	mainnet := &ChainParams{PowLimitBits: params.PowLimitBits}
+	err = mainnet.ValidateHeaderChain([]*BlockHeader{first, second}, 1)
+	assert.True(t, errors.Is(err, ErrUnexpectedBits))
+}
+
+func TestMinDifficultyWalkBack(t *testing.T) {
+	/*
+		the bits to go back to are before the first header when it is of the
+		lowest difficulty and not the first block of a period
+	*/
+	params := &ChainParams{PowLimitBits: bitsFromUint32(REGTEST_LOWEST_BITS), AllowMinDifficulty: true}
+	genesis, _ := hex.DecodeString(GENESIS_HEADER)
+	first, _ := ParseBlockHeader(genesis)
+	first.bits = params.PowLimitBits
+	timestamp := first.Timestamp().Int64()
+	second := mineHeader(first, timestamp+MIN_DIFFICULTY_SPACING+1, params.PowLimitBits)
+
+	_, err := params.NextWorkRequired([]*BlockHeader{first, second}, 2, timestamp+MIN_DIFFICULTY_SPACING+60)
+	assert.True(t, errors.Is(err, ErrInsufficientHeaders))
+	third := mineHeader(second, timestamp+MIN_DIFFICULTY_SPACING+60, params.PowLimitBits)
+	err = params.ValidateHeaderChain([]*BlockHeader{first, second, third}, 1)
+	assert.True(t, errors.Is(err, ErrInsufficientHeaders))
+
+	// the first block of a period is where the walk stops
+	bits, err := params.NextWorkRequired([]*BlockHeader{first, second}, RETARGET_INTERVAL+1, timestamp+MIN_DIFFICULTY_SPACING+60)
+	assert.Nil(t, err)
+	assert.Equal(t, params.PowLimitBits, bits)
+
+	// lowest difficulty is still allowed 20 minutes later
+	bits, err = params.NextWorkRequired([]*BlockHeader{first, second}, 2, timestamp+2*MIN_DIFFICULTY_SPACING+2)
+	assert.Nil(t, err)
+	assert.Equal(t, params.PowLimitBits, bits)
+}
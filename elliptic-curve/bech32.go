@@ -0,0 +1,322 @@
+package elliptic_curve
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+bech32(BIP173) encodes segwit address as
+human readable part | '1' | data in 5 bits characters | 6 characters checksum
+
+the checksum is a BCH code, it guarantees to detect any error affecting at
+most 4 characters, bech32m(BIP350) changes the constant of the checksum,
+it is used for witness version 1 and above, version 0 keeps bech32
+*/
+type Bech32Encoding int
+
+const (
+	BECH32 Bech32Encoding = iota + 1
+	BECH32M
+)
+
+const (
+	BECH32_ALPHABET   = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	BECH32_CONST      = 1
+	BECH32M_CONST     = 0x2bc830a3
+	BECH32_MAX_LENGTH = 90
+	BECH32_CHECKSUM   = 6
+
+	// human readable part of segwit address for each network
+	BECH32_MAINNET_HRP = "bc"
+	BECH32_TESTNET_HRP = "tb"
+	BECH32_REGTEST_HRP = "bcrt"
+)
+
+func (e Bech32Encoding) String() string {
+	if e == BECH32M {
+		return "bech32m"
+	}
+	return "bech32"
+}
+
+func (e Bech32Encoding) checksumConst() uint32 {
+	if e == BECH32M {
+		return BECH32M_CONST
+	}
+	return BECH32_CONST
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generator := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HrpExpand(hrp string) []byte {
+	// high bits of each character, zero, then low bits of each character
+	result := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]>>5)
+	}
+	result = append(result, 0)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]&31)
+	}
+	return result
+}
+
+func bech32Checksum(hrp string, data []byte, encoding Bech32Encoding) []byte {
+	values := append(bech32HrpExpand(hrp), data...)
+	values = append(values, make([]byte, BECH32_CHECKSUM)...)
+	polymod := bech32Polymod(values) ^ encoding.checksumConst()
+	checksum := make([]byte, BECH32_CHECKSUM)
+	for i := 0; i < BECH32_CHECKSUM; i++ {
+		checksum[i] = byte((polymod >> (5 * (5 - i))) & 31)
+	}
+	return checksum
+}
+
+func bech32VerifyChecksum(hrp string, data []byte) Bech32Encoding {
+	// returns which encoding the checksum matches, 0 for none of them
+	switch bech32Polymod(append(bech32HrpExpand(hrp), data...)) {
+	case BECH32_CONST:
+		return BECH32
+	case BECH32M_CONST:
+		return BECH32M
+	}
+	return 0
+}
+
+func Bech32Encode(hrp string, data []byte, encoding Bech32Encoding) (string, error) {
+	// data is in 5 bits groups, the result is in lower case
+	hrp = strings.ToLower(hrp)
+	if len(hrp) == 0 || len(hrp)+1+len(data)+BECH32_CHECKSUM > BECH32_MAX_LENGTH {
+		return "", fmt.Errorf("%w: length out of range", ErrInvalidBech32)
+	}
+	var builder strings.Builder
+	builder.WriteString(hrp)
+	builder.WriteByte('1')
+	for _, v := range append(data, bech32Checksum(hrp, data, encoding)...) {
+		if v >= 32 {
+			return "", fmt.Errorf("%w: data value %d is more than 5 bits", ErrInvalidBech32, v)
+		}
+		builder.WriteByte(BECH32_ALPHABET[v])
+	}
+	return builder.String(), nil
+}
+
+func Bech32Decode(s string) (string, []byte, Bech32Encoding, error) {
+	/*
+		returns human readable part, data without checksum and the encoding
+		of the checksum, if the checksum is wrong, the error tells where the
+		mistyped characters probably are
+	*/
+	if len(s) > BECH32_MAX_LENGTH {
+		return "", nil, 0, fmt.Errorf("%w: length %d is more than %d", ErrInvalidBech32, len(s), BECH32_MAX_LENGTH)
+	}
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, 0, fmt.Errorf("%w: mixed case", ErrInvalidBech32)
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 33 || s[i] > 126 {
+			return "", nil, 0, fmt.Errorf("%w: invalid character at position %d", ErrInvalidBech32, i)
+		}
+	}
+	s = strings.ToLower(s)
+	// the last '1' is the separator, human readable part can contain '1'
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 {
+		return "", nil, 0, fmt.Errorf("%w: no separator or empty human readable part", ErrInvalidBech32)
+	}
+	if pos+BECH32_CHECKSUM+1 > len(s) {
+		return "", nil, 0, fmt.Errorf("%w: data part is too short", ErrInvalidBech32)
+	}
+	hrp := s[0:pos]
+	data := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(s); i++ {
+		idx := strings.IndexByte(BECH32_ALPHABET, s[i])
+		if idx == -1 {
+			return "", nil, 0, fmt.Errorf("%w: character %q at position %d is not in the alphabet",
+				ErrInvalidBech32, s[i], i)
+		}
+		data = append(data, byte(idx))
+	}
+
+	encoding := bech32VerifyChecksum(hrp, data)
+	if encoding == 0 {
+		positions := Bech32ErrorPositions(s)
+		if len(positions) > 0 {
+			return "", nil, 0, fmt.Errorf("%w: probably mistyped character at position %v",
+				ErrBech32Checksum, positions)
+		}
+		return "", nil, 0, ErrBech32Checksum
+	}
+	return hrp, data[0 : len(data)-BECH32_CHECKSUM], encoding, nil
+}
+
+func Bech32ErrorPositions(s string) []int {
+	/*
+		find the characters which make the checksum valid when they are
+		replaced, one mistyped character is always found as BCH code corrects
+		one error, only positions in the data part are checked. Don't fix the
+		address by this, ask the user to check the characters instead
+	*/
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || len(s) > BECH32_MAX_LENGTH {
+		return nil
+	}
+	hrp := s[0:pos]
+	data := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(s); i++ {
+		idx := strings.IndexByte(BECH32_ALPHABET, s[i])
+		if idx == -1 {
+			return []int{i}
+		}
+		data = append(data, byte(idx))
+	}
+
+	positions := make([]int, 0)
+	for i := range data {
+		original := data[i]
+		for v := byte(0); v < 32; v++ {
+			if v == original {
+				continue
+			}
+			data[i] = v
+			if bech32VerifyChecksum(hrp, data) != 0 {
+				positions = append(positions, pos+1+i)
+				break
+			}
+		}
+		data[i] = original
+	}
+	return positions
+}
+
+func ConvertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	/*
+		regroup the bits, 8 bits bytes into 5 bits groups for encoding and
+		back for decoding, when decoding the padding should be less than
+		fromBits and all zeros
+	*/
+	acc := uint32(0)
+	bits := uint(0)
+	maxValue := uint32(1)<<toBits - 1
+	result := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+	for _, v := range data {
+		if uint32(v)>>fromBits != 0 {
+			return nil, fmt.Errorf("%w: value %d is more than %d bits", ErrInvalidBech32, v, fromBits)
+		}
+		acc = acc<<fromBits | uint32(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			result = append(result, byte((acc>>bits)&maxValue))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			result = append(result, byte((acc<<(toBits-bits))&maxValue))
+		}
+	} else if bits >= fromBits || (acc<<(toBits-bits))&maxValue != 0 {
+		return nil, fmt.Errorf("%w: invalid padding", ErrInvalidBech32)
+	}
+	return result, nil
+}
+
+func EncodeSegwitAddress(hrp string, version byte, program []byte) (string, error) {
+	/*
+		the first data character is the witness version, the program is
+		converted to 5 bits groups, version 0 uses bech32 and others bech32m
+	*/
+	if version > 16 {
+		return "", fmt.Errorf("%w: witness version %d", ErrInvalidSegwitAddress, version)
+	}
+	if err := checkWitnessProgram(version, program); err != nil {
+		return "", err
+	}
+	data, err := ConvertBits(program, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	encoding := BECH32M
+	if version == 0 {
+		encoding = BECH32
+	}
+	return Bech32Encode(hrp, append([]byte{version}, data...), encoding)
+}
+
+func DecodeSegwitAddress(address string) (string, byte, []byte, error) {
+	// returns human readable part, witness version and witness program
+	hrp, data, encoding, err := Bech32Decode(address)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if len(data) == 0 {
+		return "", 0, nil, fmt.Errorf("%w: no witness version", ErrInvalidSegwitAddress)
+	}
+	version := data[0]
+	if version > 16 {
+		return "", 0, nil, fmt.Errorf("%w: witness version %d", ErrInvalidSegwitAddress, version)
+	}
+	if version == 0 && encoding != BECH32 {
+		return "", 0, nil, fmt.Errorf("%w: witness version 0 should use bech32, not %s",
+			ErrInvalidSegwitAddress, encoding)
+	}
+	if version != 0 && encoding != BECH32M {
+		return "", 0, nil, fmt.Errorf("%w: witness version %d should use bech32m, not %s",
+			ErrInvalidSegwitAddress, version, encoding)
+	}
+	program, err := ConvertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if err := checkWitnessProgram(version, program); err != nil {
+		return "", 0, nil, err
+	}
+	return hrp, version, program, nil
+}
+
+func checkWitnessProgram(version byte, program []byte) error {
+	// version 0 program is 20 bytes for p2wpkh or 32 bytes for p2wsh
+	if len(program) < 2 || len(program) > 40 {
+		return fmt.Errorf("%w: program length %d", ErrInvalidSegwitAddress, len(program))
+	}
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		return fmt.Errorf("%w: version 0 program length %d", ErrInvalidSegwitAddress, len(program))
+	}
+	return nil
+}
+
+func (p *Point) P2wpkhAddress(hrp string) string {
+	// witness version 0 with hash160 of the compressed public key
+	address, err := EncodeSegwitAddress(hrp, 0, p.hash160(true))
+	if err != nil {
+		panic(fmt.Sprintf("p2wpkh address err: %v", err))
+	}
+	return address
+}
+
+func (p *Point) P2trAddress(hrp string) (string, error) {
+	/*
+		witness version 1 with the output key tweaked by the public key
+		itself, it can only be spent by the key path, see TaprootOutputKey
+		in transaction for output with scripts
+	*/
+	Q, err := TweakPublicKey(p, TaggedHash("TapTweak", p.XOnly()))
+	if err != nil {
+		return "", err
+	}
+	return EncodeSegwitAddress(hrp, 1, Q.XOnly())
+}
@@ -0,0 +1,119 @@
+package elliptic_curve
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBech32Checksum(t *testing.T) {
+	// valid strings from BIP173 and BIP350
+	for _, s := range []string{"A12UEL5L", "an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"11" + strings.Repeat("q", 82) + "c8247j"} {
+		_, _, encoding, err := Bech32Decode(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, BECH32, encoding)
+	}
+	for _, s := range []string{"A1LQFN3A", "abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx",
+		"split1checkupstagehandshakeupstreamerranterredcaperredlc445v"} {
+		_, _, encoding, err := Bech32Decode(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, BECH32M, encoding)
+	}
+
+	// invalid strings
+	for _, s := range []string{"pzry9x0s0muk", "1pzry9x0s0muk", "x1b4n0q5v", "li1dgmt3", "A1G7SGD8", "10a06t8", "1qzzfhee",
+		"an84characterslonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1569pvx"} {
+		_, _, _, err := Bech32Decode(s)
+		assert.NotNil(t, err, s)
+		fmt.Printf("%s: %v\n", s, err)
+	}
+
+	hrp, data, _, err := Bech32Decode("A12UEL5L")
+	assert.Nil(t, err)
+	encoded, err := Bech32Encode(hrp, data, BECH32)
+	assert.Nil(t, err)
+	assert.Equal(t, "a12uel5l", encoded)
+}
+
+func TestSegwitAddress(t *testing.T) {
+	vectors := []struct {
+		address      string
+		scriptPubKey string
+	}{
+		{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7",
+			"00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{"bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y",
+			"5128751e76e8199196d454941c45d1b3a323f1433bd6751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"BC1SW50QGDZ25J", "6002751e"},
+		{"tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c",
+			"5120000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0",
+			"512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+	}
+	for _, vector := range vectors {
+		hrp, version, program, err := DecodeSegwitAddress(vector.address)
+		assert.Nil(t, err, vector.address)
+		scriptPubKey, _ := hex.DecodeString(vector.scriptPubKey)
+		assert.Equal(t, scriptPubKey[2:], program)
+		if version == 0 {
+			assert.Equal(t, byte(0), scriptPubKey[0])
+		} else {
+			assert.Equal(t, version+0x50, scriptPubKey[0])
+		}
+		encoded, err := EncodeSegwitAddress(hrp, version, program)
+		assert.Nil(t, err)
+		assert.Equal(t, strings.ToLower(vector.address), encoded)
+	}
+
+	// invalid addresses from BIP350, the network is checked by the caller
+	for _, address := range []string{
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd",
+		"BC1S0XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ54WELL",
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh",
+		"bc1p38j9r5y49hruaue7wxjce0updqjuyyx0kh56v8s25huc6995vvpql3jow4",
+		"BC130XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ7ZWS8R",
+		"bc1pw5dgrnzv",
+		"BC1QR508D6QEJXTDG4Y5R3ZARVARYV98GJ9P",
+		"bc1zw508d6qejxtdg4y5r3zarvaryvqyzf3du",
+		"bc1gmk9yu",
+	} {
+		_, _, _, err := DecodeSegwitAddress(address)
+		assert.NotNil(t, err, address)
+		fmt.Printf("%s: %v\n", address, err)
+	}
+	_, _, _, err := DecodeSegwitAddress("bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd")
+	assert.ErrorIs(t, err, ErrInvalidSegwitAddress)
+}
+
+func TestBech32ErrorPositions(t *testing.T) {
+	address := "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"
+	typo := []byte(address)
+	typo[10] = 'x'
+	_, _, _, err := Bech32Decode(string(typo))
+	assert.ErrorIs(t, err, ErrBech32Checksum)
+	fmt.Printf("%s: %v\n", typo, err)
+	assert.Equal(t, []int{10}, Bech32ErrorPositions(string(typo)))
+	assert.Equal(t, []int{}, Bech32ErrorPositions(address))
+}
+
+func TestPublicKeySegwitAddress(t *testing.T) {
+	// BIP173 example with the public key of secret 1
+	G := NewPrivateKey(big.NewInt(1)).GetPublicKey()
+	assert.Equal(t, "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", G.P2wpkhAddress(BECH32_MAINNET_HRP))
+	assert.Equal(t, "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", G.P2wpkhAddress(BECH32_TESTNET_HRP))
+	fmt.Printf("regtest address: %s\n", G.P2wpkhAddress(BECH32_REGTEST_HRP))
+
+	// BIP86 first receiving address of the test mnemonic
+	internalKey, _ := hex.DecodeString("cc8a4bc64d897bddc5fbc2f670f7a8ba0b386779106cf1223c6fc5d7cd6fc115")
+	P, err := ParseXOnly(internalKey)
+	assert.Nil(t, err)
+	address, err := P.P2trAddress(BECH32_MAINNET_HRP)
+	assert.Nil(t, err)
+	assert.Equal(t, "bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr", address)
+}
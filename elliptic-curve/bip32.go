@@ -0,0 +1,357 @@
+package elliptic_curve
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+/*
+BIP32 hierarchical deterministic keys, every key has a chain code, and
+the child keys are derived from the parent key and the chain code:
+
+	I = HMAC-SHA512(chain code, parent public key || index)
+	child private key = parent private key + I[0:32]
+	child public key = parent public key + I[0:32] * G
+	child chain code = I[32:64]
+
+then the child public keys can be derived from the parent public key
+without knowing any private key. For index >= 2^31 (hardened), the parent
+private key is used in place of the public key, the child can only be
+derived from the private key.
+*/
+type ExtendedKey struct {
+	version           uint32
+	depth             byte
+	parentFingerprint []byte
+	childNumber       uint32
+	chainCode         []byte
+	privateKey        *PrivateKey // nil for extended public key
+	publicKey         *Point
+}
+
+const (
+	// version bytes of serialized extended keys, BIP32, BIP49, BIP84 and SLIP132
+	XPRV_VERSION = 0x0488ade4
+	XPUB_VERSION = 0x0488b21e
+	TPRV_VERSION = 0x04358394
+	TPUB_VERSION = 0x043587cf
+	YPRV_VERSION = 0x049d7878
+	YPUB_VERSION = 0x049d7cb2
+	UPRV_VERSION = 0x044a4e28
+	UPUB_VERSION = 0x044a5262
+	ZPRV_VERSION = 0x04b2430c
+	ZPUB_VERSION = 0x04b24746
+	VPRV_VERSION = 0x045f18bc
+	VPUB_VERSION = 0x045f1cf6
+
+	HARDENED_KEY_START    = 0x80000000
+	EXTENDED_KEY_LENGTH   = 78
+	BIP32_SEED_KEY        = "Bitcoin seed"
+	BIP32_MIN_SEED_LENGTH = 16
+	BIP32_MAX_SEED_LENGTH = 64
+)
+
+// private version to the public version of the same kind
+var extendedKeyVersions = map[uint32]uint32{
+	XPRV_VERSION: XPUB_VERSION,
+	TPRV_VERSION: TPUB_VERSION,
+	YPRV_VERSION: YPUB_VERSION,
+	UPRV_VERSION: UPUB_VERSION,
+	ZPRV_VERSION: ZPUB_VERSION,
+	VPRV_VERSION: VPUB_VERSION,
+}
+
+func isPrivateVersion(version uint32) bool {
+	_, ok := extendedKeyVersions[version]
+	return ok
+}
+
+func isPublicVersion(version uint32) bool {
+	for _, public := range extendedKeyVersions {
+		if public == version {
+			return true
+		}
+	}
+	return false
+}
+
+func NewMasterKey(seed []byte, version uint32) (*ExtendedKey, error) {
+	/*
+		I = HMAC-SHA512("Bitcoin seed", seed), the left half is the master
+		private key and the right half is the chain code, version should be
+		a private one like XPRV_VERSION
+	*/
+	if len(seed) < BIP32_MIN_SEED_LENGTH || len(seed) > BIP32_MAX_SEED_LENGTH {
+		return nil, fmt.Errorf("seed should be %d to %d bytes, got %d",
+			BIP32_MIN_SEED_LENGTH, BIP32_MAX_SEED_LENGTH, len(seed))
+	}
+	if !isPrivateVersion(version) {
+		return nil, fmt.Errorf("%w: %08x is not private version", ErrInvalidExtendedKey, version)
+	}
+	mac := hmac.New(sha512.New, []byte(BIP32_SEED_KEY))
+	mac.Write(seed)
+	I := mac.Sum(nil)
+	privateKey, err := NewPrivateKeyFromBytes(I[0:32])
+	if err != nil {
+		return nil, fmt.Errorf("%w: master key from this seed", ErrInvalidChild)
+	}
+	return &ExtendedKey{
+		version:           version,
+		depth:             0,
+		parentFingerprint: make([]byte, 4),
+		childNumber:       0,
+		chainCode:         I[32:64],
+		privateKey:        privateKey,
+		publicKey:         privateKey.GetPublicKey(),
+	}, nil
+}
+
+func (k *ExtendedKey) IsPrivate() bool {
+	return k.privateKey != nil
+}
+
+func (k *ExtendedKey) Depth() byte {
+	return k.depth
+}
+
+func (k *ExtendedKey) ChildNumber() uint32 {
+	return k.childNumber
+}
+
+func (k *ExtendedKey) ChainCode() []byte {
+	return k.chainCode
+}
+
+func (k *ExtendedKey) PrivateKey() (*PrivateKey, error) {
+	if k.privateKey == nil {
+		return nil, fmt.Errorf("%w: no private key in extended public key", ErrInvalidExtendedKey)
+	}
+	return k.privateKey, nil
+}
+
+func (k *ExtendedKey) PublicKey() *Point {
+	return k.publicKey
+}
+
+func (k *ExtendedKey) Fingerprint() []byte {
+	// first 4 bytes of hash160 of the compressed public key
+	return k.publicKey.hash160(true)[0:4]
+}
+
+func (k *ExtendedKey) ParentFingerprint() []byte {
+	return k.parentFingerprint
+}
+
+func (k *ExtendedKey) Neuter() (*ExtendedKey, error) {
+	// extended public key with the same public key and chain code
+	if k.privateKey == nil {
+		return k, nil
+	}
+	version, ok := extendedKeyVersions[k.version]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown version %08x", ErrInvalidExtendedKey, k.version)
+	}
+	return &ExtendedKey{
+		version:           version,
+		depth:             k.depth,
+		parentFingerprint: k.parentFingerprint,
+		childNumber:       k.childNumber,
+		chainCode:         k.chainCode,
+		publicKey:         k.publicKey,
+	}, nil
+}
+
+func (k *ExtendedKey) WithVersion(version uint32) (*ExtendedKey, error) {
+	/*
+		the same key with other version bytes, like xpub to zpub, wallets
+		use the version to know the kind of addresses derived from the key
+	*/
+	if (k.privateKey != nil && !isPrivateVersion(version)) || (k.privateKey == nil && !isPublicVersion(version)) {
+		return nil, fmt.Errorf("%w: version %08x doesn't match the key", ErrInvalidExtendedKey, version)
+	}
+	result := *k
+	result.version = version
+	return &result, nil
+}
+
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	/*
+		ErrInvalidChild is returned when I[0:32] >= n or the child key is
+		zero or infinity, it happens with probability less than 1 in 2^127,
+		the caller should go on with the next index. Depth is one byte in the
+		serialization, key at depth 255 has no child
+	*/
+	if k.depth == 255 {
+		return nil, ErrMaxDepth
+	}
+	isHardened := index >= HARDENED_KEY_START
+	if isHardened && k.privateKey == nil {
+		return nil, ErrHardenedFromPublic
+	}
+
+	data := make([]byte, 0, 37)
+	if isHardened {
+		data = append(data, 0x00)
+		data = append(data, k.privateKey.secret.bytes()...)
+	} else {
+		_, sec := k.publicKey.Sec(true)
+		data = append(data, sec...)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	I := mac.Sum(nil)
+	n := GetBitcoinValueN()
+	tweak := new(big.Int).SetBytes(I[0:32])
+	if tweak.Cmp(n) >= 0 {
+		return nil, ErrInvalidChild
+	}
+
+	child := &ExtendedKey{
+		version:           k.version,
+		depth:             k.depth + 1,
+		parentFingerprint: k.Fingerprint(),
+		childNumber:       index,
+		chainCode:         I[32:64],
+	}
+	if k.privateKey != nil {
+		// tweak is less than n, the sum is reduced by the constant time backend
+		secret := groupN.add(limbsFromBig(tweak), k.privateKey.secret)
+		if secret == (limbs{}) {
+			return nil, ErrInvalidChild
+		}
+		child.privateKey = newPrivateKeyFromLimbs(secret)
+		child.publicKey = child.privateKey.GetPublicKey()
+	} else {
+		child.publicKey = shamirMul(tweak, big.NewInt(1), k.publicKey)
+		if child.publicKey.x == nil {
+			return nil, ErrInvalidChild
+		}
+	}
+	return child, nil
+}
+
+func ParseDerivationPath(path string) ([]uint32, error) {
+	/*
+		m/84'/0'/0'/0/5, the index with ' or h is hardened, M is also
+		accepted at the beginning
+	*/
+	parts := strings.Split(strings.TrimSpace(path), "/")
+	if parts[0] != "m" && parts[0] != "M" {
+		return nil, fmt.Errorf("%w: %q should begin with m", ErrInvalidPath, path)
+	}
+	indexes := make([]uint32, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		hardened := false
+		if strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h") || strings.HasSuffix(part, "H") {
+			hardened = true
+			part = part[0 : len(part)-1]
+		}
+		// no sign or leading zero, the index is less than 2^31
+		if part == "" || (len(part) > 1 && part[0] == '0') || part[0] < '0' || part[0] > '9' {
+			return nil, fmt.Errorf("%w: bad index %q in %q", ErrInvalidPath, part, path)
+		}
+		index, err := strconv.ParseUint(part, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad index %q in %q", ErrInvalidPath, part, path)
+		}
+		if hardened {
+			index += HARDENED_KEY_START
+		}
+		indexes = append(indexes, uint32(index))
+	}
+	return indexes, nil
+}
+
+func (k *ExtendedKey) DerivePath(path string) (*ExtendedKey, error) {
+	// the path is relative to this key, "m" is the key itself
+	indexes, err := ParseDerivationPath(path)
+	if err != nil {
+		return nil, err
+	}
+	key := k
+	for _, index := range indexes {
+		key, err = key.Child(index)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+func (k *ExtendedKey) Serialize() []byte {
+	/*
+		version(4) | depth(1) | parent fingerprint(4) | child number(4) |
+		chain code(32) | 0x00 || private key or compressed public key(33)
+	*/
+	result := make([]byte, 0, EXTENDED_KEY_LENGTH)
+	result = binary.BigEndian.AppendUint32(result, k.version)
+	result = append(result, k.depth)
+	result = append(result, k.parentFingerprint...)
+	result = binary.BigEndian.AppendUint32(result, k.childNumber)
+	result = append(result, k.chainCode...)
+	if k.privateKey != nil {
+		result = append(result, 0x00)
+		result = append(result, k.privateKey.secret.bytes()...)
+	} else {
+		_, sec := k.publicKey.Sec(true)
+		result = append(result, sec...)
+	}
+	return result
+}
+
+func (k *ExtendedKey) String() string {
+	return Base58Checksum(k.Serialize())
+}
+
+func ParseExtendedKey(s string) (*ExtendedKey, error) {
+	prefix, payload, err := DecodeBase58Check(s)
+	if err != nil {
+		return nil, err
+	}
+	raw := append([]byte{prefix}, payload...)
+	if len(raw) != EXTENDED_KEY_LENGTH {
+		return nil, fmt.Errorf("%w: length %d", ErrInvalidExtendedKey, len(raw))
+	}
+
+	key := &ExtendedKey{
+		version:           binary.BigEndian.Uint32(raw[0:4]),
+		depth:             raw[4],
+		parentFingerprint: raw[5:9],
+		childNumber:       binary.BigEndian.Uint32(raw[9:13]),
+		chainCode:         raw[13:45],
+	}
+	if key.depth == 0 && (!bytes.Equal(key.parentFingerprint, make([]byte, 4)) || key.childNumber != 0) {
+		return nil, fmt.Errorf("%w: master key with parent fingerprint or child number", ErrInvalidExtendedKey)
+	}
+
+	keyData := raw[45:78]
+	switch {
+	case isPrivateVersion(key.version):
+		if keyData[0] != 0x00 {
+			return nil, fmt.Errorf("%w: private key prefix %x", ErrInvalidExtendedKey, keyData[0])
+		}
+		privateKey, err := NewPrivateKeyFromBytes(keyData[1:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidExtendedKey, err)
+		}
+		key.privateKey = privateKey
+		key.publicKey = privateKey.GetPublicKey()
+	case isPublicVersion(key.version):
+		publicKey, err := ParseSEC(keyData)
+		if err != nil || keyData[0] == 4 {
+			return nil, fmt.Errorf("%w: bad public key", ErrInvalidExtendedKey)
+		}
+		key.publicKey = publicKey
+	default:
+		return nil, fmt.Errorf("%w: unknown version %08x", ErrInvalidExtendedKey, key.version)
+	}
+	return key, nil
+}
@@ -0,0 +1,190 @@
+package elliptic_curve
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBip32Vectors(t *testing.T) {
+	// test vector 1 and 2 of BIP32
+	vectors := []struct {
+		seed string
+		path string
+		xpub string
+		xprv string
+	}{
+		{"000102030405060708090a0b0c0d0e0f", "m",
+			"xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8",
+			"xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi"},
+		{"000102030405060708090a0b0c0d0e0f", "m/0H",
+			"xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw",
+			"xprv9uHRZZhk6KAJC1avXpDAp4MDc3sQKNxDiPvvkX8Br5ngLNv1TxvUxt4cV1rGL5hj6KCesnDYUhd7oWgT11eZG7XnxHrnYeSvkzY7d2bhkJ7"},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'/1",
+			"xpub6ASuArnXKPbfEwhqN6e3mwBcDTgzisQN1wXN9BJcM47sSikHjJf3UFHKkNAWbWMiGj7Wf5uMash7SyYq527Hqck2AxYysAA7xmALppuCkwQ",
+			"xprv9wTYmMFdV23N2TdNG573QoEsfRrWKQgWeibmLntzniatZvR9BmLnvSxqu53Kw1UmYPxLgboyZQaXwTCg8MSY3H2EU4pWcQDnRnrVA1xe8fs"},
+		{"000102030405060708090a0b0c0d0e0f", "m/0h/1/2h",
+			"xpub6D4BDPcP2GT577Vvch3R8wDkScZWzQzMMUm3PWbmWvVJrZwQY4VUNgqFJPMM3No2dFDFGTsxxpG5uJh7n7epu4trkrX7x7DogT5Uv6fcLW5",
+			"xprv9z4pot5VBttmtdRTWfWQmoH1taj2axGVzFqSb8C9xaxKymcFzXBDptWmT7FwuEzG3ryjH4ktypQSAewRiNMjANTtpgP4mLTj34bhnZX7UiM"},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'/1/2'/2/1000000000",
+			"xpub6H1LXWLaKsWFhvm6RVpEL9P4KfRZSW7abD2ttkWP3SSQvnyA8FSVqNTEcYFgJS2UaFcxupHiYkro49S8yGasTvXEYBVPamhGW6cFJodrTHy",
+			""},
+		{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542",
+			"m",
+			"xpub661MyMwAqRbcFW31YEwpkMuc5THy2PSt5bDMsktWQcFF8syAmRUapSCGu8ED9W6oDMSgv6Zz8idoc4a6mr8BDzTJY47LJhkJ8UB7WEGuduB",
+			""},
+		{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542",
+			"m/0",
+			"xpub69H7F5d8KSRgmmdJg2KhpAK8SR3DjMwAdkxj3ZuxV27CprR9LgpeyGmXUbC6wb7ERfvrnKZjXoUmmDznezpbZb7ap6r1D3tgFxHmwMkQTPH",
+			""},
+	}
+	for _, vector := range vectors {
+		seed, _ := hex.DecodeString(vector.seed)
+		master, err := NewMasterKey(seed, XPRV_VERSION)
+		assert.Nil(t, err)
+		key, err := master.DerivePath(vector.path)
+		assert.Nil(t, err)
+		public, err := key.Neuter()
+		assert.Nil(t, err)
+		fmt.Printf("%s: %s\n", vector.path, public)
+		assert.Equal(t, vector.xpub, public.String(), vector.path)
+		if vector.xprv != "" {
+			assert.Equal(t, vector.xprv, key.String(), vector.path)
+		}
+
+		// parse back
+		parsed, err := ParseExtendedKey(vector.xpub)
+		assert.Nil(t, err)
+		assert.False(t, parsed.IsPrivate())
+		assert.True(t, parsed.PublicKey().Equal(key.PublicKey()))
+		assert.Equal(t, vector.xpub, parsed.String())
+		parsed, err = ParseExtendedKey(key.String())
+		assert.Nil(t, err)
+		assert.True(t, parsed.IsPrivate())
+		assert.Equal(t, key.String(), parsed.String())
+	}
+}
+
+func TestBip32PublicDerivation(t *testing.T) {
+	// non hardened children of xpub are the public keys of children of xprv
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := NewMasterKey(seed, XPRV_VERSION)
+	assert.Nil(t, err)
+	account, err := master.DerivePath("m/84'/0'/0'")
+	assert.Nil(t, err)
+	accountPublic, err := account.Neuter()
+	assert.Nil(t, err)
+
+	private, err := account.DerivePath("m/0/5")
+	assert.Nil(t, err)
+	public, err := accountPublic.DerivePath("m/0/5")
+	assert.Nil(t, err)
+	assert.True(t, private.PublicKey().Equal(public.PublicKey()))
+	assert.Equal(t, private.ParentFingerprint(), public.ParentFingerprint())
+	assert.Equal(t, byte(5), public.Depth())
+	assert.Equal(t, uint32(5), public.ChildNumber())
+
+	full, err := master.DerivePath("m/84'/0'/0'/0/5")
+	assert.Nil(t, err)
+	assert.Equal(t, private.String(), full.String())
+
+	_, err = accountPublic.Child(HARDENED_KEY_START)
+	assert.ErrorIs(t, err, ErrHardenedFromPublic)
+	_, err = accountPublic.PrivateKey()
+	assert.ErrorIs(t, err, ErrInvalidExtendedKey)
+
+	// fingerprint of master key of test vector 1
+	assert.Equal(t, "3442193e", fmt.Sprintf("%x", master.Fingerprint()))
+}
+
+func TestBip32MaxDepth(t *testing.T) {
+	// depth 255 can't be increased, it would wrap to 0 like a master key
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	key, err := NewMasterKey(seed, XPRV_VERSION)
+	require.NoError(t, err)
+	for i := 0; i < 255; i++ {
+		key, err = key.Child(0)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, byte(255), key.Depth())
+	_, err = key.Child(0)
+	assert.ErrorIs(t, err, ErrMaxDepth)
+
+	public, err := key.Neuter()
+	require.NoError(t, err)
+	_, err = public.Child(0)
+	assert.ErrorIs(t, err, ErrMaxDepth)
+}
+
+func TestBip32Versions(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := NewMasterKey(seed, TPRV_VERSION)
+	assert.Nil(t, err)
+	public, err := master.Neuter()
+	assert.Nil(t, err)
+	assert.Equal(t, "tpub", public.String()[0:4])
+	assert.Equal(t, "tprv", master.String()[0:4])
+
+	for _, version := range []struct {
+		version uint32
+		prefix  string
+	}{{ZPUB_VERSION, "zpub"}, {VPUB_VERSION, "vpub"}, {YPUB_VERSION, "ypub"}, {UPUB_VERSION, "upub"}, {XPUB_VERSION, "xpub"}} {
+		converted, err := public.WithVersion(version.version)
+		assert.Nil(t, err)
+		assert.Equal(t, version.prefix, converted.String()[0:4])
+		parsed, err := ParseExtendedKey(converted.String())
+		assert.Nil(t, err)
+		assert.True(t, parsed.PublicKey().Equal(master.PublicKey()))
+	}
+	zprv, err := master.WithVersion(ZPRV_VERSION)
+	assert.Nil(t, err)
+	assert.Equal(t, "zprv", zprv.String()[0:4])
+	zpub, err := zprv.Neuter()
+	assert.Nil(t, err)
+	assert.Equal(t, "zpub", zpub.String()[0:4])
+
+	_, err = public.WithVersion(XPRV_VERSION)
+	assert.ErrorIs(t, err, ErrInvalidExtendedKey)
+	_, err = NewMasterKey(seed, XPUB_VERSION)
+	assert.ErrorIs(t, err, ErrInvalidExtendedKey)
+	_, err = NewMasterKey(seed[0:8], XPRV_VERSION)
+	assert.NotNil(t, err)
+}
+
+func TestParseDerivationPath(t *testing.T) {
+	indexes, err := ParseDerivationPath("m/84'/0'/0'/0/5")
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{HARDENED_KEY_START + 84, HARDENED_KEY_START, HARDENED_KEY_START, 0, 5}, indexes)
+	indexes, err = ParseDerivationPath("m")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(indexes))
+	indexes, err = ParseDerivationPath("m/2147483647h")
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{0xffffffff}, indexes)
+
+	for _, path := range []string{"", "84'/0'", "m/", "m//1", "m/-1", "m/+1", "m/01", "m/2147483648", "m/1''", "m/a"} {
+		_, err := ParseDerivationPath(path)
+		assert.ErrorIs(t, err, ErrInvalidPath, path)
+	}
+}
+
+func TestParseExtendedKeyError(t *testing.T) {
+	// invalid keys of test vector 5 in BIP32
+	for _, key := range []string{
+		// pubkey version / prvkey mismatch
+		"xpub661MyMwAqRbcEYS8w7XLSVeEsBXy79zSzH1J8vCdxAZningWLdN3zgtU6LBpB85b3D2yc8sfvZU521AAwdZafEz7mnzBBsz4wKY5fTtTQBm",
+		// invalid pubkey prefix 04
+		"xpub661MyMwAqRbcEYS8w7XLSVeEsBXy79zSzH1J8vCdxAZningWLdN3zgtU6Txnt3siSujt9RCVYsx4qHZGc62TG4McvMGcAUjeuwZdduYEvFn",
+		// zero depth with non zero parent fingerprint
+		"xprv9s2SPatNQ9Vc6GTbVMFPFo7jsaZySyzk7L8n2uqKXJen3KUmvQNTuLh3fhZMBoG3G4ZW1N2kZuHEPY53qmbZzCHshoQnNf4GvELZfqTUrcv",
+		// unknown version
+		"DMwo58pR1QLEFihHiXPVykYB6fJmsTeHvyTp7hRThAtCX8CvYzgPcn8XnmdfHGMQzT7ayAmfo4z3gY5KfbrZWZ6St24UVf2Qgo6oujFktLHdHY4",
+	} {
+		_, err := ParseExtendedKey(key)
+		assert.NotNil(t, err, key)
+		fmt.Printf("%v\n", err)
+	}
+}
@@ -0,0 +1,214 @@
+package elliptic_curve
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/text/unicode/norm"
+)
+
+/*
+BIP39 mnemonic, the entropy of 128 to 256 bits is followed by a checksum
+of the first (entropy bits / 32) bits of its sha256, then every 11 bits
+is the index of a word in the list of 2048 words:
+
+	128 bits entropy + 4 bits checksum = 132 bits = 12 words
+	256 bits entropy + 8 bits checksum = 264 bits = 24 words
+
+the seed is PBKDF2-HMAC-SHA512 of the mnemonic with "mnemonic" + passphrase
+as salt, it is the seed of the BIP32 master key. Any passphrase gives a
+valid seed, a wrong passphrase just leads to another wallet.
+*/
+const (
+	MNEMONIC_MIN_ENTROPY_BITS = 128
+	MNEMONIC_MAX_ENTROPY_BITS = 256
+	MNEMONIC_WORD_BITS        = 11
+	MNEMONIC_PBKDF2_ROUNDS    = 2048
+	MNEMONIC_SEED_LENGTH      = 64
+	MNEMONIC_SALT_PREFIX      = "mnemonic"
+	// the first 4 letters are unique for every word in the english list
+	MNEMONIC_UNIQUE_PREFIX = 4
+	// words within this edit distance are suggested for a misspelled word
+	MNEMONIC_SUGGEST_DISTANCE = 2
+)
+
+//go:embed wordlist/english.txt
+var englishWordList string
+
+var (
+	englishWords     []string
+	englishWordIndex map[string]int
+	englishWordsOnce sync.Once
+)
+
+func getEnglishWords() ([]string, map[string]int) {
+	englishWordsOnce.Do(func() {
+		englishWords = strings.Fields(englishWordList)
+		englishWordIndex = make(map[string]int, len(englishWords))
+		for i, word := range englishWords {
+			englishWordIndex[word] = i
+		}
+	})
+	return englishWords, englishWordIndex
+}
+
+func NewEntropy(bits int) ([]byte, error) {
+	// random entropy for a new mnemonic, 128 bits for 12 words, 256 bits for 24 words
+	if bits < MNEMONIC_MIN_ENTROPY_BITS || bits > MNEMONIC_MAX_ENTROPY_BITS || bits%32 != 0 {
+		return nil, fmt.Errorf("%w: entropy bits %d", ErrInvalidMnemonic, bits)
+	}
+	entropy := make([]byte, bits/8)
+	if _, err := rand.Read(entropy); err != nil {
+		return nil, err
+	}
+	return entropy, nil
+}
+
+func EntropyToMnemonic(entropy []byte) (string, error) {
+	bits := len(entropy) * 8
+	if bits < MNEMONIC_MIN_ENTROPY_BITS || bits > MNEMONIC_MAX_ENTROPY_BITS || bits%32 != 0 {
+		return "", fmt.Errorf("%w: entropy length %d", ErrInvalidMnemonic, len(entropy))
+	}
+	words, _ := getEnglishWords()
+	hash := sha256.Sum256(entropy)
+	// entropy followed by the checksum, at most 8 bits of it, so one more byte is enough
+	data := append(append([]byte{}, entropy...), hash[0])
+	wordCount := (bits + bits/32) / MNEMONIC_WORD_BITS
+	result := make([]string, wordCount)
+	for i := 0; i < wordCount; i++ {
+		result[i] = words[readBits(data, i*MNEMONIC_WORD_BITS, MNEMONIC_WORD_BITS)]
+	}
+	return strings.Join(result, " "), nil
+}
+
+func readBits(data []byte, offset int, count int) int {
+	// count bits beginning at the offset bit, the highest bit first
+	value := 0
+	for i := offset; i < offset+count; i++ {
+		value = value<<1 | int(data[i/8]>>(7-i%8)&1)
+	}
+	return value
+}
+
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	/*
+		check the words and the checksum, returns the entropy, an unknown
+		word gets the error with suggestions of similar words
+	*/
+	_, index := getEnglishWords()
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, fmt.Errorf("%w: %d words", ErrInvalidMnemonic, len(words))
+	}
+
+	totalBits := len(words) * MNEMONIC_WORD_BITS
+	checksumBits := totalBits / 33
+	data := make([]byte, (totalBits+7)/8)
+	for i, word := range words {
+		idx, ok := index[word]
+		if !ok {
+			suggestions := SuggestWords(word)
+			if len(suggestions) == 0 {
+				return nil, fmt.Errorf("%w: unknown word %q at position %d", ErrInvalidMnemonic, word, i+1)
+			}
+			return nil, fmt.Errorf("%w: unknown word %q at position %d, did you mean %s?",
+				ErrInvalidMnemonic, word, i+1, strings.Join(suggestions, ", "))
+		}
+		for bit := 0; bit < MNEMONIC_WORD_BITS; bit++ {
+			if idx>>(MNEMONIC_WORD_BITS-1-bit)&1 == 1 {
+				pos := i*MNEMONIC_WORD_BITS + bit
+				data[pos/8] |= 1 << (7 - pos%8)
+			}
+		}
+	}
+
+	entropy := data[0 : (totalBits-checksumBits)/8]
+	hash := sha256.Sum256(entropy)
+	if readBits(data, totalBits-checksumBits, checksumBits) != readBits(hash[:], 0, checksumBits) {
+		return nil, ErrMnemonicChecksum
+	}
+	return entropy, nil
+}
+
+func ValidateMnemonic(mnemonic string) error {
+	_, err := MnemonicToEntropy(mnemonic)
+	return err
+}
+
+func SuggestWords(word string) []string {
+	/*
+		words in the list for a misspelled one, the word with the same first
+		4 letters is the only candidate as the prefix is unique, otherwise
+		the words with the smallest edit distance
+	*/
+	words, _ := getEnglishWords()
+	word = strings.ToLower(word)
+	if len(word) >= MNEMONIC_UNIQUE_PREFIX {
+		for _, candidate := range words {
+			if strings.HasPrefix(candidate, word[0:MNEMONIC_UNIQUE_PREFIX]) {
+				return []string{candidate}
+			}
+		}
+	}
+
+	suggestions := make([]string, 0)
+	best := MNEMONIC_SUGGEST_DISTANCE + 1
+	for _, candidate := range words {
+		distance := editDistance(word, candidate)
+		if distance < best {
+			best = distance
+			suggestions = suggestions[:0]
+		}
+		if distance == best {
+			suggestions = append(suggestions, candidate)
+		}
+	}
+	return suggestions
+}
+
+func editDistance(a, b string) int {
+	// levenshtein distance with one row of the table
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		prev := row[0]
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			current := row[j]
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			row[j] = min(row[j]+1, row[j-1]+1, prev+cost)
+			prev = current
+		}
+	}
+	return row[len(b)]
+}
+
+func MnemonicToSeed(mnemonic string, passphrase string) []byte {
+	/*
+		the mnemonic and passphrase are in unicode NFKD, the same text typed
+		in composed or decomposed form gives the same seed, ideographic space
+		of japanese mnemonic becomes the normal space
+	*/
+	normalized := strings.Join(strings.Fields(norm.NFKD.String(mnemonic)), " ")
+	return pbkdf2.Key([]byte(normalized), []byte(MNEMONIC_SALT_PREFIX+norm.NFKD.String(passphrase)),
+		MNEMONIC_PBKDF2_ROUNDS, MNEMONIC_SEED_LENGTH, sha512.New)
+}
+
+func NewMasterKeyFromMnemonic(mnemonic string, passphrase string, version uint32) (*ExtendedKey, error) {
+	// check the mnemonic first, a typo would give a valid but different wallet
+	if err := ValidateMnemonic(mnemonic); err != nil {
+		return nil, err
+	}
+	return NewMasterKey(MnemonicToSeed(mnemonic, passphrase), version)
+}
@@ -0,0 +1,111 @@
+package elliptic_curve
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMnemonicVectors(t *testing.T) {
+	// vectors of the reference implementation with passphrase "TREZOR"
+	vectors := []struct {
+		entropy  string
+		mnemonic string
+		seed     string
+	}{
+		{"00000000000000000000000000000000",
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+			"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04"},
+		{"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+			"legal winner thank year wave sausage worth useful legal winner thank yellow",
+			"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607"},
+		{"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+			strings.Repeat("zoo ", 23) + "vote",
+			"dd48c104698c30cfe2b6142103248622fb7bb0ff692eebb00089b32d22484e1613912f0a5b694407be899ffd31ed3992c456cdf60f5d4564b8ba3f05a69890ad"},
+	}
+	for _, vector := range vectors {
+		entropy, _ := hex.DecodeString(vector.entropy)
+		mnemonic, err := EntropyToMnemonic(entropy)
+		assert.Nil(t, err)
+		assert.Equal(t, vector.mnemonic, mnemonic)
+
+		decoded, err := MnemonicToEntropy(mnemonic)
+		assert.Nil(t, err)
+		assert.Equal(t, entropy, decoded)
+		assert.Equal(t, vector.seed, fmt.Sprintf("%x", MnemonicToSeed(mnemonic, "TREZOR")))
+	}
+
+	// the seed is the seed of BIP32 master key
+	master, err := NewMasterKeyFromMnemonic(vectors[0].mnemonic, "TREZOR", XPRV_VERSION)
+	assert.Nil(t, err)
+	assert.Equal(t, "xprv9s21ZrQH143K3h3fDYiay8mocZ3afhfULfb5GX8kCBdno77K4HiA15Tg23wpbeF1pLfs1c5SPmYHrEpTuuRhxMwvKDwqdKiGJS9XFKzUsAF",
+		master.String())
+}
+
+func TestMnemonicToSeedNFKD(t *testing.T) {
+	/*
+		first japanese vector of BIP39, words are separated by ideographic
+		space and the passphrase has characters changed by NFKD
+	*/
+	mnemonic := strings.Join([]string{"あいこくしん", "あいこくしん", "あいこくしん", "あいこくしん",
+		"あいこくしん", "あいこくしん", "あいこくしん", "あいこくしん", "あいこくしん",
+		"あいこくしん", "あいこくしん", "あおぞら"}, "\u3000")
+	passphrase := "㍍ガバヴァぱばぐゞちぢ十人十色"
+	seed := "a262d6fb6122ecf45be09c50492b31f92e9beb7d9a845987a02cefda57a15f9c467a17872029a9e92299b5cbdf306e3a0ee620245cbd508959b6cb7ca637bd55"
+	assert.Equal(t, seed, fmt.Sprintf("%x", MnemonicToSeed(mnemonic, passphrase)))
+
+	// ㍍ is the compatibility character of メートル, both give the same seed
+	spelledOut := "メートルガバヴァぱばぐゞちぢ十人十色"
+	assert.Equal(t, seed, fmt.Sprintf("%x", MnemonicToSeed(strings.ReplaceAll(mnemonic, "\u3000", " "), spelledOut)))
+}
+
+func TestEnglishWordList(t *testing.T) {
+	words, index := getEnglishWords()
+	assert.Equal(t, 2048, len(words))
+	assert.Equal(t, 2048, len(index))
+	assert.Equal(t, "abandon", words[0])
+	assert.Equal(t, "zoo", words[2047])
+}
+
+func TestNewMnemonic(t *testing.T) {
+	for _, bits := range []int{128, 160, 192, 224, 256} {
+		entropy, err := NewEntropy(bits)
+		assert.Nil(t, err)
+		mnemonic, err := EntropyToMnemonic(entropy)
+		assert.Nil(t, err)
+		assert.Equal(t, (bits+bits/32)/11, len(strings.Fields(mnemonic)))
+		assert.Nil(t, ValidateMnemonic(mnemonic))
+	}
+	_, err := NewEntropy(100)
+	assert.ErrorIs(t, err, ErrInvalidMnemonic)
+	_, err = EntropyToMnemonic(bytes.Repeat([]byte{0x01}, 15))
+	assert.ErrorIs(t, err, ErrInvalidMnemonic)
+}
+
+func TestValidateMnemonic(t *testing.T) {
+	// last word changed, the checksum doesn't match
+	err := ValidateMnemonic(strings.Repeat("abandon ", 11) + "abandon")
+	assert.ErrorIs(t, err, ErrMnemonicChecksum)
+	err = ValidateMnemonic(strings.Repeat("abandon ", 10) + "about")
+	assert.ErrorIs(t, err, ErrInvalidMnemonic)
+
+	// misspelled words get suggestions
+	err = ValidateMnemonic("legal winner thank year wave sausage worth useful legal winner thank yelow")
+	assert.ErrorIs(t, err, ErrInvalidMnemonic)
+	fmt.Printf("%v\n", err)
+	assert.Contains(t, err.Error(), "yellow")
+	assert.Equal(t, []string{"below", "yellow"}, SuggestWords("yelow"))
+	assert.Equal(t, []string{"sausage"}, SuggestWords("sausag"))
+	assert.Contains(t, SuggestWords("cta"), "cat")
+	assert.Equal(t, []string{}, SuggestWords("qqqqqqqq"))
+
+	// seed is different with other passphrase
+	mnemonic := strings.Repeat("abandon ", 11) + "about"
+	assert.NotEqual(t, MnemonicToSeed(mnemonic, ""), MnemonicToSeed(mnemonic, "TREZOR"))
+	_, err = NewMasterKeyFromMnemonic(mnemonic+" abandon", "", XPRV_VERSION)
+	assert.ErrorIs(t, err, ErrInvalidMnemonic)
+}
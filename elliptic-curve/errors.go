@@ -0,0 +1,28 @@
+package elliptic_curve
+
+import "errors"
+
+/*
+errors returned when parsing binary data, wrap them with details by
+fmt.Errorf("%w") and check them by errors.Is
+*/
+var (
+	ErrTruncatedInput   = errors.New("truncated input")
+	ErrInvalidPublicKey = errors.New("invalid sec public key")
+	ErrNonCanonicalDER  = errors.New("non-canonical der signature")
+	ErrInvalidBase58    = errors.New("invalid base58 string")
+	ErrBase58Checksum   = errors.New("base58 checksum mismatch")
+	ErrInvalidBech32    = errors.New("invalid bech32 string")
+	ErrBech32Checksum   = errors.New("bech32 checksum mismatch")
+
+	ErrInvalidSegwitAddress = errors.New("invalid segwit address")
+	ErrInvalidPrivateKey    = errors.New("invalid private key")
+
+	ErrInvalidExtendedKey = errors.New("invalid extended key")
+	ErrInvalidChild       = errors.New("invalid child key, use the next index")
+	ErrHardenedFromPublic = errors.New("can't derive hardened child from public key")
+	ErrMaxDepth           = errors.New("extended key at max depth 255")
+	ErrInvalidPath        = errors.New("invalid derivation path")
+	ErrInvalidMnemonic    = errors.New("invalid mnemonic")
+	ErrMnemonicChecksum   = errors.New("mnemonic checksum mismatch")
+)
@@ -8,5 +8,6 @@ require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/crypto v0.26.0
+	golang.org/x/text v0.17.0
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,293 @@
+package elliptic_curve
+
+import (
+	"math/big"
+	"sync"
+)
+
+/*
+Point.Add computes the slope by division, which is a modular inversion for
+every addition, it is the slowest operation of the field. Jacobian
+coordinates (X, Y, Z) represent the point x = X/Z^2, y = Y/Z^3, addition and
+doubling only need multiplications, and we do one inversion at the end to get
+back x and y. Only secp256k1 (a = 0) is handled here. These are not constant
+time, they are for public values like signature verification, see
+secp256k1.go for the secret ones.
+*/
+type jacobianPoint struct {
+	x, y, z *big.Int
+}
+
+var secp256k1P = S256Field(big.NewInt(0)).order
+
+const (
+	// window size of wNAF for the generator and for other points
+	GENERATOR_WINDOW = 8
+	POINT_WINDOW     = 5
+)
+
+func jacobianInfinity() *jacobianPoint {
+	return &jacobianPoint{x: big.NewInt(1), y: big.NewInt(1), z: big.NewInt(0)}
+}
+
+func jacobianFromPoint(p *Point) *jacobianPoint {
+	if p.x == nil {
+		return jacobianInfinity()
+	}
+	return &jacobianPoint{
+		x: new(big.Int).Set(p.x.num),
+		y: new(big.Int).Set(p.y.num),
+		z: big.NewInt(1),
+	}
+}
+
+func (j *jacobianPoint) isInfinity() bool {
+	return j.z.Sign() == 0
+}
+
+func (j *jacobianPoint) toPoint() *Point {
+	if j.isInfinity() {
+		return S256Point(nil, nil)
+	}
+	x, y := j.affine()
+	return S256Point(x, y)
+}
+
+func (j *jacobianPoint) affine() (*big.Int, *big.Int) {
+	// x = X/Z^2, y = Y/Z^3
+	p := secp256k1P
+	zInv := new(big.Int).ModInverse(j.z, p)
+	zInv2 := new(big.Int).Mul(zInv, zInv)
+	zInv2.Mod(zInv2, p)
+	x := new(big.Int).Mul(j.x, zInv2)
+	x.Mod(x, p)
+	y := new(big.Int).Mul(j.y, zInv2)
+	y.Mul(y, zInv)
+	y.Mod(y, p)
+	return x, y
+}
+
+func (j *jacobianPoint) negate() *jacobianPoint {
+	y := new(big.Int).Sub(secp256k1P, j.y)
+	y.Mod(y, secp256k1P)
+	return &jacobianPoint{x: j.x, y: y, z: j.z}
+}
+
+func (j *jacobianPoint) double() *jacobianPoint {
+	/*
+		dbl-2009-l for a = 0:
+		A = X^2, B = Y^2, C = B^2, D = 2((X+B)^2 - A - C), E = 3A, F = E^2
+		X3 = F - 2D, Y3 = E(D - X3) - 8C, Z3 = 2YZ
+	*/
+	if j.isInfinity() || j.y.Sign() == 0 {
+		return jacobianInfinity()
+	}
+	p := secp256k1P
+	a := new(big.Int).Mul(j.x, j.x)
+	a.Mod(a, p)
+	b := new(big.Int).Mul(j.y, j.y)
+	b.Mod(b, p)
+	c := new(big.Int).Mul(b, b)
+	c.Mod(c, p)
+	d := new(big.Int).Add(j.x, b)
+	d.Mul(d, d)
+	d.Sub(d, a)
+	d.Sub(d, c)
+	d.Lsh(d, 1)
+	d.Mod(d, p)
+	e := new(big.Int).Mul(a, big.NewInt(3))
+	f := new(big.Int).Mul(e, e)
+
+	x3 := new(big.Int).Sub(f, new(big.Int).Lsh(d, 1))
+	x3.Mod(x3, p)
+	y3 := new(big.Int).Sub(d, x3)
+	y3.Mul(y3, e)
+	y3.Sub(y3, new(big.Int).Lsh(c, 3))
+	y3.Mod(y3, p)
+	z3 := new(big.Int).Mul(j.y, j.z)
+	z3.Lsh(z3, 1)
+	z3.Mod(z3, p)
+	return &jacobianPoint{x: x3, y: y3, z: z3}
+}
+
+func (j *jacobianPoint) add(other *jacobianPoint) *jacobianPoint {
+	/*
+		add-2007-bl:
+		U1 = X1*Z2^2, U2 = X2*Z1^2, S1 = Y1*Z2^3, S2 = Y2*Z1^3
+		H = U2 - U1, r = S2 - S1
+		X3 = r^2 - H^3 - 2*U1*H^2, Y3 = r(U1*H^2 - X3) - S1*H^3, Z3 = Z1*Z2*H
+	*/
+	if j.isInfinity() {
+		return other
+	}
+	if other.isInfinity() {
+		return j
+	}
+	p := secp256k1P
+	z1z1 := new(big.Int).Mul(j.z, j.z)
+	z1z1.Mod(z1z1, p)
+	z2z2 := new(big.Int).Mul(other.z, other.z)
+	z2z2.Mod(z2z2, p)
+	u1 := new(big.Int).Mul(j.x, z2z2)
+	u1.Mod(u1, p)
+	u2 := new(big.Int).Mul(other.x, z1z1)
+	u2.Mod(u2, p)
+	s1 := new(big.Int).Mul(j.y, other.z)
+	s1.Mul(s1, z2z2)
+	s1.Mod(s1, p)
+	s2 := new(big.Int).Mul(other.y, j.z)
+	s2.Mul(s2, z1z1)
+	s2.Mod(s2, p)
+
+	h := new(big.Int).Sub(u2, u1)
+	h.Mod(h, p)
+	r := new(big.Int).Sub(s2, s1)
+	r.Mod(r, p)
+	if h.Sign() == 0 {
+		// same x, the points are the same or on the vertical line
+		if r.Sign() == 0 {
+			return j.double()
+		}
+		return jacobianInfinity()
+	}
+
+	hh := new(big.Int).Mul(h, h)
+	hh.Mod(hh, p)
+	hhh := new(big.Int).Mul(h, hh)
+	hhh.Mod(hhh, p)
+	v := new(big.Int).Mul(u1, hh)
+	v.Mod(v, p)
+
+	x3 := new(big.Int).Mul(r, r)
+	x3.Sub(x3, hhh)
+	x3.Sub(x3, new(big.Int).Lsh(v, 1))
+	x3.Mod(x3, p)
+	y3 := new(big.Int).Sub(v, x3)
+	y3.Mul(y3, r)
+	y3.Sub(y3, new(big.Int).Mul(s1, hhh))
+	y3.Mod(y3, p)
+	z3 := new(big.Int).Mul(j.z, other.z)
+	z3.Mul(z3, h)
+	z3.Mod(z3, p)
+	return &jacobianPoint{x: x3, y: y3, z: z3}
+}
+
+func oddMultiples(p *jacobianPoint, window uint) []*jacobianPoint {
+	// P, 3P, 5P, ..., (2^(window-1) - 1)P
+	count := 1 << (window - 2)
+	table := make([]*jacobianPoint, count)
+	table[0] = p
+	double := p.double()
+	for i := 1; i < count; i++ {
+		table[i] = table[i-1].add(double)
+	}
+	return table
+}
+
+func wNAF(scalar *big.Int, window uint) []int {
+	/*
+		width-w non-adjacent form, every digit is zero or odd in
+		(-2^(w-1), 2^(w-1)), and there is at most one non zero digit in any
+		w digits in a row, so we only need the odd multiples in the table
+		and much fewer additions than the binary form, the lowest digit first
+	*/
+	k := new(big.Int).Set(scalar)
+	modulus := int64(1) << window
+	digits := make([]int, 0, k.BitLen()+1)
+	for k.Sign() > 0 {
+		digit := int64(0)
+		if k.Bit(0) == 1 {
+			digit = new(big.Int).And(k, big.NewInt(modulus-1)).Int64()
+			if digit >= modulus/2 {
+				digit -= modulus
+			}
+			k.Sub(k, big.NewInt(digit))
+		}
+		digits = append(digits, int(digit))
+		k.Rsh(k, 1)
+	}
+	return digits
+}
+
+var (
+	generatorTable     []*jacobianPoint
+	generatorTableOnce sync.Once
+)
+
+func getGeneratorTable() []*jacobianPoint {
+	/*
+		odd multiples of G up to 127G, computed once and turned into affine
+		coordinates (Z = 1), which makes the additions with them cheaper
+	*/
+	generatorTableOnce.Do(func() {
+		table := oddMultiples(jacobianFromPoint(GetGenerator()), GENERATOR_WINDOW)
+		for i, point := range table {
+			x, y := point.affine()
+			table[i] = &jacobianPoint{x: x, y: y, z: big.NewInt(1)}
+		}
+		generatorTable = table
+	})
+	return generatorTable
+}
+
+/*
+multiScalarMul computes the sum of scalars[i] * points[i] by Shamir's trick,
+all the scalars share the same chain of doublings, and each one only adds
+its table entry when its wNAF digit is not zero
+*/
+func multiScalarMul(scalars []*big.Int, tables [][]*jacobianPoint, windows []uint) *jacobianPoint {
+	nafs := make([][]int, len(scalars))
+	length := 0
+	for i, scalar := range scalars {
+		nafs[i] = wNAF(scalar, windows[i])
+		if len(nafs[i]) > length {
+			length = len(nafs[i])
+		}
+	}
+
+	result := jacobianInfinity()
+	for i := length - 1; i >= 0; i-- {
+		result = result.double()
+		for k, naf := range nafs {
+			if i >= len(naf) || naf[i] == 0 {
+				continue
+			}
+			digit := naf[i]
+			if digit > 0 {
+				result = result.add(tables[k][digit/2])
+			} else {
+				result = result.add(tables[k][-digit/2].negate())
+			}
+		}
+	}
+	return result
+}
+
+func isS256Point(p *Point) bool {
+	return p.a.order.Cmp(secp256k1P) == 0 && p.a.num.Sign() == 0 && p.b.num.Cmp(big.NewInt(7)) == 0
+}
+
+func (p *Point) isGenerator() bool {
+	G := GetGenerator()
+	return p.x != nil && p.x.num.Cmp(G.x.num) == 0 && p.y.num.Cmp(G.y.num) == 0
+}
+
+func s256ScalarMul(p *Point, scalar *big.Int) *Point {
+	// scalar * p on secp256k1 with the wNAF, G uses the precomputed table
+	if p.x == nil || scalar.Sign() == 0 {
+		return S256Point(nil, nil)
+	}
+	if p.isGenerator() {
+		return multiScalarMul([]*big.Int{scalar}, [][]*jacobianPoint{getGeneratorTable()},
+			[]uint{GENERATOR_WINDOW}).toPoint()
+	}
+	table := oddMultiples(jacobianFromPoint(p), POINT_WINDOW)
+	return multiScalarMul([]*big.Int{scalar}, [][]*jacobianPoint{table}, []uint{POINT_WINDOW}).toPoint()
+}
+
+func shamirMul(u *big.Int, v *big.Int, p *Point) *Point {
+	// u*G + v*P with one chain of doublings
+	table := oddMultiples(jacobianFromPoint(p), POINT_WINDOW)
+	return multiScalarMul([]*big.Int{u, v}, [][]*jacobianPoint{getGeneratorTable(), table},
+		[]uint{GENERATOR_WINDOW, POINT_WINDOW}).toPoint()
+}
@@ -0,0 +1,115 @@
+package elliptic_curve
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWNAF(t *testing.T) {
+	// digits are zero or odd, and give back the scalar
+	for _, window := range []uint{POINT_WINDOW, GENERATOR_WINDOW} {
+		scalar := new(big.Int).SetBytes(Hash256(fmt.Sprintf("wnaf %d", window)))
+		digits := wNAF(scalar, window)
+		sum := big.NewInt(0)
+		for i := len(digits) - 1; i >= 0; i-- {
+			sum.Lsh(sum, 1)
+			sum.Add(sum, big.NewInt(int64(digits[i])))
+			if digits[i] != 0 {
+				assert.Equal(t, 1, digits[i]&1)
+				assert.True(t, digits[i] < 1<<(window-1) && digits[i] > -(1<<(window-1)))
+			}
+		}
+		assert.Equal(t, scalar.String(), sum.String())
+	}
+}
+
+func TestJacobianScalarMul(t *testing.T) {
+	// jacobian and wNAF give the same point as the affine double and add
+	n := GetBitcoinValueN()
+	G := GetGenerator()
+	P := G.scalarMulAffine(big.NewInt(8675309))
+	scalars := []*big.Int{big.NewInt(1), big.NewInt(2), big.NewInt(3), big.NewInt(255),
+		new(big.Int).Sub(n, big.NewInt(1)),
+		new(big.Int).SetBytes(Hash256("jacobian"))}
+	for _, scalar := range scalars {
+		for _, point := range []*Point{G, P} {
+			expected := point.scalarMulAffine(scalar)
+			assert.True(t, expected.Equal(point.ScalarMul(scalar)), "scalar %x", scalar)
+		}
+	}
+
+	// n*G is the point at infinity
+	assert.Nil(t, G.ScalarMul(n).x)
+	assert.Nil(t, P.ScalarMul(big.NewInt(0)).x)
+
+	// u*G + v*P
+	u := new(big.Int).SetBytes(Hash256("u"))
+	v := new(big.Int).SetBytes(Hash256("v"))
+	expected := G.scalarMulAffine(u).Add(P.scalarMulAffine(v))
+	assert.True(t, expected.Equal(shamirMul(u, v, P)))
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	privateKey := NewPrivateKey(big.NewInt(12345))
+	n := GetBitcoinValueN()
+	z := new(big.Int).SetBytes(Hash256("Testing my Signing"))
+	sig := privateKey.Sign(z)
+	assert.True(t, privateKey.GetPublicKey().Verify(NewFieldElement(n, z), sig))
+
+	otherZ := new(big.Int).SetBytes(Hash256("Testing other Signing"))
+	assert.False(t, privateKey.GetPublicKey().Verify(NewFieldElement(n, otherZ), sig))
+	otherKey := NewPrivateKey(big.NewInt(54321))
+	assert.False(t, otherKey.GetPublicKey().Verify(NewFieldElement(n, z), sig))
+}
+
+func benchmarkSignature() (*Point, *FieldElement, *Signature) {
+	privateKey := NewPrivateKey(big.NewInt(12345))
+	z := new(big.Int).SetBytes(Hash256("benchmark"))
+	return privateKey.GetPublicKey(), NewFieldElement(GetBitcoinValueN(), z), privateKey.Sign(z)
+}
+
+func BenchmarkScalarMulAffine(b *testing.B) {
+	G := GetGenerator()
+	scalar := new(big.Int).SetBytes(Hash256("benchmark"))
+	for i := 0; i < b.N; i++ {
+		G.scalarMulAffine(scalar)
+	}
+}
+
+func BenchmarkScalarMulJacobian(b *testing.B) {
+	P := GetGenerator().ScalarMul(big.NewInt(8675309))
+	scalar := new(big.Int).SetBytes(Hash256("benchmark"))
+	for i := 0; i < b.N; i++ {
+		P.ScalarMul(scalar)
+	}
+}
+
+func BenchmarkScalarBaseMul(b *testing.B) {
+	G := GetGenerator()
+	scalar := new(big.Int).SetBytes(Hash256("benchmark"))
+	for i := 0; i < b.N; i++ {
+		G.ScalarMul(scalar)
+	}
+}
+
+func BenchmarkVerifyAffine(b *testing.B) {
+	// the way Verify worked before, two scalar multiplications in affine
+	point, z, sig := benchmarkSignature()
+	G := GetGenerator()
+	for i := 0; i < b.N; i++ {
+		sInverse := sig.s.Inverse()
+		u := z.Multiply(sInverse)
+		v := sig.r.Multiply(sInverse)
+		G.scalarMulAffine(u.num).Add(point.scalarMulAffine(v.num))
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	point, z, sig := benchmarkSignature()
+	for i := 0; i < b.N; i++ {
+		point.Verify(z, sig)
+	}
+}
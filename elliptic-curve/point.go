@@ -90,7 +90,14 @@ func (p *Point) ScalarMul(scalar *big.Int) *Point {
 	if scalar == nil {
 		panic("scalar can not be nil")
 	}
+	// bitcoin curve goes the fast way in jacobian coordinates
+	if isS256Point(p) {
+		return s256ScalarMul(p, scalar)
+	}
+	return p.scalarMulAffine(scalar)
+}
 
+func (p *Point) scalarMulAffine(scalar *big.Int) *Point {
 	// 13 => "1101"
 	binaryFrom := fmt.Sprintf("%b", scalar)
 	current := p
@@ -226,11 +233,18 @@ func (p *Point) Verify(z *FieldElement, sig *Signature) bool {
 			  n * G = identity
 			  n is prime, FieldElemnet(order=n, z/s)
 	*/
+	// r and s must be in [1, n-1], zero has no inverse
+	if sig.r.num.Sign() == 0 || sig.s.num.Sign() == 0 {
+		return false
+	}
 	sInverse := sig.s.Inverse()
 	u := z.Multiply(sInverse)
 	v := sig.r.Multiply(sInverse)
-	G := GetGenerator()
-	total := (G.ScalarMul(u.num)).Add(p.ScalarMul(v.num))
+	// u*G + v*P computed together by Shamir's trick
+	total := shamirMul(u.num, v.num, p)
+	if total.x == nil {
+		return false
+	}
 	return total.x.num.Cmp(sig.r.num) == 0
 }
 
@@ -251,8 +265,9 @@ func (p *Point) Sec(compressed bool) (string, []byte) {
 
 	if !compressed {
 		secBytes = append(secBytes, 0x04)
-		secBytes = append(secBytes, p.x.num.Bytes()...)
-		secBytes = append(secBytes, p.y.num.Bytes()...)
+		// coordinates are always in 32 bytes even with leading zeros
+		secBytes = append(secBytes, p.x.num.FillBytes(make([]byte, 32))...)
+		secBytes = append(secBytes, p.y.num.FillBytes(make([]byte, 32))...)
 		return fmt.Sprintf("04%064x%064x", p.x.num, p.y.num), secBytes
 	}
 
@@ -261,12 +276,12 @@ func (p *Point) Sec(compressed bool) (string, []byte) {
 	if opMod.Mod(p.y.num, big.NewInt(2)).Cmp(big.NewInt(0)) == 0 {
 		// y is even set first byte to 02
 		secBytes = append(secBytes, 0x02)
-		secBytes = append(secBytes, p.x.num.Bytes()...)
+		secBytes = append(secBytes, p.x.num.FillBytes(make([]byte, 32))...)
 		return fmt.Sprintf("02%064x", p.x.num), secBytes
 	} else {
 		// y is odd set first byte to 03
 		secBytes = append(secBytes, 0x03)
-		secBytes = append(secBytes, p.x.num.Bytes()...)
+		secBytes = append(secBytes, p.x.num.FillBytes(make([]byte, 32))...)
 		return fmt.Sprintf("03%064x", p.x.num), secBytes
 	}
 
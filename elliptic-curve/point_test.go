@@ -66,6 +66,7 @@ func TestMain(m *testing.M) {
 	n := new(big.Int)
 	n.SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
 	fmt.Printf("n*G is :%s\n", G.ScalarMul(n))
+	m.Run()
 }
 
 func TestCheckPointOnCurve(t *testing.T) {
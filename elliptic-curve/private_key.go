@@ -1,57 +1,201 @@
 package elliptic_curve
 
 import (
-	"crypto/rand"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
 	"math/big"
 )
 
+const (
+	// wallet import format prefix for mainnet and testnet
+	WIF_MAINNET_PREFIX = 0x80
+	WIF_TESTNET_PREFIX = 0xef
+	// appended after the secret when the public key is compressed
+	WIF_COMPRESSED_SUFFIX = 0x01
+)
+
 type PrivateKey struct {
-	secret *big.Int
+	// kept in limbs for the constant time backend, it is in [1, n-1]
+	secret limbs
 	point  *Point
 }
 
 func NewPrivateKey(secret *big.Int) *PrivateKey {
-	G := GetGenerator()
+	/*
+		secret should be in [1, n-1], it is checked here once and never
+		reduced by big.Int, whose running time depends on the value
+	*/
+	if secret.Sign() <= 0 || secret.Cmp(GetBitcoinValueN()) >= 0 {
+		panic("secret of private key is not in [1, n-1]")
+	}
+	return newPrivateKeyFromLimbs(limbsFromBig(secret))
+}
+
+func newPrivateKeyFromLimbs(secret limbs) *PrivateKey {
+	/*
+		public key is secret * G, it is computed by the constant time
+		backend, G.ScalarMul gives the same point but leaks the secret by
+		its running time
+	*/
+	x, y := ctScalarBaseMul(secret)
 	return &PrivateKey{
 		secret: secret,
 		// public key
-		point: G.ScalarMul(secret),
+		point: S256Point(x, y),
+	}
+}
+
+func NewPrivateKeyFromBytes(secret []byte) (*PrivateKey, error) {
+	// 32 bytes big endian secret, it should be in [1, n-1]
+	if len(secret) != 32 {
+		return nil, fmt.Errorf("%w: secret length %d", ErrInvalidPrivateKey, len(secret))
+	}
+	num := new(big.Int).SetBytes(secret)
+	if num.Sign() == 0 || num.Cmp(GetBitcoinValueN()) >= 0 {
+		return nil, fmt.Errorf("%w: secret is not in [1, n-1]", ErrInvalidPrivateKey)
+	}
+	return newPrivateKeyFromLimbs(limbsFromBytes(secret)), nil
+}
+
+func (p *PrivateKey) Wif(compressed, testnet bool) string {
+	/*
+		wallet import format:
+		1. prefix 0x80 for mainnet, 0xef for testnet
+		2. secret in 32 bytes big endian
+		3. 0x01 if the address of the key uses compressed public key
+		4. base58 with checksum
+	*/
+	prefix := byte(WIF_MAINNET_PREFIX)
+	if testnet {
+		prefix = WIF_TESTNET_PREFIX
+	}
+	result := append([]byte{prefix}, p.secret.bytes()...)
+	if compressed {
+		result = append(result, WIF_COMPRESSED_SUFFIX)
+	}
+	return Base58Checksum(result)
+}
+
+func ParseWif(wif string) (*PrivateKey, bool, bool, error) {
+	// returns the private key, whether it is compressed and whether it is for testnet
+	prefix, payload, err := DecodeBase58Check(wif)
+	if err != nil {
+		return nil, false, false, err
+	}
+	if prefix != WIF_MAINNET_PREFIX && prefix != WIF_TESTNET_PREFIX {
+		return nil, false, false, fmt.Errorf("%w: wif prefix %x", ErrInvalidPrivateKey, prefix)
+	}
+	compressed := false
+	if len(payload) == 33 {
+		if payload[32] != WIF_COMPRESSED_SUFFIX {
+			return nil, false, false, fmt.Errorf("%w: wif compressed flag %x", ErrInvalidPrivateKey, payload[32])
+		}
+		compressed = true
+		payload = payload[0:32]
+	}
+	privateKey, err := NewPrivateKeyFromBytes(payload)
+	if err != nil {
+		return nil, false, false, err
 	}
+	return privateKey, compressed, prefix == WIF_TESTNET_PREFIX, nil
 }
 
 func (p *PrivateKey) String() string {
-	return fmt.Sprintf("private key hex: {%s}", p.secret)
+	return fmt.Sprintf("private key hex: {%s}", p.secret.big())
 }
 
 func (p *PrivateKey) GetPublicKey() *Point {
 	return p.point
 }
 
+func (p *PrivateKey) deterministicK(z *big.Int, extraEntropy []byte) limbs {
+	/*
+		RFC 6979 generates k from the secret and the message by HMAC-SHA256,
+		the same message signed by the same key always gets the same k, we
+		don't depend on a random number generator which may leak the secret
+		if it gives the same k twice. Extra entropy is appended after the
+		message like libsecp256k1 does
+	*/
+	n := GetBitcoinValueN()
+	k := make([]byte, 32)
+	v := make([]byte, 32)
+	for i := range v {
+		v[i] = 0x01
+	}
+	zNum := new(big.Int).Set(z)
+	if zNum.Cmp(n) >= 0 {
+		zNum.Sub(zNum, n)
+	}
+	zBytes := make([]byte, 32)
+	zNum.FillBytes(zBytes)
+	secretBytes := p.secret.bytes()
+
+	hmacSha256 := func(key []byte, data ...[]byte) []byte {
+		mac := hmac.New(sha256.New, key)
+		for _, d := range data {
+			mac.Write(d)
+		}
+		return mac.Sum(nil)
+	}
+
+	k = hmacSha256(k, v, []byte{0x00}, secretBytes, zBytes, extraEntropy)
+	v = hmacSha256(k, v)
+	k = hmacSha256(k, v, []byte{0x01}, secretBytes, zBytes, extraEntropy)
+	v = hmacSha256(k, v)
+	for {
+		/*
+			the candidate is secret, it goes into limbs straight from the HMAC
+			output and is checked in constant time, only whether it is in
+			[1, n-1] is known by the branch, it is out of range with
+			probability about 2^-128
+		*/
+		v = hmacSha256(k, v)
+		candidate := limbsFromBytes(v)
+		if groupN.inRange(candidate) != 0 {
+			return candidate
+		}
+		k = hmacSha256(k, v, []byte{0x00})
+		v = hmacSha256(k, v)
+	}
+}
+
 func (p *PrivateKey) Sign(z *big.Int) *Signature {
+	return p.signWithK(z, p.deterministicK(z, nil))
+}
+
+func (p *PrivateKey) SignWithEntropy(z *big.Int, extraEntropy []byte) *Signature {
+	/*
+		extra entropy is mixed into the nonce, the signature is still
+		deterministic for the same entropy, nil entropy is the same as Sign
+	*/
+	return p.signWithK(z, p.deterministicK(z, extraEntropy))
+}
+
+func (p *PrivateKey) SignLowR(z *big.Int) *Signature {
+	/*
+		Bitcoin Core grinds the nonce until r is less than 2^255, then the
+		DER encoding of r needs no padding byte and the signature is one byte
+		shorter, the counter is put into the extra entropy in little endian
+	*/
+	sig := p.Sign(z)
+	extraEntropy := make([]byte, 32)
+	for counter := uint32(1); sig.r.num.BitLen() > 255; counter++ {
+		binary.LittleEndian.PutUint32(extraEntropy, counter)
+		sig = p.SignWithEntropy(z, extraEntropy)
+	}
+	return sig
+}
+
+func (p *PrivateKey) signWithK(z *big.Int, k limbs) *Signature {
 	//(s, r)
 	//s = (z + r * e) / k
-	// k is a strong random number
-	n := GetBitcoinValueN()
-	k, err := rand.Int(rand.Reader, n)
-	if err != nil {
-		panic(fmt.Sprintf("Sign err with rand int: %s", err))
-	}
-	kField := NewFieldElement(n, k)
-	G := GetGenerator()
-	// s = (z + r * e) / k
 	// r = G * k
-	r := G.ScalarMul(k).x.num
-	rField := NewFieldElement(n, r)
-	eField := NewFieldElement(n, p.secret)
-	zField := NewFieldElement(n, z)
-	// r*e
-	rMulSecret := rField.Multiply(eField)
-	// z+r*e
-	zAddRMulSecret := zField.Add(rMulSecret)
-	// /k
-	kInverse := kField.Inverse()
-	sField := zAddRMulSecret.Multiply(kInverse)
+	n := GetBitcoinValueN()
+	zNum := new(big.Int).Mod(z, n)
+	r, s := ctSign(p.secret, k, limbsFromBig(zNum))
+	sField := NewFieldElement(n, s)
 	/*
 	   if s > n / 2 we need to change it to n - s, when doing signature
 	   verify, s and n - s are equivalence doing this change is for malleability reasons, detail:
@@ -1,6 +1,7 @@
 package elliptic_curve
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"math/big"
 	"testing"
@@ -23,3 +24,131 @@ func TestSign(t *testing.T) {
 	res := pubKey.Verify(zField, sig)
 	assert.True(t, res)
 }
+
+func TestSignDeterministic(t *testing.T) {
+	/*
+		RFC 6979 vectors for secp256k1 with SHA256 message, the same ones
+		used by other bitcoin libraries, s is in the low form
+	*/
+	n := GetBitcoinValueN()
+	tests := []struct {
+		secret  *big.Int
+		message string
+		r       string
+		s       string
+	}{
+		{
+			big.NewInt(1),
+			"Satoshi Nakamoto",
+			"934b1ea10a4b3c1757e2b0c017d0b6143ce3c9a7e6a4a49860d7a6ab210ee3d8",
+			"2442ce9d2b916064108014783e923ec36b49743e2ffa1c4496f01a512aafd9e5",
+		},
+		{
+			big.NewInt(1),
+			"All those moments will be lost in time, like tears in rain. Time to die...",
+			"8600dbd41e348fe5c9465ab92d23e3db8b98b873beecd930736488696438cb6b",
+			"547fe64427496db33bf66019dacbf0039c04199abb0122918601db38a72cfc21",
+		},
+	}
+
+	for _, test := range tests {
+		privateKey := NewPrivateKey(test.secret)
+		hash := sha256.Sum256([]byte(test.message))
+		z := new(big.Int).SetBytes(hash[:])
+		sig := privateKey.Sign(z)
+		fmt.Printf("sig is %s\n", sig)
+		assert.Equal(t, test.r, fmt.Sprintf("%064x", sig.r.num))
+		assert.Equal(t, test.s, fmt.Sprintf("%064x", sig.s.num))
+		// the same key and message always gives the same signature
+		assert.Equal(t, sig.Der(), privateKey.Sign(z).Der())
+		assert.True(t, privateKey.GetPublicKey().Verify(NewFieldElement(n, z), sig))
+	}
+}
+
+func TestSignWithEntropy(t *testing.T) {
+	privateKey := NewPrivateKey(big.NewInt(12345))
+	z := new(big.Int).SetBytes(Hash256("Testing my Signing"))
+	n := GetBitcoinValueN()
+
+	sig := privateKey.Sign(z)
+	assert.Equal(t, sig.Der(), privateKey.SignWithEntropy(z, nil).Der())
+	// extra entropy changes the nonce but the signature is still valid
+	entropySig := privateKey.SignWithEntropy(z, make([]byte, 32))
+	assert.NotEqual(t, sig.Der(), entropySig.Der())
+	assert.True(t, privateKey.GetPublicKey().Verify(NewFieldElement(n, z), entropySig))
+
+	// low r signature is at most 70 bytes in DER
+	for i := 0; i < 8; i++ {
+		z := new(big.Int).SetBytes(Hash256(fmt.Sprintf("low r %d", i)))
+		lowR := privateKey.SignLowR(z)
+		assert.True(t, lowR.r.num.BitLen() <= 255)
+		assert.True(t, len(lowR.Der()) <= 70)
+		assert.True(t, privateKey.GetPublicKey().Verify(NewFieldElement(n, z), lowR))
+	}
+}
+
+func TestWif(t *testing.T) {
+	// exercises of chapter 4 in Programming Bitcoin
+	secret := new(big.Int)
+	secret.SetString("54321deadbeef", 16)
+	vectors := []struct {
+		secret     *big.Int
+		compressed bool
+		testnet    bool
+		wif        string
+	}{
+		{big.NewInt(5003), true, true, "cMahea7zqjxrtgAbB7LSGbcQUr1uX1ojuat9jZodMN8rFTv2sfUK"},
+		{new(big.Int).Exp(big.NewInt(2021), big.NewInt(5), nil), false, true,
+			"91avARGdfge8E4tZfYLoxeJ5sGBdNJQH4kvjpWAxgzczjbCwxic"},
+		{secret, true, false, "KwDiBf89QgGbjEhKnhXJuH7LrciVrZi3qYjgiuQJv1h8Ytr2S53a"},
+		{big.NewInt(1), false, false, "5HpHagT65TZzG1PH3CSu63k8DbpvD8s5ip4nEB3kEsreAnchuDf"},
+	}
+	for _, vector := range vectors {
+		privateKey := NewPrivateKey(vector.secret)
+		wif := privateKey.Wif(vector.compressed, vector.testnet)
+		fmt.Printf("wif of %x: %s\n", vector.secret, wif)
+		assert.Equal(t, vector.wif, wif)
+
+		parsed, compressed, testnet, err := ParseWif(wif)
+		assert.Nil(t, err)
+		assert.Equal(t, vector.secret.String(), parsed.secret.big().String())
+		assert.Equal(t, vector.compressed, compressed)
+		assert.Equal(t, vector.testnet, testnet)
+		assert.True(t, privateKey.GetPublicKey().Equal(parsed.GetPublicKey()))
+	}
+
+	// p2pkh address is not a wif
+	_, _, _, err := ParseWif(NewPrivateKey(big.NewInt(5003)).GetPublicKey().Address(true, true))
+	assert.ErrorIs(t, err, ErrInvalidPrivateKey)
+	// wrong compressed flag
+	bad := append([]byte{WIF_MAINNET_PREFIX}, make([]byte, 31)...)
+	bad = append(bad, 0x01, 0x02)
+	_, _, _, err = ParseWif(Base58Checksum(bad))
+	assert.ErrorIs(t, err, ErrInvalidPrivateKey)
+	_, _, _, err = ParseWif("KwDiBf89QgGbjEhKnhXJuH7LrciVrZi3qYjgiuQJv1h8Ytr2S53b")
+	assert.ErrorIs(t, err, ErrBase58Checksum)
+}
+
+func TestNewPrivateKeyFromBytes(t *testing.T) {
+	secret := make([]byte, 32)
+	_, err := NewPrivateKeyFromBytes(secret)
+	assert.ErrorIs(t, err, ErrInvalidPrivateKey)
+
+	secret[31] = 0x01
+	privateKey, err := NewPrivateKeyFromBytes(secret)
+	assert.Nil(t, err)
+	assert.True(t, privateKey.GetPublicKey().Equal(GetGenerator()))
+
+	_, err = NewPrivateKeyFromBytes(GetBitcoinValueN().Bytes())
+	assert.ErrorIs(t, err, ErrInvalidPrivateKey)
+	nMinusOne := new(big.Int).Sub(GetBitcoinValueN(), big.NewInt(1))
+	_, err = NewPrivateKeyFromBytes(nMinusOne.Bytes())
+	assert.Nil(t, err)
+	_, err = NewPrivateKeyFromBytes(secret[1:])
+	assert.ErrorIs(t, err, ErrInvalidPrivateKey)
+
+	// secret out of range is never reduced into a valid key
+	assert.Panics(t, func() { NewPrivateKey(big.NewInt(0)) })
+	assert.Panics(t, func() { NewPrivateKey(new(big.Int).Add(GetBitcoinValueN(), big.NewInt(1))) })
+	assert.True(t, NewPrivateKey(nMinusOne).GetPublicKey().Equal(GetGenerator().ScalarMul(nMinusOne)))
+}
@@ -0,0 +1,310 @@
+package elliptic_curve
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+/*
+BIP340 Schnorr signature, the public key is only the x coordinate of the
+point (32 bytes), the y coordinate is always the even one. The signature is
+(R, s) with 32 bytes of x coordinate of R and 32 bytes of s:
+
+	s = k + e * d, e = hash(R || P || m)
+
+and s*G = R + e*P can be checked without any inversion. As the equation is
+linear, many signatures can be verified together faster than one by one.
+*/
+type SchnorrSignature struct {
+	r *big.Int // x coordinate of R, the y coordinate is even
+	s *big.Int
+}
+
+const (
+	TAG_BIP340_AUX       = "BIP0340/aux"
+	TAG_BIP340_NONCE     = "BIP0340/nonce"
+	TAG_BIP340_CHALLENGE = "BIP0340/challenge"
+)
+
+var ErrInvalidSchnorrSignature = errors.New("invalid schnorr signature")
+
+func TaggedHash(tag string, msgs ...[]byte) []byte {
+	/*
+		sha256(sha256(tag) || sha256(tag) || msg), hash for different
+		purpose has different prefix, they never collide with each other
+	*/
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, msg := range msgs {
+		h.Write(msg)
+	}
+	return h.Sum(nil)
+}
+
+func bytes32(num *big.Int) []byte {
+	b := make([]byte, 32)
+	num.FillBytes(b)
+	return b
+}
+
+func (p *Point) XOnly() []byte {
+	// x coordinate of the point in 32 bytes
+	return bytes32(p.x.num)
+}
+
+func (p *Point) HasEvenY() bool {
+	return p.y.num.Bit(0) == 0
+}
+
+func liftX(x *big.Int) (*Point, error) {
+	// the point with the given x and even y
+	p := secp256k1P
+	if x.Cmp(p) >= 0 {
+		return nil, fmt.Errorf("%w: x is not less than p", ErrInvalidPublicKey)
+	}
+	c := new(big.Int).Exp(x, big.NewInt(3), p)
+	c.Add(c, big.NewInt(7))
+	c.Mod(c, p)
+	exp := new(big.Int).Add(p, big.NewInt(1))
+	exp.Rsh(exp, 2)
+	y := new(big.Int).Exp(c, exp, p)
+	if new(big.Int).Exp(y, big.NewInt(2), p).Cmp(c) != 0 {
+		return nil, fmt.Errorf("%w: x is not on the curve", ErrInvalidPublicKey)
+	}
+	if y.Bit(0) == 1 {
+		y.Sub(p, y)
+	}
+	return S256Point(x, y), nil
+}
+
+func ParseXOnly(pubKey []byte) (*Point, error) {
+	if len(pubKey) != 32 {
+		return nil, fmt.Errorf("%w: x only public key length %d", ErrInvalidPublicKey, len(pubKey))
+	}
+	return liftX(new(big.Int).SetBytes(pubKey))
+}
+
+func NewSchnorrSignature(r, s *big.Int) *SchnorrSignature {
+	return &SchnorrSignature{
+		r: r,
+		s: s,
+	}
+}
+
+func ParseSchnorrSignature(sigBin []byte) (*SchnorrSignature, error) {
+	if len(sigBin) != 64 {
+		return nil, fmt.Errorf("%w: length %d", ErrInvalidSchnorrSignature, len(sigBin))
+	}
+	r := new(big.Int).SetBytes(sigBin[0:32])
+	s := new(big.Int).SetBytes(sigBin[32:64])
+	if r.Cmp(secp256k1P) >= 0 {
+		return nil, fmt.Errorf("%w: r is not less than p", ErrInvalidSchnorrSignature)
+	}
+	if s.Cmp(GetBitcoinValueN()) >= 0 {
+		return nil, fmt.Errorf("%w: s is not less than n", ErrInvalidSchnorrSignature)
+	}
+	return NewSchnorrSignature(r, s), nil
+}
+
+func (s *SchnorrSignature) Serialize() []byte {
+	return append(bytes32(s.r), bytes32(s.s)...)
+}
+
+func (s *SchnorrSignature) String() string {
+	return fmt.Sprintf("SchnorrSignature(r: {%x}, s: {%x})", s.r, s.s)
+}
+
+func schnorrChallenge(r *big.Int, pubKey []byte, msg []byte) *big.Int {
+	e := new(big.Int).SetBytes(TaggedHash(TAG_BIP340_CHALLENGE, bytes32(r), pubKey, msg))
+	return e.Mod(e, GetBitcoinValueN())
+}
+
+func (p *PrivateKey) SignSchnorr(msg []byte, auxRand []byte) (*SchnorrSignature, error) {
+	/*
+		1. d = secret, negate it if y of P is odd, then P = d*G has even y
+		2. t = d xor hash_aux(auxRand), mixing fresh randomness protects
+		   against side channel attacks, the nonce is still safe without it
+		3. k = hash_nonce(t || P || m), negate it if y of R = k*G is odd
+		4. s = k + e * d with e = hash_challenge(R || P || m)
+	*/
+	if len(auxRand) != 32 {
+		return nil, fmt.Errorf("aux rand should be 32 bytes, got %d", len(auxRand))
+	}
+	d := p.secret
+	if !p.point.HasEvenY() {
+		d = groupN.sub(limbs{}, d)
+	}
+	pubKey := p.point.XOnly()
+
+	t := d.bytes()
+	auxHash := TaggedHash(TAG_BIP340_AUX, auxRand)
+	for i := range t {
+		t[i] ^= auxHash[i]
+	}
+	// the nonce is secret, it is reduced and checked in constant time
+	k := groupN.reduce(limbsFromBytes(TaggedHash(TAG_BIP340_NONCE, t, pubKey, msg)))
+	if groupN.inRange(k) == 0 {
+		return nil, fmt.Errorf("nonce is zero")
+	}
+	rx, ry := ctScalarBaseMul(k)
+	if ry.Bit(0) == 1 {
+		k = groupN.sub(limbs{}, k)
+	}
+
+	e := schnorrChallenge(rx, pubKey, msg)
+	// s = k + e * d mod n, in Montgomery form
+	s := groupN.add(groupN.toMont(k), groupN.mul(groupN.toMont(limbsFromBig(e)), groupN.toMont(d)))
+	sig := NewSchnorrSignature(rx, groupN.fromMont(s).big())
+
+	// make sure we don't give out a wrong signature
+	if !p.point.VerifySchnorr(msg, sig) {
+		return nil, fmt.Errorf("created signature can't be verified")
+	}
+	return sig, nil
+}
+
+func (p *Point) VerifySchnorr(msg []byte, sig *SchnorrSignature) bool {
+	/*
+		compute R = s*G - e*P, it should not be the point at infinity, its
+		y should be even and its x should be r
+	*/
+	if p.x == nil || sig.r.Cmp(secp256k1P) >= 0 || sig.s.Cmp(GetBitcoinValueN()) >= 0 {
+		return false
+	}
+	pubKey := p.XOnly()
+	P, err := liftX(p.x.num)
+	if err != nil {
+		return false
+	}
+	e := schnorrChallenge(sig.r, pubKey, msg)
+	minusE := new(big.Int).Sub(GetBitcoinValueN(), e)
+	R := shamirMul(sig.s, minusE, P)
+	if R.x == nil || !R.HasEvenY() {
+		return false
+	}
+	return R.x.num.Cmp(sig.r) == 0
+}
+
+func VerifySchnorr(pubKey []byte, msg []byte, sigBin []byte) bool {
+	// verify with x only public key and the 64 bytes signature
+	P, err := ParseXOnly(pubKey)
+	if err != nil {
+		return false
+	}
+	sig, err := ParseSchnorrSignature(sigBin)
+	if err != nil {
+		return false
+	}
+	return P.VerifySchnorr(msg, sig)
+}
+
+func SchnorrBatchVerify(pubKeys []*Point, msgs [][]byte, sigs []*SchnorrSignature) bool {
+	/*
+		with random a_1 = 1, a_2, ..., a_u check
+		(s_1 + a_2*s_2 + ... + a_u*s_u) * G = R_1 + a_2*R_2 + ... + a_u*R_u
+		                                    + e_1*P_1 + a_2*e_2*P_2 + ... + a_u*e_u*P_u
+		all the multiplications share the same doublings, a forged signature
+		can only pass if the attacker guesses the random a
+	*/
+	if len(pubKeys) != len(msgs) || len(pubKeys) != len(sigs) {
+		return false
+	}
+	n := GetBitcoinValueN()
+	sSum := big.NewInt(0)
+	scalars := make([]*big.Int, 0, 2*len(sigs)+1)
+	tables := make([][]*jacobianPoint, 0, 2*len(sigs)+1)
+	windows := make([]uint, 0, 2*len(sigs)+1)
+	for i, sig := range sigs {
+		if pubKeys[i].x == nil || sig.r.Cmp(secp256k1P) >= 0 || sig.s.Cmp(n) >= 0 {
+			return false
+		}
+		P, err := liftX(pubKeys[i].x.num)
+		if err != nil {
+			return false
+		}
+		R, err := liftX(sig.r)
+		if err != nil {
+			return false
+		}
+
+		a := big.NewInt(1)
+		if i > 0 {
+			random := make([]byte, 32)
+			if _, err := rand.Read(random); err != nil {
+				panic(fmt.Sprintf("batch verify err with rand: %s", err))
+			}
+			a.SetBytes(random)
+			a.Mod(a, n)
+			if a.Sign() == 0 {
+				a.SetInt64(1)
+			}
+		}
+
+		e := schnorrChallenge(sig.r, P.XOnly(), msgs[i])
+		aS := new(big.Int).Mul(a, sig.s)
+		sSum.Add(sSum, aS)
+		// -a*R and -a*e*P, the total should be the point at infinity
+		minusA := new(big.Int).Sub(n, a)
+		minusAE := new(big.Int).Mul(minusA, e)
+		minusAE.Mod(minusAE, n)
+		scalars = append(scalars, minusA, minusAE)
+		tables = append(tables, oddMultiples(jacobianFromPoint(R), POINT_WINDOW),
+			oddMultiples(jacobianFromPoint(P), POINT_WINDOW))
+		windows = append(windows, POINT_WINDOW, POINT_WINDOW)
+	}
+	sSum.Mod(sSum, n)
+	scalars = append(scalars, sSum)
+	tables = append(tables, getGeneratorTable())
+	windows = append(windows, GENERATOR_WINDOW)
+
+	return multiScalarMul(scalars, tables, windows).isInfinity()
+}
+
+func TweakPublicKey(pubKey *Point, tweak []byte) (*Point, error) {
+	/*
+		Q = P + t*G with P of even y, taproot commits to the scripts by
+		putting their hash in the tweak t, the result has the y as it is
+	*/
+	t := new(big.Int).SetBytes(tweak)
+	if len(tweak) != 32 || t.Cmp(GetBitcoinValueN()) >= 0 {
+		return nil, fmt.Errorf("tweak should be 32 bytes less than n")
+	}
+	if pubKey.x == nil {
+		return nil, fmt.Errorf("%w: point at infinity", ErrInvalidPublicKey)
+	}
+	P, err := liftX(pubKey.x.num)
+	if err != nil {
+		return nil, err
+	}
+	Q := shamirMul(t, big.NewInt(1), P)
+	if Q.x == nil {
+		return nil, fmt.Errorf("tweaked public key is the point at infinity")
+	}
+	return Q, nil
+}
+
+func (p *PrivateKey) TweakAdd(tweak []byte) (*PrivateKey, error) {
+	/*
+		the private key of Q = P + t*G is d + t, d is negated when P has odd
+		y as the x only public key always means the even one
+	*/
+	n := GetBitcoinValueN()
+	t := new(big.Int).SetBytes(tweak)
+	if len(tweak) != 32 || t.Cmp(n) >= 0 {
+		return nil, fmt.Errorf("tweak should be 32 bytes less than n")
+	}
+	d := p.secret
+	if !p.point.HasEvenY() {
+		d = groupN.sub(limbs{}, d)
+	}
+	d = groupN.add(d, limbsFromBig(t))
+	if d == (limbs{}) {
+		return nil, fmt.Errorf("tweaked secret is zero")
+	}
+	return newPrivateKeyFromLimbs(d), nil
+}
@@ -0,0 +1,176 @@
+package elliptic_curve
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	assert.Nil(t, err)
+	return b
+}
+
+func TestSchnorrSign(t *testing.T) {
+	// signing vectors of BIP340 test-vectors.csv
+	tests := []struct {
+		secret    string
+		pubKey    string
+		auxRand   string
+		msg       string
+		signature string
+	}{
+		{
+			"0000000000000000000000000000000000000000000000000000000000000003",
+			"F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA821525F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0",
+		},
+		{
+			"B7E151628AED2A6ABF7158809CF4F3C762E7160F38B4DA56A784D9045190CFEF",
+			"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+			"0000000000000000000000000000000000000000000000000000000000000001",
+			"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+			"6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE33418906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A",
+		},
+		{
+			"C90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B14E5C9",
+			"DD308AFEC5777E13121FA72B9CC1B7CC0139715309B086C960E18FD969774EB8",
+			"C87AA53824B4D7AE2EB035A2B5BBBCCC080E76CDC6D1692C4B0B62D798E6D906",
+			"7E2D58D8B3BCDF1ABADEC7829054F90DDA9805AAB56C77333024B9D0A508B75C",
+			"5831AAEED7B44BB74E5EAB94BA9D4294C49BCF2A60728D8B4C200F50DD313C1BAB745879A5AD954A72C45A91C3A51D3C7ADEA98D82F8481E0E1E03674A6F3FB7",
+		},
+		{
+			"0B432B2677937381AEF05BB02A66ECD012773062CF3FA2549E44F58ED2401710",
+			"25D1DFF95105F5253C4022F628A996AD3A0D95FBF21D468A1B33F8C160D8F517",
+			"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+			"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+			"7EB0509757E246F19449885651611CB965ECC1A187DD51B64FDA1EDC9637D5EC97582B9CB13DB3933705B32BA982AF5AF25FD78881EBB32771FC5922EFC66EA3",
+		},
+	}
+
+	for i, test := range tests {
+		privateKey := NewPrivateKey(new(big.Int).SetBytes(decodeHex(t, test.secret)))
+		assert.Equal(t, decodeHex(t, test.pubKey), privateKey.GetPublicKey().XOnly(), "vector %d", i)
+		sig, err := privateKey.SignSchnorr(decodeHex(t, test.msg), decodeHex(t, test.auxRand))
+		assert.Nil(t, err)
+		assert.Equal(t, decodeHex(t, test.signature), sig.Serialize(), "vector %d", i)
+		fmt.Printf("schnorr signature %d: %s\n", i, sig)
+		assert.True(t, VerifySchnorr(decodeHex(t, test.pubKey), decodeHex(t, test.msg), decodeHex(t, test.signature)))
+	}
+}
+
+func TestSchnorrVerify(t *testing.T) {
+	// verification vectors of BIP340 test-vectors.csv
+	msg := "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89"
+	pubKey := "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659"
+	tests := []struct {
+		pubKey    string
+		msg       string
+		signature string
+		result    bool
+		comment   string
+	}{
+		{
+			"D69C3509BB99E412E68B0FE8544E72837DFA30746D8BE2AA65975F29D22DC7B9",
+			"4DF3C3F68FCC83B27E9D42C90431A72499F17875C81A599B566C9889B9696703",
+			"00000000000000000000003B78CE563F89A0ED9414F5AA28AD0D96D6795F9C6376AFB1548AF603B3EB45C9F8207DEE1060CB71C04E80F593060B07D28308D7F4",
+			true, "",
+		},
+		{
+			"EEFDEA4CDB677750A420FEE807EACF21EB9898AE79B9768766E4FAA04A2D4A34", msg,
+			"6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E17776969E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+			false, "public key not on the curve",
+		},
+		{
+			pubKey, msg,
+			"FFF97BD5755EEEA420453A14355235D382F6472F8568A18B2F057A14602975563CC27944640AC607CD107AE10923D9EF7A73C643E166BE5EBEAFA34B1AC553E2",
+			false, "has_even_y(R) is false",
+		},
+		{
+			pubKey, msg,
+			"1FA62E331EDBC21C394792D2AB1100A7B432B013DF3F6FF4F99FCB33E0E1515F28890B3EDB6E7189B630448B515CE4F8622A954CFE545735AAEA5134FCCDB2BD",
+			false, "negated message",
+		},
+		{
+			pubKey, msg,
+			"6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E177769961764B3AA9B2FFCB6EF947B6887A226E8D7C93E00C5ED0C1834FF0D0C2E6DA6",
+			false, "negated s value",
+		},
+		{
+			pubKey, msg,
+			"0000000000000000000000000000000000000000000000000000000000000000123DDA8328AF9C23A94C1FEECFD123BA4FB73476F0D594DCB65C6425BD186051",
+			false, "sG - eP is infinite, x(inf) defined as 0",
+		},
+		{
+			pubKey, msg,
+			"00000000000000000000000000000000000000000000000000000000000000017615FBAF5AE28864013C099742DEADB4DBA87F11AC6754F93780D5A1837CF197",
+			false, "sG - eP is infinite, x(inf) defined as 1",
+		},
+		{
+			pubKey, msg,
+			"4A298DACAE57395A15D0795DDBFD1DCB564DA82B0F269BC70A74F8220429BA1D69E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+			false, "sig[0:32] is not an X coordinate on the curve",
+		},
+		{
+			pubKey, msg,
+			"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F69E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+			false, "sig[0:32] is equal to field size",
+		},
+		{
+			pubKey, msg,
+			"6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E177769FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141",
+			false, "sig[32:64] is equal to curve order",
+		},
+		{
+			"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC30", msg,
+			"6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E17776969E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+			false, "public key is not a valid X coordinate because it exceeds the field size",
+		},
+	}
+
+	for _, test := range tests {
+		result := VerifySchnorr(decodeHex(t, test.pubKey), decodeHex(t, test.msg), decodeHex(t, test.signature))
+		assert.Equal(t, test.result, result, test.comment)
+	}
+
+	_, err := ParseXOnly(decodeHex(t, "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC30"))
+	assert.ErrorIs(t, err, ErrInvalidPublicKey)
+	_, err = ParseSchnorrSignature(make([]byte, 63))
+	assert.ErrorIs(t, err, ErrInvalidSchnorrSignature)
+}
+
+func TestSchnorrBatchVerify(t *testing.T) {
+	pubKeys := make([]*Point, 0)
+	msgs := make([][]byte, 0)
+	sigs := make([]*SchnorrSignature, 0)
+	for i := 1; i <= 5; i++ {
+		privateKey := NewPrivateKey(new(big.Int).SetBytes(Hash256(fmt.Sprintf("batch key %d", i))))
+		msg := Hash256(fmt.Sprintf("batch message %d", i))
+		sig, err := privateKey.SignSchnorr(msg, make([]byte, 32))
+		assert.Nil(t, err)
+		pubKeys = append(pubKeys, privateKey.GetPublicKey())
+		msgs = append(msgs, msg)
+		sigs = append(sigs, sig)
+	}
+	assert.True(t, SchnorrBatchVerify(pubKeys, msgs, sigs))
+
+	// one wrong message fails the whole batch
+	msgs[2] = Hash256("wrong message")
+	assert.False(t, SchnorrBatchVerify(pubKeys, msgs, sigs))
+	assert.False(t, SchnorrBatchVerify(pubKeys, msgs[0:2], sigs))
+}
+
+func TestTaggedHash(t *testing.T) {
+	// sha256(sha256(tag) || sha256(tag) || msg)
+	tagHash := sha256.Sum256([]byte(TAG_BIP340_CHALLENGE))
+	expected := sha256.Sum256(append(append(tagHash[:], tagHash[:]...), []byte("message")...))
+	assert.Equal(t, expected[:], TaggedHash(TAG_BIP340_CHALLENGE, []byte("mess"), []byte("age")))
+	assert.NotEqual(t, TaggedHash(TAG_BIP340_AUX), TaggedHash(TAG_BIP340_NONCE))
+}
@@ -0,0 +1,350 @@
+package elliptic_curve
+
+import (
+	"encoding/binary"
+	"math/big"
+	"math/bits"
+)
+
+/*
+Constant time arithmetic for secp256k1, FieldElement and Point use big.Int
+whose running time depends on the value, and ScalarMul branches on every
+bit of the scalar, this leaks the secret to anyone who can measure the time
+of signing. Here numbers are 4 limbs of 64 bits in little endian order, and
+operations on secret values never branch or index memory by the value.
+Only the secret key and the nonce k go through here, the results like the
+public key and the signature are public, they can be turned into big.Int.
+*/
+type limbs [4]uint64
+
+/*
+montModulus does Montgomery multiplication modulo m, value a is kept as
+a * R mod m with R = 2^256, then a * b * R can be computed from a * R and
+b * R without division
+*/
+type montModulus struct {
+	m       limbs
+	mInv    uint64 // -m^-1 mod 2^64
+	rr      limbs  // R^2 mod m, for converting into Montgomery form
+	one     limbs  // R mod m, which is 1 in Montgomery form
+	mMinus2 limbs  // exponent for inverse by Fermat's little theorem
+}
+
+func newMontModulus(m *big.Int) *montModulus {
+	// the modulus is public, big.Int is fine for the constants
+	mod := &montModulus{
+		m: limbsFromBig(m),
+	}
+	// Newton iteration doubles the correct bits of the inverse each time
+	inv := uint64(1)
+	for i := 0; i < 6; i++ {
+		inv *= 2 - mod.m[0]*inv
+	}
+	mod.mInv = -inv
+
+	r := new(big.Int).Lsh(big.NewInt(1), 256)
+	mod.one = limbsFromBig(new(big.Int).Mod(r, m))
+	mod.rr = limbsFromBig(new(big.Int).Mod(new(big.Int).Mul(r, r), m))
+	mod.mMinus2 = limbsFromBig(new(big.Int).Sub(m, big.NewInt(2)))
+	return mod
+}
+
+var (
+	fieldP = newMontModulus(S256Field(big.NewInt(0)).order)
+	groupN = newMontModulus(GetBitcoinValueN())
+)
+
+func limbsFromBytes(b []byte) limbs {
+	// b is 32 bytes in big endian
+	var l limbs
+	for i := 0; i < 4; i++ {
+		l[i] = binary.BigEndian.Uint64(b[24-8*i : 32-8*i])
+	}
+	return l
+}
+
+func (l limbs) bytes() []byte {
+	b := make([]byte, 32)
+	for i := 0; i < 4; i++ {
+		binary.BigEndian.PutUint64(b[24-8*i:32-8*i], l[i])
+	}
+	return b
+}
+
+func limbsFromBig(num *big.Int) limbs {
+	b := make([]byte, 32)
+	num.FillBytes(b)
+	return limbsFromBytes(b)
+}
+
+func (l limbs) big() *big.Int {
+	return new(big.Int).SetBytes(l.bytes())
+}
+
+func selectLimbs(mask uint64, a, b limbs) limbs {
+	// a if mask is all ones, b if mask is zero
+	var r limbs
+	for i := 0; i < 4; i++ {
+		r[i] = (a[i] & mask) | (b[i] &^ mask)
+	}
+	return r
+}
+
+func equalMask(a, b uint64) uint64 {
+	// all ones if a == b, zero otherwise
+	x := a ^ b
+	return ((x | -x) >> 63) - 1
+}
+
+func (m *montModulus) reduceOnce(t limbs, carry uint64) limbs {
+	/*
+		t + carry * 2^256 is less than 2m, subtract m if it is not less than m,
+		the subtraction is always done and the result is selected by mask
+	*/
+	var d limbs
+	var borrow uint64
+	for i := 0; i < 4; i++ {
+		d[i], borrow = bits.Sub64(t[i], m.m[i], borrow)
+	}
+	// keep t only when there is no carry and t < m
+	_, keep := bits.Sub64(carry, 0, borrow)
+	return selectLimbs(keep-1, d, t)
+}
+
+func (m *montModulus) reduce(a limbs) limbs {
+	// any 256 bits number is less than 2m for p and n, one subtraction is enough
+	return m.reduceOnce(a, 0)
+}
+
+func (m *montModulus) inRange(a limbs) uint64 {
+	// all ones if 0 < a < m, zero otherwise
+	var borrow uint64
+	for i := 0; i < 4; i++ {
+		_, borrow = bits.Sub64(a[i], m.m[i], borrow)
+	}
+	isZero := equalMask(a[0]|a[1]|a[2]|a[3], 0)
+	return -borrow &^ isZero
+}
+
+func (m *montModulus) add(a, b limbs) limbs {
+	var t limbs
+	var carry uint64
+	for i := 0; i < 4; i++ {
+		t[i], carry = bits.Add64(a[i], b[i], carry)
+	}
+	return m.reduceOnce(t, carry)
+}
+
+func (m *montModulus) sub(a, b limbs) limbs {
+	var t limbs
+	var borrow uint64
+	for i := 0; i < 4; i++ {
+		t[i], borrow = bits.Sub64(a[i], b[i], borrow)
+	}
+	// add m back if the result is negative
+	mask := -borrow
+	var carry uint64
+	for i := 0; i < 4; i++ {
+		t[i], carry = bits.Add64(t[i], m.m[i]&mask, carry)
+	}
+	return t
+}
+
+func (m *montModulus) mul(a, b limbs) limbs {
+	/*
+		coarsely integrated operand scanning: for each limb of b add a * b[i]
+		into t, then add a multiple of m to make the lowest limb zero and
+		shift t right by one limb, the result is a * b / R mod m
+	*/
+	var t [6]uint64
+	for i := 0; i < 4; i++ {
+		var carry uint64
+		for j := 0; j < 4; j++ {
+			hi, lo := bits.Mul64(a[j], b[i])
+			var c uint64
+			lo, c = bits.Add64(lo, t[j], 0)
+			hi += c
+			lo, c = bits.Add64(lo, carry, 0)
+			hi += c
+			t[j] = lo
+			carry = hi
+		}
+		t[4], t[5] = bits.Add64(t[4], carry, 0)
+
+		q := t[0] * m.mInv
+		hi, lo := bits.Mul64(q, m.m[0])
+		_, c := bits.Add64(lo, t[0], 0)
+		carry = hi + c
+		for j := 1; j < 4; j++ {
+			hi, lo := bits.Mul64(q, m.m[j])
+			lo, c = bits.Add64(lo, t[j], 0)
+			hi += c
+			lo, c = bits.Add64(lo, carry, 0)
+			hi += c
+			t[j-1] = lo
+			carry = hi
+		}
+		t[3], c = bits.Add64(t[4], carry, 0)
+		t[4] = t[5] + c
+	}
+	return m.reduceOnce(limbs{t[0], t[1], t[2], t[3]}, t[4])
+}
+
+func (m *montModulus) toMont(a limbs) limbs {
+	return m.mul(a, m.rr)
+}
+
+func (m *montModulus) fromMont(a limbs) limbs {
+	return m.mul(a, limbs{1, 0, 0, 0})
+}
+
+func (m *montModulus) exp(a limbs, e limbs) limbs {
+	/*
+		square and multiply, the exponent is public (m - 2 for inverse),
+		the branches depend only on it
+	*/
+	result := m.one
+	for i := 255; i >= 0; i-- {
+		result = m.mul(result, result)
+		if (e[i/64]>>(i%64))&1 == 1 {
+			result = m.mul(result, a)
+		}
+	}
+	return result
+}
+
+func (m *montModulus) inverse(a limbs) limbs {
+	// a^(m-2) = a^-1 mod m for prime m
+	return m.exp(a, m.mMinus2)
+}
+
+/*
+ctPoint is a point in projective coordinates (X : Y : Z) for x = X/Z and
+y = Y/Z, the values are in Montgomery form modulo p, the point at
+infinity is (0 : 1 : 0)
+*/
+type ctPoint struct {
+	x, y, z limbs
+}
+
+// 3 * b of y^2 = x^3 + 7 in Montgomery form
+var curveB3 = fieldP.toMont(limbs{21, 0, 0, 0})
+
+func ctInfinity() ctPoint {
+	return ctPoint{y: fieldP.one}
+}
+
+func ctPointFromAffine(x, y *big.Int) ctPoint {
+	return ctPoint{
+		x: fieldP.toMont(limbsFromBig(x)),
+		y: fieldP.toMont(limbsFromBig(y)),
+		z: fieldP.one,
+	}
+}
+
+func (p ctPoint) add(q ctPoint) ctPoint {
+	/*
+		complete addition for a = 0 by Renes, Costello and Batina (2015),
+		the same formula works for doubling and the point at infinity, so
+		there is no branch on the points
+	*/
+	f := fieldP
+	t0 := f.mul(p.x, q.x)
+	t1 := f.mul(p.y, q.y)
+	t2 := f.mul(p.z, q.z)
+	t3 := f.add(p.x, p.y)
+	t4 := f.add(q.x, q.y)
+	t3 = f.mul(t3, t4)
+	t4 = f.add(t0, t1)
+	t3 = f.sub(t3, t4)
+	t4 = f.add(p.y, p.z)
+	x3 := f.add(q.y, q.z)
+	t4 = f.mul(t4, x3)
+	x3 = f.add(t1, t2)
+	t4 = f.sub(t4, x3)
+	x3 = f.add(p.x, p.z)
+	y3 := f.add(q.x, q.z)
+	x3 = f.mul(x3, y3)
+	y3 = f.add(t0, t2)
+	y3 = f.sub(x3, y3)
+	x3 = f.add(t0, t0)
+	t0 = f.add(x3, t0)
+	t2 = f.mul(curveB3, t2)
+	z3 := f.add(t1, t2)
+	t1 = f.sub(t1, t2)
+	y3 = f.mul(curveB3, y3)
+	x3 = f.mul(t4, y3)
+	t2 = f.mul(t3, t1)
+	x3 = f.sub(t2, x3)
+	y3 = f.mul(y3, t0)
+	t1 = f.mul(t1, z3)
+	y3 = f.add(t1, y3)
+	t0 = f.mul(t0, t3)
+	z3 = f.mul(z3, t4)
+	z3 = f.add(z3, t0)
+	return ctPoint{x: x3, y: y3, z: z3}
+}
+
+func (p ctPoint) affine() (*big.Int, *big.Int) {
+	// the result is public, returns nil for the point at infinity
+	if p.z == (limbs{}) {
+		return nil, nil
+	}
+	zInv := fieldP.inverse(p.z)
+	x := fieldP.fromMont(fieldP.mul(p.x, zInv))
+	y := fieldP.fromMont(fieldP.mul(p.y, zInv))
+	return x.big(), y.big()
+}
+
+func ctScalarMul(p ctPoint, scalar limbs) ctPoint {
+	/*
+		fixed window of 4 bits, the table has 0*P to 15*P, every window does
+		4 doublings and one addition, the table entry is picked by scanning
+		the whole table, so the memory access doesn't depend on the scalar
+	*/
+	var table [16]ctPoint
+	table[0] = ctInfinity()
+	for i := 1; i < 16; i++ {
+		table[i] = table[i-1].add(p)
+	}
+
+	result := ctInfinity()
+	for i := 63; i >= 0; i-- {
+		for j := 0; j < 4; j++ {
+			result = result.add(result)
+		}
+		window := (scalar[i/16] >> (4 * (i % 16))) & 0xf
+		selected := ctInfinity()
+		for j := 0; j < 16; j++ {
+			mask := equalMask(uint64(j), window)
+			selected.x = selectLimbs(mask, table[j].x, selected.x)
+			selected.y = selectLimbs(mask, table[j].y, selected.y)
+			selected.z = selectLimbs(mask, table[j].z, selected.z)
+		}
+		result = result.add(selected)
+	}
+	return result
+}
+
+var ctGenerator = ctPointFromAffine(GetGenerator().x.num, GetGenerator().y.num)
+
+func ctScalarBaseMul(scalar limbs) (*big.Int, *big.Int) {
+	return ctScalarMul(ctGenerator, scalar).affine()
+}
+
+func ctSign(secret, k, z limbs) (*big.Int, *big.Int) {
+	/*
+		s = (z + r * e) / k mod n, the values are less than n, z is reduced
+		by the caller, r is the x coordinate of k*G mod n
+	*/
+	rx, _ := ctScalarBaseMul(k)
+	r := new(big.Int).Mod(rx, GetBitcoinValueN())
+
+	n := groupN
+	rMont := n.toMont(limbsFromBig(r))
+	eMont := n.toMont(secret)
+	zMont := n.toMont(z)
+	kInv := n.inverse(n.toMont(k))
+	s := n.mul(n.add(zMont, n.mul(rMont, eMont)), kInv)
+	return r, n.fromMont(s).big()
+}
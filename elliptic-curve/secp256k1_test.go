@@ -0,0 +1,101 @@
+package elliptic_curve
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMontgomeryArithmetic(t *testing.T) {
+	for _, mod := range []*montModulus{fieldP, groupN} {
+		m := mod.m.big()
+		values := []*big.Int{big.NewInt(0), big.NewInt(1), big.NewInt(2),
+			new(big.Int).Sub(m, big.NewInt(1)),
+			new(big.Int).SetBytes(Hash256("montgomery a")),
+			new(big.Int).SetBytes(Hash256("montgomery b"))}
+		for _, a := range values {
+			a = new(big.Int).Mod(a, m)
+			aMont := mod.toMont(limbsFromBig(a))
+			assert.Equal(t, a.String(), mod.fromMont(aMont).big().String())
+			for _, b := range values {
+				b = new(big.Int).Mod(b, m)
+				bMont := mod.toMont(limbsFromBig(b))
+
+				expected := new(big.Int).Mul(a, b)
+				expected.Mod(expected, m)
+				assert.Equal(t, expected.String(), mod.fromMont(mod.mul(aMont, bMont)).big().String())
+				expected = new(big.Int).Add(a, b)
+				expected.Mod(expected, m)
+				assert.Equal(t, expected.String(), mod.fromMont(mod.add(aMont, bMont)).big().String())
+				expected = new(big.Int).Sub(a, b)
+				expected.Mod(expected, m)
+				assert.Equal(t, expected.String(), mod.fromMont(mod.sub(aMont, bMont)).big().String())
+			}
+			if a.Sign() != 0 {
+				expected := new(big.Int).ModInverse(a, m)
+				assert.Equal(t, expected.String(), mod.fromMont(mod.inverse(aMont)).big().String())
+			}
+		}
+	}
+}
+
+func TestConstantTimeScalarMul(t *testing.T) {
+	// the constant time backend gives the same point as Point.ScalarMul
+	n := GetBitcoinValueN()
+	G := GetGenerator()
+	scalars := []*big.Int{big.NewInt(1), big.NewInt(2), big.NewInt(15), big.NewInt(16),
+		big.NewInt(12345), new(big.Int).Sub(n, big.NewInt(1)),
+		new(big.Int).Lsh(big.NewInt(1), 255),
+		new(big.Int).SetBytes(Hash256("scalar"))}
+	for _, scalar := range scalars {
+		scalar = new(big.Int).Mod(scalar, n)
+		expected := G.ScalarMul(scalar)
+		x, y := ctScalarBaseMul(limbsFromBig(scalar))
+		assert.Equal(t, expected.x.num, x, "scalar %x", scalar)
+		assert.Equal(t, expected.y.num, y, "scalar %x", scalar)
+	}
+
+	// n * G and 0 * G are the point at infinity
+	x, y := ctScalarBaseMul(limbsFromBig(big.NewInt(0)))
+	assert.Nil(t, x)
+	assert.Nil(t, y)
+
+	// other point than G
+	P := G.ScalarMul(big.NewInt(8675309))
+	scalar := new(big.Int).SetBytes(Hash256("other point"))
+	scalar.Mod(scalar, n)
+	x, y = ctScalarMul(ctPointFromAffine(P.x.num, P.y.num), limbsFromBig(scalar)).affine()
+	expected := P.ScalarMul(scalar)
+	assert.Equal(t, expected.x.num, x)
+	assert.Equal(t, expected.y.num, y)
+	fmt.Printf("ct scalar mul: %x\n", x)
+}
+
+func TestPrivateKeyPublicPoint(t *testing.T) {
+	secret := new(big.Int).SetBytes(Hash256("my secret"))
+	secret.Mod(secret, GetBitcoinValueN())
+	privateKey := NewPrivateKey(secret)
+	assert.True(t, privateKey.GetPublicKey().Equal(GetGenerator().ScalarMul(secret)))
+}
+
+func TestScalarInRange(t *testing.T) {
+	n := GetBitcoinValueN()
+	cases := map[*big.Int]bool{
+		big.NewInt(0):                      false,
+		big.NewInt(1):                      true,
+		new(big.Int).Sub(n, big.NewInt(1)): true,
+		n:                                  false,
+		new(big.Int).Add(n, big.NewInt(1)): false,
+		new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1)): false,
+	}
+	for num, expected := range cases {
+		assert.Equal(t, expected, groupN.inRange(limbsFromBig(num)) != 0, "%x", num)
+	}
+
+	// 2^256 - 1 is reduced to 2^256 - 1 - n
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	assert.Equal(t, new(big.Int).Sub(max, n).String(), groupN.reduce(limbsFromBig(max)).big().String())
+	assert.Equal(t, "0", groupN.reduce(limbsFromBig(n)).big().String())
+}
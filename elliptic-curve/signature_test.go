@@ -1,9 +1,12 @@
 package elliptic_curve
 
 import (
+	"encoding/hex"
 	"fmt"
 	"math/big"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestSIgnatureDer(t *testing.T) {
@@ -17,6 +20,52 @@ func TestSIgnatureDer(t *testing.T) {
 	derEncode := sig.Der()
 	fmt.Printf("der encoding for signature is %x\n", derEncode)
 
-	sig2 := ParseSigBin(derEncode)
+	sig2, err := ParseSigBin(derEncode)
+	assert.Nil(t, err)
 	fmt.Printf("signature parsed from raw binary data is %s\n", sig2)
+	assert.Equal(t, derEncode, sig2.Der())
+}
+
+func TestParseSigBinError(t *testing.T) {
+	der, err := hex.DecodeString("3045022100ed81ff192e75a3fd2304004dcadb746fa5e24c5031ccfcf21320b0277457c98f02207a986d955c6e0cb35d446a89d3f56100f4d7f67801c31967743a9c8e10615bed")
+	assert.Nil(t, err)
+	_, err = ParseSigBin(der)
+	assert.Nil(t, err)
+
+	_, err = ParseSigBin(der[0:40])
+	assert.ErrorIs(t, err, ErrTruncatedInput)
+
+	// wrong first byte
+	bad := append([]byte{}, der...)
+	bad[0] = 0x31
+	_, err = ParseSigBin(bad)
+	assert.ErrorIs(t, err, ErrNonCanonicalDER)
+
+	// r without the 0x00 is negative
+	bad = append([]byte{0x30, 0x44, 0x02, 0x20}, der[5:]...)
+	_, err = ParseSigBin(bad)
+	assert.ErrorIs(t, err, ErrNonCanonicalDER)
+
+	// unnecessary zero padding for s
+	bad = append([]byte{0x30, 0x46}, der[2:37]...)
+	bad = append(bad, 0x02, 0x21, 0x00)
+	bad = append(bad, der[39:]...)
+	_, err = ParseSigBin(bad)
+	assert.ErrorIs(t, err, ErrNonCanonicalDER)
+
+	// trailing data after s
+	bad = append([]byte{0x30, 0x46}, der[2:]...)
+	bad = append(bad, 0x00)
+	_, err = ParseSigBin(bad)
+	assert.ErrorIs(t, err, ErrNonCanonicalDER)
+}
+
+func TestParseSigBinOverflow(t *testing.T) {
+	// r = n is strict DER, it parses but never verifies
+	der := append([]byte{0x30, 0x26, 0x02, 0x21, 0x00}, GetBitcoinValueN().Bytes()...)
+	der = append(der, 0x02, 0x01, 0x01)
+	sig, err := ParseSigBin(der)
+	assert.Nil(t, err)
+	point := GetGenerator().ScalarMul(big.NewInt(12345))
+	assert.False(t, point.Verify(S256Field(big.NewInt(1)), sig))
 }
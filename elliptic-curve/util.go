@@ -1,9 +1,9 @@
 package elliptic_curve
 
 import (
-	"bufio"
 	"bytes"
 	"crypto/sha256"
+	"fmt"
 	"math/big"
 	"strings"
 
@@ -33,23 +33,54 @@ func GetBitcoinValueN() *big.Int {
 	return n
 }
 
-func ParseSEC(secBin []byte) *Point {
+func ParseSEC(secBin []byte) (*Point, error) {
+	if len(secBin) == 0 {
+		return nil, ErrTruncatedInput
+	}
+
+	p := S256Field(big.NewInt(0)).order
 	// check the first byte to descide it is compressed or uncompressed
 	if secBin[0] == 4 {
 		// uncompressed
+		if len(secBin) != 65 {
+			return nil, fmt.Errorf("%w: uncompressed key with length %d", ErrInvalidPublicKey, len(secBin))
+		}
 		x := new(big.Int)
 		x.SetBytes(secBin[1:33])
 		y := new(big.Int)
 		y.SetBytes(secBin[33:65])
-		return S256Point(x, y)
+		if x.Cmp(p) >= 0 || y.Cmp(p) >= 0 {
+			return nil, fmt.Errorf("%w: coordinate out of field range", ErrInvalidPublicKey)
+		}
+		// y^2 = x^3 + 7
+		left := S256Field(y).Power(big.NewInt(2))
+		right := S256Field(x).Power(big.NewInt(3)).Add(S256Field(big.NewInt(7)))
+		if !left.EqualTo(right) {
+			return nil, fmt.Errorf("%w: point is not on the curve", ErrInvalidPublicKey)
+		}
+		return S256Point(x, y), nil
+	}
+
+	if secBin[0] != 2 && secBin[0] != 3 {
+		return nil, fmt.Errorf("%w: unknown prefix %x", ErrInvalidPublicKey, secBin[0])
+	}
+	if len(secBin) != 33 {
+		return nil, fmt.Errorf("%w: compressed key with length %d", ErrInvalidPublicKey, len(secBin))
 	}
 
 	// check first byte for y is odd or even
 	isEven := (secBin[0] == 2)
 	x := new(big.Int)
 	x.SetBytes(secBin[1:])
+	if x.Cmp(p) >= 0 {
+		return nil, fmt.Errorf("%w: coordinate out of field range", ErrInvalidPublicKey)
+	}
 	y2 := S256Field(x).Power(big.NewInt(3)).Add(S256Field(big.NewInt(7)))
 	y := y2.Sqrt()
+	// not every x has a point on the curve
+	if !y.Power(big.NewInt(2)).EqualTo(y2) {
+		return nil, fmt.Errorf("%w: point is not on the curve", ErrInvalidPublicKey)
+	}
 	var modOp big.Int
 	var yEven *FieldElement
 	var yOdd *FieldElement
@@ -62,9 +93,9 @@ func ParseSEC(secBin []byte) *Point {
 	}
 
 	if isEven {
-		return S256Point(x, yEven.num)
+		return S256Point(x, yEven.num), nil
 	} else {
-		return S256Point(x, yOdd.num)
+		return S256Point(x, yOdd.num), nil
 	}
 }
 
@@ -72,7 +103,16 @@ func ParseSEC(secBin []byte) *Point {
 base58 it removes 0 o I l
 */
 
-func DecodeBase58(s string) []byte {
+func DecodeBase58(s string) ([]byte, error) {
+	_, payload, err := DecodeBase58Check(s)
+	return payload, err
+}
+
+func DecodeBase58Check(s string) (byte, []byte, error) {
+	/*
+		decode base58 with checksum, return the first byte as network
+		prefix(version) and the payload following it
+	*/
 	BASE58_ALPHABET := "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
 	num := big.NewInt(int64(0))
 	for _, char := range s {
@@ -80,20 +120,31 @@ func DecodeBase58(s string) []byte {
 		num = mulOp.Mul(num, big.NewInt(int64(58)))
 		idx := strings.Index(BASE58_ALPHABET, string(char))
 		if idx == -1 {
-			panic("can't find char in base58 alphabet")
+			return 0, nil, fmt.Errorf("%w: can't find char %q in base58 alphabet", ErrInvalidBase58, char)
 		}
 		addOp := new(big.Int)
 		num = addOp.Add(num, big.NewInt(int64(idx)))
 	}
-	combined := num.Bytes()
+
+	// every leading 1 is a zero byte, they are lost when converting to number
+	combined := []byte{}
+	for i := 0; i < len(s) && s[i] == '1'; i++ {
+		combined = append(combined, 0x00)
+	}
+	combined = append(combined, num.Bytes()...)
+	// at least one byte of prefix and four bytes of checksum
+	if len(combined) < 5 {
+		return 0, nil, fmt.Errorf("%w: decoded length %d", ErrTruncatedInput, len(combined))
+	}
+
 	checksum := combined[len(combined)-4:]
 	h256 := Hash256(string(combined[0 : len(combined)-4]))
-	if bytes.Equal(h256[0:4], checksum) != true {
-		panic("decode base58 checksum error")
+	if !bytes.Equal(h256[0:4], checksum) {
+		return 0, nil, ErrBase58Checksum
 	}
 
 	// first byte is network prefix
-	return combined[1 : len(combined)-4]
+	return combined[0], combined[1 : len(combined)-4], nil
 }
 
 func EncodeBase58(s []byte) string {
@@ -140,57 +191,82 @@ func Hash160(s []byte) []byte {
 	return hashBytes
 }
 
-func ParseSigBin(sigBin []byte) *Signature {
-	reader := bytes.NewReader(sigBin)
-	bufReader := bufio.NewReader(reader)
+func ParseSigBin(sigBin []byte) (*Signature, error) {
+	/*
+		only strict DER encoding is accepted(BIP66), otherwise the same
+		signature can have different binary forms:
+		0x30 <total length> 0x02 <length of r> <r> 0x02 <length of s> <s>
+		r and s are positive, they don't have unnecessary 0x00 at the head
+	*/
+	if len(sigBin) < 2 {
+		return nil, ErrTruncatedInput
+	}
 	// first byte should be 0x30
-	firstByte := make([]byte, 1)
-	bufReader.Read(firstByte)
-	if firstByte[0] != 0x30 {
-		panic("Bad signature, first byte is not 0x30")
-	}
-
-	// second byte is the length of r and s
-	lenBuf := make([]byte, 1)
-	bufReader.Read(lenBuf)
-	// first two byte with the length of r and s should be the total length of sigBin
-	if lenBuf[0]+2 != byte(len(sigBin)) {
-		panic("Bad signature length")
-	}
-
-	// marker of 0x02 as the beginning of r
-	marker := make([]byte, 1)
-	bufReader.Read(marker)
-	if marker[0] != 0x02 {
-		panic("signature marker for r is not 0x02")
-	}
-	lenBuf = make([]byte, 1)
-	bufReader.Read(lenBuf)
-	rLength := lenBuf[0]
-	rBin := make([]byte, rLength)
-	// it may have 0x00 append at the head, but it dose not affect the value of r
-	bufReader.Read(rBin)
-	r := new(big.Int)
-	r.SetBytes(rBin)
-
-	// marker of 0x02 for the beginning of s
-	marker = make([]byte, 1)
-	bufReader.Read(marker)
-	if marker[0] != 0x02 {
-		panic("signature marker for s is not 0x02")
-	}
-	lenBuf = make([]byte, 1)
-	bufReader.Read(lenBuf)
-	sLength := lenBuf[0]
-	sBin := make([]byte, sLength)
-	bufReader.Read(sBin)
-	s := new(big.Int)
-	s.SetBytes(sBin)
-
-	if len(sigBin) != int(6+rLength+sLength) {
-		panic("signature wrong length ")
+	if sigBin[0] != 0x30 {
+		return nil, fmt.Errorf("%w: first byte is not 0x30", ErrNonCanonicalDER)
+	}
+	// second byte is the length of r and s, it should be the total length of sigBin
+	if int(sigBin[1])+2 > len(sigBin) {
+		return nil, ErrTruncatedInput
+	}
+	if int(sigBin[1])+2 != len(sigBin) {
+		return nil, fmt.Errorf("%w: bad signature length", ErrNonCanonicalDER)
+	}
+	if len(sigBin) < 8 || len(sigBin) > 72 {
+		return nil, fmt.Errorf("%w: signature length %d out of range", ErrNonCanonicalDER, len(sigBin))
+	}
+
+	r, rLength, err := parseDERInteger(sigBin[2:])
+	if err != nil {
+		return nil, fmt.Errorf("parse r: %w", err)
+	}
+	s, sLength, err := parseDERInteger(sigBin[4+rLength:])
+	if err != nil {
+		return nil, fmt.Errorf("parse s: %w", err)
 	}
 
+	if len(sigBin) != 6+rLength+sLength {
+		return nil, fmt.Errorf("%w: signature wrong length", ErrNonCanonicalDER)
+	}
+
+	/*
+		r or s not less than n is well encoded but can never verify, it is
+		not a parse error: the script pushes false for it like any other bad
+		signature. Same as libsecp256k1 the overflow becomes r = s = 0, which
+		Verify always rejects
+	*/
 	n := GetBitcoinValueN()
-	return NewSignature(NewFieldElement(n, r), NewFieldElement(n, s))
+	if r.Cmp(n) >= 0 || s.Cmp(n) >= 0 {
+		r, s = big.NewInt(0), big.NewInt(0)
+	}
+	return NewSignature(NewFieldElement(n, r), NewFieldElement(n, s)), nil
+}
+
+func parseDERInteger(bin []byte) (*big.Int, int, error) {
+	// marker of 0x02 as the beginning of integer, then the length of it
+	if len(bin) < 2 {
+		return nil, 0, ErrTruncatedInput
+	}
+	if bin[0] != 0x02 {
+		return nil, 0, fmt.Errorf("%w: integer marker is not 0x02", ErrNonCanonicalDER)
+	}
+	length := int(bin[1])
+	if length == 0 {
+		return nil, 0, fmt.Errorf("%w: zero length integer", ErrNonCanonicalDER)
+	}
+	if len(bin) < 2+length {
+		return nil, 0, ErrTruncatedInput
+	}
+	value := bin[2 : 2+length]
+	if value[0]&0x80 != 0 {
+		return nil, 0, fmt.Errorf("%w: negative integer", ErrNonCanonicalDER)
+	}
+	// 0x00 is only at the head when the following byte >= 0x80
+	if length > 1 && value[0] == 0x00 && value[1]&0x80 == 0 {
+		return nil, 0, fmt.Errorf("%w: integer with unnecessary zero padding", ErrNonCanonicalDER)
+	}
+
+	num := new(big.Int)
+	num.SetBytes(value)
+	return num, length, nil
 }
@@ -16,17 +16,15 @@ func TestParseSEC(t *testing.T) {
 	pubKey := privateKey.GetPublicKey()
 	fmt.Printf("public key is %s\n", pubKey)
 
-	secBinUnCompressed := new(big.Int)
 	_, secBytes := pubKey.Sec(false)
-	secBinUnCompressed.SetString(string(secBytes), 16)
-	unUnCompressedDecode := ParseSEC(secBinUnCompressed.Bytes())
+	unUnCompressedDecode, err := ParseSEC(secBytes)
+	assert.Nil(t, err)
 	fmt.Printf("decode sec uncompressed format: %s\n", unUnCompressedDecode)
 	assert.True(t, pubKey.Equal(unUnCompressedDecode))
 
-	secBinCompressed := new(big.Int)
 	_, secBytes = pubKey.Sec(true)
-	secBinCompressed.SetString(string(secBytes), 16)
-	compressedDecode := ParseSEC(secBinCompressed.Bytes())
+	compressedDecode, err := ParseSEC(secBytes)
+	assert.Nil(t, err)
 	fmt.Printf("decode sec compressed format: %s\n", compressedDecode)
 	assert.True(t, pubKey.Equal(compressedDecode))
 }
@@ -42,3 +40,52 @@ func TestEncodeBase58(t *testing.T) {
 	val.SetString("c7207fee197d27c618aea621406f6bf5ef6fca38681d82b2f06fddbdce6feab6", 16)
 	fmt.Printf("base58 encoding is %s\n", EncodeBase58(val.Bytes()))
 }
+
+func TestParseSECError(t *testing.T) {
+	_, secBytes := NewPrivateKey(big.NewInt(int64(12345))).GetPublicKey().Sec(true)
+
+	_, err := ParseSEC([]byte{})
+	assert.ErrorIs(t, err, ErrTruncatedInput)
+	_, err = ParseSEC(secBytes[0:32])
+	assert.ErrorIs(t, err, ErrInvalidPublicKey)
+
+	badPrefix := append([]byte{0x05}, secBytes[1:]...)
+	_, err = ParseSEC(badPrefix)
+	assert.ErrorIs(t, err, ErrInvalidPublicKey)
+
+	// x = 5 has no point on the curve
+	notOnCurve := make([]byte, 33)
+	notOnCurve[0] = 0x02
+	notOnCurve[32] = 0x05
+	_, err = ParseSEC(notOnCurve)
+	assert.ErrorIs(t, err, ErrInvalidPublicKey)
+
+	_, uncompressed := NewPrivateKey(big.NewInt(int64(12345))).GetPublicKey().Sec(false)
+	uncompressed[64] ^= 0x01
+	_, err = ParseSEC(uncompressed)
+	assert.ErrorIs(t, err, ErrInvalidPublicKey)
+}
+
+func TestDecodeBase58(t *testing.T) {
+	// p2pkh address with version byte 0x00 and hash160 with leading zeros
+	h160 := make([]byte, 20)
+	h160[19] = 0x01
+	address := Base58Checksum(append([]byte{0x00}, h160...))
+	assert.Equal(t, "11111111111111111111BZbvjr", address)
+	decoded, err := DecodeBase58(address)
+	assert.Nil(t, err)
+	assert.Equal(t, h160, decoded)
+
+	pubKey := NewPrivateKey(big.NewInt(int64(5002))).GetPublicKey()
+	address = pubKey.Address(false, true)
+	decoded, err = DecodeBase58(address)
+	assert.Nil(t, err)
+	assert.Equal(t, pubKey.hash160(false), decoded)
+
+	_, err = DecodeBase58("mmTPbXQFxboEtNRkwfh6K51jvdtHLxGeM0")
+	assert.ErrorIs(t, err, ErrInvalidBase58)
+	_, err = DecodeBase58("mmTPbXQFxboEtNRkwfh6K51jvdtHLxGeMB")
+	assert.ErrorIs(t, err, ErrBase58Checksum)
+	_, err = DecodeBase58("1")
+	assert.ErrorIs(t, err, ErrTruncatedInput)
+}
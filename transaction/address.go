@@ -0,0 +1,146 @@
+package transaction
+
+import (
+	ecc "elliptic_curve"
+	"fmt"
+	"strings"
+)
+
+type Network int
+
+const (
+	MAINNET Network = iota
+	TESTNET
+	REGTEST
+)
+
+func (n Network) String() string {
+	switch n {
+	case TESTNET:
+		return "testnet"
+	case REGTEST:
+		return "regtest"
+	}
+	return "mainnet"
+}
+
+func (n Network) IsTestnet() bool {
+	// regtest uses the same prefixes as testnet for base58 addresses
+	return n != MAINNET
+}
+
+type AddressType int
+
+const (
+	ADDRESS_P2PKH AddressType = iota
+	ADDRESS_P2SH
+	ADDRESS_P2WPKH
+	ADDRESS_P2WSH
+	ADDRESS_P2TR
+	// witness version 2 to 16, or version 1 program not of 32 bytes
+	ADDRESS_WITNESS_UNKNOWN
+)
+
+func (a AddressType) String() string {
+	switch a {
+	case ADDRESS_P2PKH:
+		return "p2pkh"
+	case ADDRESS_P2SH:
+		return "p2sh"
+	case ADDRESS_P2WPKH:
+		return "p2wpkh"
+	case ADDRESS_P2WSH:
+		return "p2wsh"
+	case ADDRESS_P2TR:
+		return "p2tr"
+	}
+	return "witness_unknown"
+}
+
+// Address is the result of parsing an address string
+type Address struct {
+	Network      Network
+	Type         AddressType
+	ScriptPubKey *ScriptSig
+}
+
+func (a *Address) String() string {
+	return fmt.Sprintf("%s %s address, scriptPubKey: %x", a.Network, a.Type, a.ScriptPubKey.rawSerialize())
+}
+
+func ParseAddress(address string) (*Address, error) {
+	/*
+		bech32 address begins with the human readable part of the network,
+		otherwise it is base58 address, the prefix byte tells the network and
+		the type. Base58 address of testnet can also be used on regtest, it is
+		reported as testnet
+	*/
+	if isSegwitAddress(address) {
+		return parseSegwitAddress(address)
+	}
+
+	prefix, payload, err := ecc.DecodeBase58Check(address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+	}
+	if len(payload) != 20 {
+		return nil, fmt.Errorf("%w: payload length %d", ErrInvalidAddress, len(payload))
+	}
+	switch prefix {
+	case P2PKH_MAINNET_PREFIX:
+		return &Address{Network: MAINNET, Type: ADDRESS_P2PKH, ScriptPubKey: P2pkScript(payload)}, nil
+	case P2SH_MAINNET_PREFIX:
+		return &Address{Network: MAINNET, Type: ADDRESS_P2SH, ScriptPubKey: P2shScript(payload)}, nil
+	case P2PKH_TESTNET_PREFIX:
+		return &Address{Network: TESTNET, Type: ADDRESS_P2PKH, ScriptPubKey: P2pkScript(payload)}, nil
+	case P2SH_TESTNET_PREFIX:
+		return &Address{Network: TESTNET, Type: ADDRESS_P2SH, ScriptPubKey: P2shScript(payload)}, nil
+	}
+	return nil, fmt.Errorf("%w: unknown prefix %x", ErrInvalidAddress, prefix)
+}
+
+func isSegwitAddress(address string) bool {
+	/*
+		the version byte fixes the first character of a base58 address, it is
+		'1' or '3' on mainnet and 'm', 'n' or '2' on testnet, so it never
+		begins with the human readable part of bech32
+	*/
+	lower := strings.ToLower(address)
+	for _, hrp := range []string{ecc.BECH32_MAINNET_HRP, ecc.BECH32_TESTNET_HRP, ecc.BECH32_REGTEST_HRP} {
+		if strings.HasPrefix(lower, hrp+"1") {
+			return true
+		}
+	}
+	return false
+}
+
+func parseSegwitAddress(address string) (*Address, error) {
+	// bech32 address has the witness version and program, the scriptPubKey is OP_n <program>
+	hrp, version, program, err := ecc.DecodeSegwitAddress(address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+	}
+	result := &Address{ScriptPubKey: WitnessProgramScript(version, program)}
+	switch hrp {
+	case ecc.BECH32_MAINNET_HRP:
+		result.Network = MAINNET
+	case ecc.BECH32_TESTNET_HRP:
+		result.Network = TESTNET
+	case ecc.BECH32_REGTEST_HRP:
+		result.Network = REGTEST
+	default:
+		return nil, fmt.Errorf("%w: unknown human readable part %s", ErrInvalidAddress, hrp)
+	}
+
+	switch {
+	case version == 0 && len(program) == 20:
+		result.Type = ADDRESS_P2WPKH
+	case version == 0:
+		result.Type = ADDRESS_P2WSH
+	case version == 1 && len(program) == 32:
+		result.Type = ADDRESS_P2TR
+	default:
+		result.Type = ADDRESS_WITNESS_UNKNOWN
+	}
+	return result, nil
+}
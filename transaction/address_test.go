@@ -0,0 +1,62 @@
+package transaction
+
+import (
+	ecc "elliptic_curve"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAddress(t *testing.T) {
+	pubKey := ecc.NewPrivateKey(big.NewInt(int64(8675309))).GetPublicKey()
+	h160 := ecc.Hash160(secBytes(pubKey, true))
+	p2trAddress, err := pubKey.P2trAddress(ecc.BECH32_REGTEST_HRP)
+	assert.Nil(t, err)
+
+	vectors := []struct {
+		address      string
+		network      Network
+		addressType  AddressType
+		scriptPubKey string
+	}{
+		{pubKey.Address(true, false), MAINNET, ADDRESS_P2PKH, fmt.Sprintf("76a914%x88ac", h160)},
+		{pubKey.Address(true, true), TESTNET, ADDRESS_P2PKH, fmt.Sprintf("76a914%x88ac", h160)},
+		{"3CLoMMyuoDQTPRD3XYZtCvgvkadrAdvdXh", MAINNET, ADDRESS_P2SH,
+			"a91474d691da1574e6b3c192ecfb52cc8984ee7b6c5687"},
+		{ecc.Base58Checksum(append([]byte{P2SH_TESTNET_PREFIX}, h160...)), TESTNET, ADDRESS_P2SH,
+			fmt.Sprintf("a914%x87", h160)},
+		{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", MAINNET, ADDRESS_P2WPKH,
+			"0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", TESTNET, ADDRESS_P2WSH,
+			"00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{pubKey.P2wpkhAddress(ecc.BECH32_REGTEST_HRP), REGTEST, ADDRESS_P2WPKH, fmt.Sprintf("0014%x", h160)},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", MAINNET, ADDRESS_P2TR,
+			"512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+		{"BC1SW50QGDZ25J", MAINNET, ADDRESS_WITNESS_UNKNOWN, "6002751e"},
+	}
+	for _, vector := range vectors {
+		parsed, err := ParseAddress(vector.address)
+		assert.Nil(t, err, vector.address)
+		fmt.Printf("%s: %s\n", vector.address, parsed)
+		assert.Equal(t, vector.network, parsed.Network, vector.address)
+		assert.Equal(t, vector.addressType, parsed.Type, vector.address)
+		assert.Equal(t, vector.scriptPubKey, fmt.Sprintf("%x", parsed.ScriptPubKey.rawSerialize()), vector.address)
+	}
+
+	parsed, err := ParseAddress(p2trAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, REGTEST, parsed.Network)
+	assert.True(t, parsed.ScriptPubKey.IsP2trScriptPubKey())
+
+	// the scriptPubKey goes into the output directly
+	output := InitTransactionOutPut(big.NewInt(int64(1000)), parsed.ScriptPubKey)
+	assert.Equal(t, "e803000000000000225120", fmt.Sprintf("%x", output.Serialize()[0:11]))
+
+	for _, address := range []string{"", "bc1", "tc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq5zuyut",
+		"3CLoMMyuoDQTPRD3XYZtCvgvkadrAdvdXi", ecc.Base58Checksum(append([]byte{0x80}, h160...))} {
+		_, err := ParseAddress(address)
+		assert.ErrorIs(t, err, ErrInvalidAddress, address)
+	}
+}
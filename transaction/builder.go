@@ -0,0 +1,265 @@
+package transaction
+
+import (
+	"bytes"
+	ecc "elliptic_curve"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+const (
+	// base58 address prefix for mainnet and testnet
+	P2PKH_MAINNET_PREFIX = 0x00
+	P2SH_MAINNET_PREFIX  = 0x05
+	P2PKH_TESTNET_PREFIX = 0x6f
+	P2SH_TESTNET_PREFIX  = 0xc4
+	// change less than this is given to miners, nobody would spend it
+	DUST_LIMIT = 546
+)
+
+const (
+	/*
+		estimated size of each part of transaction with compressed public key,
+		signature is at most 72 bytes plus one byte of hash type
+	*/
+	TX_OVERHEAD_SIZE     = 10  // version, lock time, input and output count
+	SEGWIT_OVERHEAD_SIZE = 2   // marker and flag, in weight units
+	P2PKH_INPUT_SIZE     = 149 // 32 + 4 + 1 + (1 + 73 + 1 + 33) + 4
+	P2WPKH_INPUT_SIZE    = 41  // 32 + 4 + 1 + 4, with empty scriptSig
+	P2WPKH_WITNESS_SIZE  = 109 // 1 + (1 + 73) + (1 + 33), in weight units
+)
+
+func AddressToScriptPubKey(address string, testnet bool) (*ScriptSig, error) {
+	// the address should be for the network of the transaction
+	parsed, err := ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Network.IsTestnet() != testnet {
+		return nil, fmt.Errorf("%w: %s address is not for this network", ErrInvalidAddress, parsed.Network)
+	}
+	return parsed.ScriptPubKey, nil
+}
+
+// UTXO is the unspent output in our wallet, it can be spent by the builder
+type UTXO struct {
+	TxID         string
+	Index        uint32
+	Amount       *big.Int
+	ScriptPubKey *ScriptSig
+}
+
+func NewUTXO(txID string, index uint32, amount *big.Int, scriptPubKey *ScriptSig) *UTXO {
+	return &UTXO{
+		TxID:         txID,
+		Index:        index,
+		Amount:       amount,
+		ScriptPubKey: scriptPubKey,
+	}
+}
+
+/*
+TransactionBuilder selects utxos to pay the destinations and the fee, the
+amount left is sent back to the change address, then all the inputs are
+signed by the given private keys
+*/
+type TransactionBuilder struct {
+	utxos        []*UTXO
+	outputs      []*TransactionOutput
+	feeRate      int64 // satoshi per virtual byte
+	changeScript *ScriptSig
+	testnet      bool
+}
+
+func NewTransactionBuilder(testnet bool) *TransactionBuilder {
+	return &TransactionBuilder{
+		utxos:   make([]*UTXO, 0),
+		outputs: make([]*TransactionOutput, 0),
+		feeRate: 1,
+		testnet: testnet,
+	}
+}
+
+func (b *TransactionBuilder) AddUTXO(utxo *UTXO) {
+	b.utxos = append(b.utxos, utxo)
+}
+
+func (b *TransactionBuilder) AddDestination(address string, amount *big.Int) error {
+	script, err := AddressToScriptPubKey(address, b.testnet)
+	if err != nil {
+		return err
+	}
+	b.outputs = append(b.outputs, InitTransactionOutPut(amount, script))
+	return nil
+}
+
+func (b *TransactionBuilder) SetFeeRate(satoshiPerVByte int64) error {
+	// zero fee rate gives transaction nobody relays, negative is nonsense
+	if satoshiPerVByte <= 0 {
+		return fmt.Errorf("%w: %d satoshi per virtual byte", ErrInvalidFeeRate, satoshiPerVByte)
+	}
+	b.feeRate = satoshiPerVByte
+	return nil
+}
+
+func (b *TransactionBuilder) SetChangeAddress(address string) error {
+	script, err := AddressToScriptPubKey(address, b.testnet)
+	if err != nil {
+		return err
+	}
+	b.changeScript = script
+	return nil
+}
+
+func estimateVSize(utxos []*UTXO, outputs []*TransactionOutput) int64 {
+	/*
+		virtual size is weight / 4, data not in witness counts 4 weight units
+		for each byte, witness counts 1 weight unit for each byte
+	*/
+	size := int64(TX_OVERHEAD_SIZE)
+	witnessSize := int64(0)
+	for _, utxo := range utxos {
+		if utxo.ScriptPubKey.IsP2wpkhScriptPubKey() {
+			size += P2WPKH_INPUT_SIZE
+			witnessSize += P2WPKH_WITNESS_SIZE
+		} else {
+			size += P2PKH_INPUT_SIZE
+		}
+	}
+	for _, output := range outputs {
+		size += int64(len(output.Serialize()))
+	}
+	if witnessSize > 0 {
+		witnessSize += SEGWIT_OVERHEAD_SIZE
+	}
+
+	weight := size*4 + witnessSize
+	return (weight + 3) / 4
+}
+
+func (b *TransactionBuilder) selectUTXOs() ([]*UTXO, *big.Int, error) {
+	/*
+		take utxos with the largest amount first until they can pay the
+		destinations and the fee, return the change which may be zero
+	*/
+	candidates := append([]*UTXO{}, b.utxos...)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Amount.Cmp(candidates[j].Amount) > 0
+	})
+
+	target := big.NewInt(int64(0))
+	for _, output := range b.outputs {
+		target.Add(target, output.amount)
+	}
+
+	selected := make([]*UTXO, 0)
+	total := big.NewInt(int64(0))
+	for _, utxo := range candidates {
+		selected = append(selected, utxo)
+		total.Add(total, utxo.Amount)
+
+		// try with the change output first
+		changeScript := b.changeScript
+		if changeScript == nil {
+			changeScript = P2pkScript(make([]byte, 20))
+		}
+		withChange := append(append([]*TransactionOutput{}, b.outputs...),
+			InitTransactionOutPut(big.NewInt(int64(0)), changeScript))
+		fee := big.NewInt(b.feeRate * estimateVSize(selected, withChange))
+		change := new(big.Int).Sub(total, target)
+		change.Sub(change, fee)
+		if change.Cmp(big.NewInt(int64(DUST_LIMIT))) >= 0 {
+			if b.changeScript == nil {
+				return nil, nil, fmt.Errorf("%w: %v satoshi left", ErrNoChangeAddress, change)
+			}
+			return selected, change, nil
+		}
+
+		// the amount left is too small for change, it goes to the fee
+		fee = big.NewInt(b.feeRate * estimateVSize(selected, b.outputs))
+		left := new(big.Int).Sub(total, target)
+		if left.Cmp(fee) >= 0 {
+			return selected, big.NewInt(int64(0)), nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("%w: have %v satoshi, need %v satoshi and fee", ErrInsufficientFunds, total, target)
+}
+
+func findSigningKey(scriptPubKey *ScriptSig, privateKeys []*ecc.PrivateKey) (*ecc.PrivateKey, bool, error) {
+	/*
+		find the private key whose hash160 of public key is in the scriptPubKey,
+		return whether the compressed sec format is used
+	*/
+	var h160 []byte
+	var segwit bool
+	if scriptPubKey.IsP2pkhScriptPubKey() {
+		h160 = scriptPubKey.bitcoinOpCode.cmds[2]
+	} else if scriptPubKey.IsP2wpkhScriptPubKey() {
+		h160 = scriptPubKey.bitcoinOpCode.cmds[1]
+		segwit = true
+	} else {
+		return nil, false, ErrUnsupportedScript
+	}
+
+	for _, privateKey := range privateKeys {
+		_, sec := privateKey.GetPublicKey().Sec(true)
+		if bytes.Equal(ecc.Hash160(sec), h160) {
+			return privateKey, true, nil
+		}
+		// segwit only allows compressed public key
+		if segwit {
+			continue
+		}
+		_, sec = privateKey.GetPublicKey().Sec(false)
+		if bytes.Equal(ecc.Hash160(sec), h160) {
+			return privateKey, false, nil
+		}
+	}
+
+	return nil, false, ErrNoSigningKey
+}
+
+func (b *TransactionBuilder) Build(privateKeys []*ecc.PrivateKey) (*Transaction, error) {
+	/*
+		build the transaction with selected utxos as inputs, the destinations
+		and change as outputs, then sign every input, the signed transaction
+		is ready to broadcast by its Serialize()
+	*/
+	selected, change, err := b.selectUTXOs()
+	if err != nil {
+		return nil, err
+	}
+
+	txInputs := make([]*TransactionInput, 0)
+	for _, utxo := range selected {
+		prevTx, err := hex.DecodeString(utxo.TxID)
+		if err != nil || len(prevTx) != 32 {
+			return nil, fmt.Errorf("invalid utxo transaction id %s", utxo.TxID)
+		}
+		txInput := InitTransactionInput(prevTx, big.NewInt(int64(utxo.Index)))
+		txInput.SetScript(InitScriptSig([][]byte{}))
+		txInput.SetPreviousOutput(InitTransactionOutPut(utxo.Amount, utxo.ScriptPubKey))
+		txInputs = append(txInputs, txInput)
+	}
+
+	txOutputs := append([]*TransactionOutput{}, b.outputs...)
+	if change.Cmp(big.NewInt(int64(0))) > 0 {
+		txOutputs = append(txOutputs, InitTransactionOutPut(change, b.changeScript))
+	}
+
+	transaction := InitTransaction(big.NewInt(int64(1)), txInputs, txOutputs, big.NewInt(int64(0)), b.testnet)
+	for i, utxo := range selected {
+		privateKey, compressed, err := findSigningKey(utxo.ScriptPubKey, privateKeys)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+		if !transaction.signInput(i, privateKey, compressed) {
+			return nil, fmt.Errorf("input %d: %w", i, ErrSignatureInvalid)
+		}
+	}
+
+	return transaction, nil
+}
@@ -0,0 +1,215 @@
+package transaction
+
+import (
+	ecc "elliptic_curve"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddressToScriptPubKey(t *testing.T) {
+	pubKey := ecc.NewPrivateKey(big.NewInt(int64(5002))).GetPublicKey()
+	h160 := ecc.Hash160(secBytes(pubKey, false))
+
+	script, err := AddressToScriptPubKey(pubKey.Address(false, true), true)
+	assert.Nil(t, err)
+	assert.Equal(t, P2pkScript(h160).Serialize(), script.Serialize())
+
+	// testnet address on mainnet
+	_, err = AddressToScriptPubKey(pubKey.Address(false, true), false)
+	assert.ErrorIs(t, err, ErrInvalidAddress)
+
+	script, err = AddressToScriptPubKey(pubKey.Address(false, false), false)
+	assert.Nil(t, err)
+	assert.True(t, script.IsP2pkhScriptPubKey())
+
+	// p2sh address from the book
+	script, err = AddressToScriptPubKey("3CLoMMyuoDQTPRD3XYZtCvgvkadrAdvdXh", false)
+	assert.Nil(t, err)
+	assert.True(t, script.IsP2shScriptPubKey())
+	assert.Equal(t, "74d691da1574e6b3c192ecfb52cc8984ee7b6c56", fmt.Sprintf("%x", script.bitcoinOpCode.cmds[1]))
+
+	_, err = AddressToScriptPubKey("3CLoMMyuoDQTPRD3XYZtCvgvkadrAdvdXi", false)
+	assert.ErrorIs(t, err, ErrInvalidAddress)
+}
+
+func secBytes(pubKey *ecc.Point, compressed bool) []byte {
+	_, sec := pubKey.Sec(compressed)
+	return sec
+}
+
+func TestSignInput(t *testing.T) {
+	privateKey := ecc.NewPrivateKey(big.NewInt(int64(8675309)))
+	h160 := ecc.Hash160(secBytes(privateKey.GetPublicKey(), true))
+	prevTx := make([]byte, 32)
+	prevTx[0] = 0x01
+
+	for _, scriptPubKey := range []*ScriptSig{P2pkScript(h160), P2wpkhScript(h160)} {
+		txInput := InitTransactionInput(prevTx, big.NewInt(int64(0)))
+		txInput.SetScript(InitScriptSig([][]byte{}))
+		txInput.SetPreviousOutput(InitTransactionOutPut(big.NewInt(int64(10000)), scriptPubKey))
+		txOutput := InitTransactionOutPut(big.NewInt(int64(9000)), P2pkScript(h160))
+		transaction := InitTransaction(big.NewInt(int64(1)), []*TransactionInput{txInput},
+			[]*TransactionOutput{txOutput}, big.NewInt(int64(0)), true)
+
+		assert.True(t, transaction.SignInput(0, privateKey))
+		assert.Equal(t, scriptPubKey.IsP2wpkhScriptPubKey(), transaction.IsSegwit())
+		fee, err := transaction.Fee()
+		assert.Nil(t, err)
+		assert.Equal(t, big.NewInt(int64(1000)), fee)
+		assert.True(t, transaction.Verify())
+
+		// signed by other key
+		other := ecc.NewPrivateKey(big.NewInt(int64(12345)))
+		assert.False(t, transaction.SignInput(0, other))
+
+		// no such input
+		assert.False(t, transaction.SignInput(1, privateKey))
+		assert.False(t, transaction.SignInput(-1, privateKey))
+	}
+}
+
+func TestTransactionBuilder(t *testing.T) {
+	privateKey := ecc.NewPrivateKey(big.NewInt(int64(8675309)))
+	pubKey := privateKey.GetPublicKey()
+	h160 := ecc.Hash160(secBytes(pubKey, true))
+	changeAddress := pubKey.Address(true, true)
+	destination := ecc.NewPrivateKey(big.NewInt(int64(2024))).GetPublicKey().Address(true, true)
+
+	builder := NewTransactionBuilder(true)
+	builder.AddUTXO(NewUTXO("0000000000000000000000000000000000000000000000000000000000000001", 0,
+		big.NewInt(int64(50000)), P2pkScript(h160)))
+	builder.AddUTXO(NewUTXO("0000000000000000000000000000000000000000000000000000000000000002", 1,
+		big.NewInt(int64(30000)), P2wpkhScript(h160)))
+	builder.AddUTXO(NewUTXO("0000000000000000000000000000000000000000000000000000000000000003", 2,
+		big.NewInt(int64(10000)), P2pkScript(h160)))
+	assert.Nil(t, builder.AddDestination(destination, big.NewInt(int64(60000))))
+	assert.Nil(t, builder.SetChangeAddress(changeAddress))
+	assert.ErrorIs(t, builder.SetFeeRate(0), ErrInvalidFeeRate)
+	assert.ErrorIs(t, builder.SetFeeRate(-1), ErrInvalidFeeRate)
+	assert.Nil(t, builder.SetFeeRate(10))
+
+	transaction, err := builder.Build([]*ecc.PrivateKey{privateKey})
+	assert.Nil(t, err)
+	// the two largest utxos are used
+	assert.Equal(t, 2, len(transaction.txInputs))
+	assert.Equal(t, 2, len(transaction.txOutputs))
+	assert.True(t, transaction.Verify())
+
+	// the fee pays at least the fee rate of the real size
+	raw := transaction.Serialize()
+	vsize := (len(transaction.SerializeLegacy())*3 + len(raw) + 3) / 4
+	fee, err := transaction.Fee()
+	assert.Nil(t, err)
+	fmt.Printf("signed transaction: %x\nvsize: %d, fee: %v\n", raw, vsize, fee)
+	assert.True(t, fee.Cmp(big.NewInt(int64(10*vsize))) >= 0)
+	assert.Equal(t, big.NewInt(int64(80000-60000)), new(big.Int).Add(fee, transaction.txOutputs[1].amount))
+
+	parsed, err := ParseTransaction(raw)
+	assert.Nil(t, err)
+	assert.Equal(t, transaction.ID(), parsed.ID())
+
+	// no key for the utxo
+	_, err = builder.Build([]*ecc.PrivateKey{ecc.NewPrivateKey(big.NewInt(int64(1)))})
+	assert.ErrorIs(t, err, ErrNoSigningKey)
+
+	// not enough money
+	assert.Nil(t, builder.AddDestination(destination, big.NewInt(int64(30000))))
+	_, err = builder.Build([]*ecc.PrivateKey{privateKey})
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+
+	// change without change address
+	builder = NewTransactionBuilder(true)
+	builder.AddUTXO(NewUTXO("0000000000000000000000000000000000000000000000000000000000000001", 0,
+		big.NewInt(int64(50000)), P2pkScript(h160)))
+	assert.Nil(t, builder.AddDestination(destination, big.NewInt(int64(10000))))
+	_, err = builder.Build([]*ecc.PrivateKey{privateKey})
+	assert.ErrorIs(t, err, ErrNoChangeAddress)
+
+	// amount left smaller than dust goes to the fee
+	builder = NewTransactionBuilder(true)
+	builder.AddUTXO(NewUTXO("0000000000000000000000000000000000000000000000000000000000000001", 0,
+		big.NewInt(int64(50000)), P2pkScript(h160)))
+	assert.Nil(t, builder.AddDestination(destination, big.NewInt(int64(49500))))
+	transaction, err = builder.Build([]*ecc.PrivateKey{privateKey})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(transaction.txOutputs))
+	fee, err = transaction.Fee()
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(int64(500)), fee)
+
+	assert.ErrorIs(t, builder.AddDestination("not an address", big.NewInt(int64(1))), ErrInvalidAddress)
+}
+
+func TestEstimateVSize(t *testing.T) {
+	/*
+		estimate is for the largest signature, it is never below the size of
+		the signed transaction and not more than a few bytes above it
+	*/
+	privateKey := ecc.NewPrivateKey(big.NewInt(int64(8675309)))
+	pubKey := privateKey.GetPublicKey()
+	h160 := ecc.Hash160(secBytes(pubKey, true))
+	destination := ecc.NewPrivateKey(big.NewInt(int64(2024))).GetPublicKey().Address(true, true)
+
+	for _, scriptPubKeys := range [][]*ScriptSig{{P2pkScript(h160)}, {P2wpkhScript(h160)},
+		{P2pkScript(h160), P2wpkhScript(h160), P2pkScript(h160)}} {
+		utxos := make([]*UTXO, 0)
+		for i, scriptPubKey := range scriptPubKeys {
+			txID := fmt.Sprintf("%064x", i+1)
+			utxos = append(utxos, NewUTXO(txID, uint32(i), big.NewInt(int64(10000)), scriptPubKey))
+		}
+		builder := NewTransactionBuilder(true)
+		for _, utxo := range utxos {
+			builder.AddUTXO(utxo)
+		}
+		assert.Nil(t, builder.AddDestination(destination, big.NewInt(int64(len(utxos)*10000-5000))))
+		assert.Nil(t, builder.SetChangeAddress(pubKey.Address(true, true)))
+		transaction, err := builder.Build([]*ecc.PrivateKey{privateKey})
+		assert.Nil(t, err)
+		assert.Equal(t, len(utxos), len(transaction.txInputs))
+
+		vsize := int64((len(transaction.SerializeLegacy())*3 + len(transaction.Serialize()) + 3) / 4)
+		estimate := estimateVSize(utxos, transaction.txOutputs)
+		fmt.Printf("estimated vsize: %d, real vsize: %d\n", estimate, vsize)
+		assert.GreaterOrEqual(t, estimate, vsize)
+		assert.LessOrEqual(t, estimate-vsize, int64(2*len(utxos)))
+	}
+}
+
+func TestSegwitAddressToScriptPubKey(t *testing.T) {
+	pubKey := ecc.NewPrivateKey(big.NewInt(int64(8675309))).GetPublicKey()
+	h160 := ecc.Hash160(secBytes(pubKey, true))
+
+	for _, hrp := range []string{ecc.BECH32_TESTNET_HRP, ecc.BECH32_REGTEST_HRP} {
+		script, err := AddressToScriptPubKey(pubKey.P2wpkhAddress(hrp), true)
+		assert.Nil(t, err)
+		assert.Equal(t, P2wpkhScript(h160).Serialize(), script.Serialize())
+	}
+	script, err := AddressToScriptPubKey(strings.ToUpper(pubKey.P2wpkhAddress(ecc.BECH32_MAINNET_HRP)), false)
+	assert.Nil(t, err)
+	assert.True(t, script.IsP2wpkhScriptPubKey())
+	_, err = AddressToScriptPubKey(pubKey.P2wpkhAddress(ecc.BECH32_MAINNET_HRP), true)
+	assert.ErrorIs(t, err, ErrInvalidAddress)
+	_, err = AddressToScriptPubKey(pubKey.P2wpkhAddress(ecc.BECH32_REGTEST_HRP), false)
+	assert.ErrorIs(t, err, ErrInvalidAddress)
+
+	// p2wsh and p2tr from BIP173 and BIP350
+	script, err = AddressToScriptPubKey("tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", true)
+	assert.Nil(t, err)
+	assert.True(t, script.IsP2wshScriptPubKey())
+	assert.Equal(t, "2200201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262",
+		fmt.Sprintf("%x", script.Serialize()))
+	script, err = AddressToScriptPubKey("bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", false)
+	assert.Nil(t, err)
+	assert.True(t, script.IsP2trScriptPubKey())
+	assert.Equal(t, "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798",
+		fmt.Sprintf("%x", script.rawSerialize()))
+
+	// mistyped address
+	_, err = AddressToScriptPubKey("bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj2", false)
+	assert.ErrorIs(t, err, ErrInvalidAddress)
+	fmt.Printf("mistyped address: %v\n", err)
+}
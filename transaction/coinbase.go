@@ -0,0 +1,94 @@
+package transaction
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+)
+
+const (
+	// coinbase input spends nothing, the previous output is null
+	COINBASE_PREVIOUS_INDEX = 0xffffffff
+	// consensus limits of the coinbase scriptSig length
+	COINBASE_SCRIPT_MIN_LENGTH = 2
+	COINBASE_SCRIPT_MAX_LENGTH = 100
+	// BIP34 height is pushed as a script number, 8 bytes is more than enough
+	COINBASE_HEIGHT_MAX_LENGTH = 8
+)
+
+const (
+	// 50 bitcoins at the beginning, halved every 210000 blocks
+	INITIAL_SUBSIDY  = 50 * STASHI_PRE_BITCOIN
+	HALVING_INTERVAL = 210000
+	// shifting more than 63 bits is undefined, the subsidy is zero long before
+	MAX_HALVINGS = 64
+)
+
+func (t *TransactionInput) isNullOutpoint() bool {
+	return bytes.Equal(t.previousTransactionID, make([]byte, 32)) &&
+		t.previousTransactionIndex.Cmp(big.NewInt(COINBASE_PREVIOUS_INDEX)) == 0
+}
+
+func (t *Transaction) IsCoinbase() bool {
+	/*
+		coinbase is the first transaction of a block, it has exactly one
+		input, the previous transaction id is 32 zero bytes and the index is
+		0xffffffff
+	*/
+	return len(t.txInputs) == 1 && t.txInputs[0].isNullOutpoint()
+}
+
+func (t *Transaction) CoinbaseHeight() (int64, error) {
+	/*
+		BIP34, the first command of the coinbase scriptSig is the height of
+		the block. It is a script number, OP_0 and OP_1 to OP_16 for the
+		small heights, otherwise the data in little endian, like
+		03 d71b07 => 0x071bd7 = 465879
+		blocks before BIP34 activation(227931 on mainnet) may have anything
+		in the scriptSig
+	*/
+	if !t.IsCoinbase() {
+		return 0, ErrNotCoinbase
+	}
+	// scriptSig of coinbase is arbitrary data, we look at the raw bytes
+	raw := t.txInputs[0].scriptSig.rawSerialize()
+	if len(raw) == 0 {
+		return 0, fmt.Errorf("%w: empty scriptSig", ErrNoCoinbaseHeight)
+	}
+	op := int(raw[0])
+	switch {
+	case op == OP_0:
+		return 0, nil
+	case op >= OP_1 && op <= OP_16:
+		return int64(op - OP_1 + 1), nil
+	case op < 1 || op > COINBASE_HEIGHT_MAX_LENGTH:
+		return 0, fmt.Errorf("%w: first command %x is not a height", ErrNoCoinbaseHeight, raw[0])
+	case len(raw) < op+1:
+		return 0, fmt.Errorf("%w: push of %d bytes but only %d left", ErrNoCoinbaseHeight, op, len(raw)-1)
+	}
+	data := raw[1 : op+1]
+	if data[len(data)-1]&0x80 != 0 {
+		return 0, fmt.Errorf("%w: negative height %x", ErrNoCoinbaseHeight, data)
+	}
+	height := new(big.Int).SetBytes(reverseByteSlice(data))
+	return height.Int64(), nil
+}
+
+func (t *Transaction) verifyCoinbase() bool {
+	/*
+		coinbase has no previous output to check, its amount is limited by
+		the block: subsidy + fees of the block, here we only check the
+		length of the scriptSig
+	*/
+	length := len(t.txInputs[0].scriptSig.rawSerialize())
+	return length >= COINBASE_SCRIPT_MIN_LENGTH && length <= COINBASE_SCRIPT_MAX_LENGTH
+}
+
+func BlockSubsidy(height int64) *big.Int {
+	// new bitcoins in the block at the height, halved every 210000 blocks
+	halvings := height / HALVING_INTERVAL
+	if height < 0 || halvings >= MAX_HALVINGS {
+		return big.NewInt(0)
+	}
+	return new(big.Int).Rsh(big.NewInt(INITIAL_SUBSIDY), uint(halvings))
+}
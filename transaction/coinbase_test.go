@@ -0,0 +1,114 @@
+package transaction
+
+import (
+	ecc "elliptic_curve"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsCoinbase(t *testing.T) {
+	// coinbase of block 465879 from chapter 9 of the book
+	binary, _ := hex.DecodeString("01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff5e03d71b07254d696e656420627920416e74506f6f6c20626a31312f4542312f4144362f43205914293101fabe6d6d678e2c8c34afc36896e7d9402824ed38e856676ee94bfdb0c6c4bcd8b2e5666a0400000000000000c7270000a5e00e00ffffffff01faf20b58000000001976a914338c84849423992471bffb1a54a8d9b1d69dc28a88ac00000000")
+	coinbase, err := ParseTransaction(binary)
+	assert.Nil(t, err)
+	assert.True(t, coinbase.IsCoinbase())
+	height, err := coinbase.CoinbaseHeight()
+	assert.Nil(t, err)
+	fmt.Printf("coinbase height: %d\n", height)
+	assert.Equal(t, int64(465879), height)
+
+	// no fetching for the null previous transaction
+	fee, err := coinbase.Fee()
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(0), fee)
+	assert.True(t, coinbase.Verify())
+	assert.True(t, coinbase.VerifyInput(0))
+	assert.False(t, coinbase.SignInput(0, ecc.NewPrivateKey(big.NewInt(int64(8675309)))))
+
+	binary, _ = hex.DecodeString("0100000001813f79011acb80925dfe69b3def355fe914bd1d96a3f5f71bf8303c6a989c7d1000000006b483045022100ed81ff192e75a3fd2304004dcadb746fa5e24c5031ccfcf21320b0277457c98f02207a986d955c6e0cb35d446a89d3f56100f4d7f67801c31967743a9c8e10615bed01210349fc4e631e3624a545de3f89f5d8684c7b8138bd94bdd531d2e213bf016b278afeffffff02a135ef01000000001976a914bc3b654dca7e56b04dca18f2566cdaf02e8d9ada88ac99c39800000000001976a9141c4bc762dd5423e332166702cb75f40df79fea1288ac19430600")
+	legacy, _ := ParseTransaction(binary)
+	assert.False(t, legacy.IsCoinbase())
+	_, err = legacy.CoinbaseHeight()
+	assert.True(t, errors.Is(err, ErrNotCoinbase))
+}
+
+func coinbaseWithScriptSig(scriptSig string) *Transaction {
+	binary, _ := hex.DecodeString("01000000" + "01" + strings.Repeat("00", 32) + "ffffffff" +
+		fmt.Sprintf("%02x", len(scriptSig)/2) + scriptSig + "ffffffff" +
+		"01" + "00f2052a01000000" + "0151" + "00000000")
+	coinbase, _ := ParseTransaction(binary)
+	return coinbase
+}
+
+func TestCoinbaseHeight(t *testing.T) {
+	vectors := []struct {
+		scriptSig string
+		height    int64
+	}{
+		// small heights are OP_0 and OP_1 to OP_16
+		{"0000", 0},
+		{"5100", 1},
+		{"6000", 16},
+		{"0111", 17},
+		{"028000", 128},
+		{"03a0bb0d", 900000},
+	}
+	for _, vector := range vectors {
+		height, err := coinbaseWithScriptSig(vector.scriptSig).CoinbaseHeight()
+		assert.Nil(t, err)
+		assert.Equal(t, vector.height, height)
+	}
+
+	// not a height, push beyond the script and negative number
+	for _, scriptSig := range []string{"4c0100", "6a00", "04ffff", "0180"} {
+		_, err := coinbaseWithScriptSig(scriptSig).CoinbaseHeight()
+		assert.True(t, errors.Is(err, ErrNoCoinbaseHeight))
+	}
+
+	// scriptSig of 1 or more than 100 bytes is invalid
+	assert.False(t, coinbaseWithScriptSig("51").Verify())
+	assert.True(t, coinbaseWithScriptSig("51"+strings.Repeat("00", 99)).Verify())
+	assert.False(t, coinbaseWithScriptSig("51"+strings.Repeat("00", 100)).Verify())
+}
+
+func TestNullOutpoint(t *testing.T) {
+	// null outpoint is only allowed in coinbase, nothing is fetched for it
+	txInputs := []*TransactionInput{
+		InitTransactionInput(make([]byte, 32), big.NewInt(COINBASE_PREVIOUS_INDEX)),
+		InitTransactionInput(make([]byte, 32), big.NewInt(COINBASE_PREVIOUS_INDEX)),
+	}
+	for _, txInput := range txInputs {
+		txInput.SetScript(InitScriptSig([][]byte{{0x01}, {0x02}}))
+	}
+	transaction := InitTransaction(big.NewInt(1), txInputs, []*TransactionOutput{}, big.NewInt(0), false)
+	assert.False(t, transaction.IsCoinbase())
+	assert.False(t, transaction.Verify())
+	assert.False(t, transaction.VerifyInput(0))
+}
+
+func TestBlockSubsidy(t *testing.T) {
+	vectors := []struct {
+		height  int64
+		subsidy int64
+	}{
+		{0, 5000000000},
+		{209999, 5000000000},
+		{210000, 2500000000},
+		{630000, 625000000},
+		{840000, 312500000},
+		{HALVING_INTERVAL * 33, 0},
+		{HALVING_INTERVAL * 32, 1},
+		{HALVING_INTERVAL * 64, 0},
+		{HALVING_INTERVAL * 1000, 0},
+		{-1, 0},
+	}
+	for _, vector := range vectors {
+		assert.Equal(t, vector.subsidy, BlockSubsidy(vector.height).Int64(), "height %d", vector.height)
+	}
+}
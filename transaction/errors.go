@@ -0,0 +1,38 @@
+package transaction
+
+import (
+	ecc "elliptic_curve"
+	"errors"
+)
+
+/*
+errors returned when parsing transaction from untrusted binary data, they
+are wrapped with details, check them by errors.Is
+*/
+var (
+	ErrTruncatedInput       = ecc.ErrTruncatedInput
+	ErrBadVarint            = errors.New("bad varint")
+	ErrScriptLengthMismatch = errors.New("script length mismatch")
+	ErrBadSegwitFlag        = errors.New("bad segwit flag")
+)
+
+// errors returned when building transaction
+var (
+	ErrInvalidAddress    = errors.New("invalid address")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrNoChangeAddress   = errors.New("no change address")
+	ErrNoSigningKey      = errors.New("no private key for utxo")
+	ErrUnsupportedScript = errors.New("unsupported scriptPubKey")
+	ErrSignatureInvalid  = errors.New("signature verification failed")
+	ErrPreviousOutput    = errors.New("previous output not found")
+	ErrInputIndex        = errors.New("input index out of range")
+	ErrInvalidFeeRate    = errors.New("invalid fee rate")
+	// SIGHASH_SINGLE without output of the same index has no message to serialize
+	ErrSighashSingleNoOutput = errors.New("no output for SIGHASH_SINGLE")
+)
+
+// errors returned when reading coinbase transaction
+var (
+	ErrNotCoinbase      = errors.New("not a coinbase transaction")
+	ErrNoCoinbaseHeight = errors.New("no height in coinbase")
+)
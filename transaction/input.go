@@ -11,7 +11,11 @@ type TransactionInput struct {
 	previousTransactionIndex *big.Int
 	scriptSig                *ScriptSig
 	sequence                 *big.Int
-	fetcher                  *TransactionFetcher
+	fetcher                  TransactionFetcher
+	// stack items for segwit input, empty for legacy input
+	witness [][]byte
+	// output spent by this input, fetched by previous transaction if it is nil
+	prevOutput *TransactionOutput
 }
 
 func InitTransactionInput(previousTx []byte, previousIndex *big.Int) *TransactionInput {
@@ -20,6 +24,7 @@ func InitTransactionInput(previousTx []byte, previousIndex *big.Int) *Transactio
 		previousTransactionIndex: previousIndex,
 		scriptSig:                nil,
 		sequence:                 big.NewInt(int64(0xffffffff)),
+		fetcher:                  NewTransactionInputFetch(),
 	}
 }
 
@@ -34,69 +39,161 @@ func (t *TransactionInput) SetScript(sig *ScriptSig) {
 	t.scriptSig = sig
 }
 
-func NewTransactionInput(reader *bufio.Reader) *TransactionInput {
+func (t *TransactionInput) SetFetcher(fetcher TransactionFetcher) {
+	// fetcher for the previous transaction, its output is spent by this input
+	t.fetcher = fetcher
+}
+
+func (t *TransactionInput) Witness() [][]byte {
+	return t.witness
+}
+
+func (t *TransactionInput) SetWitness(witness [][]byte) {
+	t.witness = witness
+}
+
+func ReadWitness(reader *bufio.Reader) ([][]byte, error) {
+	itemCount, err := ReadVarint(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read witness item count: %w", err)
+	}
+	witness := make([][]byte, 0)
+	for i := 0; i < int(itemCount.Int64()); i++ {
+		itemLen, err := ReadVarint(reader)
+		if err != nil {
+			return nil, fmt.Errorf("read witness item length: %w", err)
+		}
+		item, err := ReadBytes(reader, int(itemLen.Int64()))
+		if err != nil {
+			return nil, fmt.Errorf("read witness item: %w", err)
+		}
+		witness = append(witness, item)
+	}
+	return witness, nil
+}
+
+func (t *TransactionInput) SerializeWitness() []byte {
+	result := make([]byte, 0)
+	result = append(result, EncodeVarint(big.NewInt(int64(len(t.witness))))...)
+	for _, item := range t.witness {
+		result = append(result, EncodeVarint(big.NewInt(int64(len(item))))...)
+		result = append(result, item...)
+	}
+	return result
+}
+
+func NewTransactionInput(reader *bufio.Reader) (*TransactionInput, error) {
 	// first 32 bytes are hash256 of previous transaction
 	transactionInput := &TransactionInput{}
 	transactionInput.fetcher = NewTransactionInputFetch()
 
-	previousTransaction := make([]byte, 32)
-	reader.Read(previousTransaction)
+	previousTransaction, err := ReadBytes(reader, 32)
+	if err != nil {
+		return nil, fmt.Errorf("read previous transaction id: %w", err)
+	}
 	// convert it from little endian to big endian
 	// reverse the byte array [0x01, 0x02, 0x03, 0x04] -> [0x04, 0x03, 0x02, 0x01]
 	transactionInput.previousTransactionID = reverseByteSlice(previousTransaction)
 
 	// 4 bytes for previous transaction index
-	idx := make([]byte, 4)
-	reader.Read(idx)
+	idx, err := ReadBytes(reader, 4)
+	if err != nil {
+		return nil, fmt.Errorf("read previous transaction index: %w", err)
+	}
 	transactionInput.previousTransactionIndex = LittleEndianToBigInt(idx, LITTLE_ENDIAN_4_BYTES)
 
-	transactionInput.scriptSig = NewScriptSig(reader)
+	transactionInput.scriptSig, err = readTransactionScript(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read scriptSig: %w", err)
+	}
 
 	// last 4 bytes for sequence
-	seqBytes := make([]byte, 4)
-	reader.Read(seqBytes)
+	seqBytes, err := ReadBytes(reader, 4)
+	if err != nil {
+		return nil, fmt.Errorf("read sequence: %w", err)
+	}
 	transactionInput.sequence = LittleEndianToBigInt(seqBytes, LITTLE_ENDIAN_4_BYTES)
 
-	return transactionInput
+	return transactionInput, nil
 }
 
-func (t *TransactionInput) getPreviousTx(testnet bool) *Transaction {
+func (t *TransactionInput) getPreviousTx(testnet bool) (*Transaction, error) {
 	previousTxID := fmt.Sprintf("%x", t.previousTransactionID)
-	previousTx := t.fetcher.Fetch(previousTxID, testnet)
-	tx := ParseTransaction(previousTx)
-	return tx
+	return FetchTransaction(t.fetcher, previousTxID, testnet)
 }
 
-func (t *TransactionInput) Value(testnet bool) *big.Int {
-	tx := t.getPreviousTx(testnet)
-	return tx.txOutputs[t.previousTransactionIndex.Int64()].amount
+func (t *TransactionInput) SetPreviousOutput(output *TransactionOutput) {
+	/*
+		when we already know the output spent by this input, like the utxo
+		in our wallet, we don't need to fetch the previous transaction
+	*/
+	t.prevOutput = output
 }
 
-func (t *TransactionInput) Script(testnet bool) *ScriptSig {
-	previousTxID := fmt.Sprintf("%x", t.previousTransactionID)
-	previousTx := t.fetcher.Fetch(previousTxID, testnet)
-	tx := ParseTransaction(previousTx)
+func (t *TransactionInput) previousOutput(testnet bool) (*TransactionOutput, error) {
+	/*
+		the fetched output is kept, signature messages of all inputs need it
+		and we don't fetch the same transaction again and again
+	*/
+	if t.prevOutput != nil {
+		return t.prevOutput, nil
+	}
+	tx, err := t.getPreviousTx(testnet)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPreviousOutput, err)
+	}
+	idx := t.previousTransactionIndex
+	if !idx.IsInt64() || idx.Int64() >= int64(len(tx.txOutputs)) {
+		return nil, fmt.Errorf("%w: transaction %x has %d outputs but index is %v",
+			ErrPreviousOutput, t.previousTransactionID, len(tx.txOutputs), idx)
+	}
+	t.prevOutput = tx.txOutputs[idx.Int64()]
+	return t.prevOutput, nil
+}
 
-	scriptPubKey := tx.txOutputs[t.previousTransactionIndex.Int64()].scriptPubKey
-	return t.scriptSig.Add(scriptPubKey)
+func (t *TransactionInput) Value(testnet bool) (*big.Int, error) {
+	prevOutput, err := t.previousOutput(testnet)
+	if err != nil {
+		return nil, err
+	}
+	return prevOutput.amount, nil
+}
+
+func (t *TransactionInput) Script(testnet bool) (*ScriptSig, error) {
+	scriptPubKey, err := t.scriptPubKey(testnet)
+	if err != nil {
+		return nil, err
+	}
+	return t.scriptSig.Add(scriptPubKey), nil
 }
 
-func (t *TransactionInput) scriptPubKey(testnet bool) *ScriptSig {
-	tx := t.getPreviousTx(testnet)
-	return tx.txOutputs[t.previousTransactionIndex.Int64()].scriptPubKey
+func (t *TransactionInput) scriptPubKey(testnet bool) (*ScriptSig, error) {
+	prevOutput, err := t.previousOutput(testnet)
+	if err != nil {
+		return nil, err
+	}
+	return prevOutput.scriptPubKey, nil
 }
 
-func (t *TransactionInput) ReplaceWithScriptPubKey(testnet bool) {
-	t.scriptSig = t.scriptPubKey(testnet)
-	fmt.Printf("scriptpubkey: %v\n", t.scriptSig)
+func (t *TransactionInput) ReplaceWithScriptPubKey(testnet bool) error {
+	scriptPubKey, err := t.scriptPubKey(testnet)
+	if err != nil {
+		return err
+	}
+	t.scriptSig = scriptPubKey
+	return nil
 }
 
 func (t *TransactionInput) Serialize() []byte {
+	return t.serializeWithScript(t.scriptSig, t.sequence)
+}
+
+func (t *TransactionInput) serializeWithScript(script *ScriptSig, sequence *big.Int) []byte {
 	result := make([]byte, 0)
 	result = append(result, reverseByteSlice(t.previousTransactionID)...)
 	result = append(result, BigIntToLittleEndian(t.previousTransactionIndex, LITTLE_ENDIAN_4_BYTES)...)
-	result = append(result, t.scriptSig.Serialize()...)
-	result = append(result, BigIntToLittleEndian(t.sequence, LITTLE_ENDIAN_4_BYTES)...)
+	result = append(result, script.Serialize()...)
+	result = append(result, BigIntToLittleEndian(sequence, LITTLE_ENDIAN_4_BYTES)...)
 	return result
 }
 
@@ -2,9 +2,12 @@ package transaction
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
 	ecc "elliptic_curve"
-	"fmt"
 	"math/big"
+
+	"golang.org/x/crypto/ripemd160"
 )
 
 const (
@@ -12,6 +15,7 @@ const (
 )
 const (
 	OP_1NEGATE = iota + 79
+	OP_RESERVED
 )
 const (
 	OP_1 = iota + 81
@@ -31,17 +35,22 @@ const (
 	OP_15
 	OP_16
 	OP_NOP
+	OP_VER
 )
 
 const (
 	OP_IF = iota + 99
-	OP_NOTIf
+	OP_NOTIF
+	OP_VERIF
+	OP_VERNOTIF
+	OP_ELSE
+	OP_ENDIF
 )
 
 const (
 	OP_VERIFY = iota + 105
 	OP_RETURN
-	OP_TOTALSTACK
+	OP_TOALTSTACK
 	OP_FROMALTSTACK
 	OP_2DROP
 	OP_2DUP
@@ -63,17 +72,29 @@ const (
 )
 
 const (
-	OP_SIZE = iota + 130
+	OP_CAT = iota + 126
+	OP_SUBSTR
+	OP_LEFT
+	OP_RIGHT
+	OP_SIZE
+	OP_INVERT
+	OP_AND
+	OP_OR
+	OP_XOR
 )
 
 const (
 	OP_EQUAL = iota + 135
 	OP_EQUALVERIFY
+	OP_RESERVED1
+	OP_RESERVED2
 )
 
 const (
 	OP_1ADD = iota + 139
 	OP_1SUB
+	OP_2MUL
+	OP_2DIV
 )
 
 const (
@@ -84,6 +105,10 @@ const (
 	OP_ADD
 	OP_SUB
 	OP_MUL
+	OP_DIV
+	OP_MOD
+	OP_LSHIFT
+	OP_RSHIFT
 )
 
 const (
@@ -104,15 +129,16 @@ const (
 	OP_SHA256
 	OP_HASH160
 	OP_HASH256
+	OP_CODESEPARATOR
 )
 
 const (
 	OP_CHECKSIG = iota + 172
-	OP_HECKSIGVERIFY
+	OP_CHECKSIGVERIFY
 	OP_CHECKMULTISIG
 	OP_CHECKMULTISIGVERIFY
 	OP_NOP1
-	OP_CHECKLOGTIMEVERIFY
+	OP_CHECKLOCKTIMEVERIFY
 	OP_CHECKSEQUENCEVERIFY
 	OP_NOP4
 	OP_NOP5
@@ -121,6 +147,43 @@ const (
 	OP_NOP8
 	OP_NOP9
 	OP_NOP10
+	// OP_CHECKSIGADD of tapscript(BIP342), disabled in legacy and segwit v0 scripts
+	OP_CHECKSIGADD
+)
+
+const (
+	// consensus limits for script evaluation
+	MAX_SCRIPT_ELEMENT_SIZE  = 520
+	MAX_STACK_SIZE           = 1000
+	MAX_OPS_PER_SCRIPT       = 201
+	MAX_PUBKEYS_PER_MULTISIG = 20
+	// numbers for arithmetic op codes can't be longer than 4 bytes
+	MAX_SCRIPT_NUM_LENGTH = 4
+	// locktime is above the 32 bits signed integer range, it uses 5 bytes
+	LOCKTIME_NUM_LENGTH = 5
+	// locktime below this value is block height, otherwise it is unix timestamp
+	LOCKTIME_THRESHOLD = 500000000
+
+	SEQUENCE_FINAL                 = 0xffffffff
+	SEQUENCE_LOCKTIME_DISABLE_FLAG = 1 << 31
+	SEQUENCE_LOCKTIME_TYPE_FLAG    = 1 << 22
+	SEQUENCE_LOCKTIME_MASK         = 0x0000ffff
+
+	/*
+		tapscript has no limit of signature op codes, each executed signature
+		check takes 50 from the budget, which is 50 plus the witness size
+	*/
+	VALIDATION_WEIGHT_PER_SIGOP_PASSED = 50
+	VALIDATION_WEIGHT_OFFSET           = 50
+)
+
+type SCRIPT_FLAG uint32
+
+const (
+	// argument of OP_IF and OP_NOTIF must be empty or exactly 0x01
+	SCRIPT_VERIFY_MINIMALIF SCRIPT_FLAG = 1 << iota
+	// dummy element consumed by OP_CHECKMULTISIG must be empty, BIP147
+	SCRIPT_VERIFY_NULLDUMMY
 )
 
 type BitcoinOpCode struct {
@@ -128,6 +191,37 @@ type BitcoinOpCode struct {
 	stack       [][]byte
 	altStack    [][]byte
 	cmds        [][]byte
+	/*
+		dataCmds[i] tells whether cmds[i] is a chunk of data, we can't rely on
+		the length of the command only, pushing one byte of data like 0x01 0xac
+		would be taken as OP_CHECKSIG
+	*/
+	dataCmds []bool
+	// fields of the spending transaction used by the locktime op codes
+	txVersion *big.Int
+	lockTime  *big.Int
+	sequence  *big.Int
+	/*
+		every OP_IF or OP_NOTIF pushes whether its branch is executed, OP_ELSE
+		flips the top value and OP_ENDIF pops it, commands are only executed
+		when all the values are true
+	*/
+	execStack []bool
+	flags     SCRIPT_FLAG
+	// count of non push op codes executed, OP_CHECKMULTISIG also adds its key count
+	opCount int
+	// compute signature message for the given hash type
+	sigHash func(hashType byte) ([]byte, bool)
+	/*
+		tapscript(BIP342) checks schnorr signatures and has different rules,
+		the signature message commits to the position of the last executed
+		OP_CODESEPARATOR, the position counts every command from 0
+	*/
+	tapscript        bool
+	cmdPosition      uint32
+	codeSepPosition  uint32
+	validationWeight int64
+	tapSigHash       func(hashType byte, codeSepPosition uint32) ([]byte, bool)
 }
 
 func NewBitcoinOpCode() *BitcoinOpCode {
@@ -137,6 +231,7 @@ func NewBitcoinOpCode() *BitcoinOpCode {
 		77:  "OP_PUSHDATA2",
 		78:  "OP_PUSHDATA4",
 		79:  "OP_1NEGATE",
+		80:  "OP_RESERVED",
 		81:  "OP_1",
 		82:  "OP_2",
 		83:  "OP_3",
@@ -154,8 +249,11 @@ func NewBitcoinOpCode() *BitcoinOpCode {
 		95:  "OP_15",
 		96:  "OP_16",
 		97:  "OP_NOP",
+		98:  "OP_VER",
 		99:  "OP_IF",
 		100: "OP_NOTIF",
+		101: "OP_VERIF",
+		102: "OP_VERNOTIF",
 		103: "OP_ELSE",
 		104: "OP_ENDIF",
 		105: "OP_VERIFY",
@@ -179,11 +277,23 @@ func NewBitcoinOpCode() *BitcoinOpCode {
 		123: "OP_ROT",
 		124: "OP_SWAP",
 		125: "OP_TUCK",
+		126: "OP_CAT",
+		127: "OP_SUBSTR",
+		128: "OP_LEFT",
+		129: "OP_RIGHT",
 		130: "OP_SIZE",
+		131: "OP_INVERT",
+		132: "OP_AND",
+		133: "OP_OR",
+		134: "OP_XOR",
 		135: "OP_EQUAL",
 		136: "OP_EQUALVERIFY",
+		137: "OP_RESERVED1",
+		138: "OP_RESERVED2",
 		139: "OP_1ADD",
 		140: "OP_1SUB",
+		141: "OP_2MUL",
+		142: "OP_2DIV",
 		143: "OP_NEGATE",
 		144: "OP_ABS",
 		145: "OP_NOT",
@@ -191,6 +301,10 @@ func NewBitcoinOpCode() *BitcoinOpCode {
 		147: "OP_ADD",
 		148: "OP_SUB",
 		149: "OP_MUL",
+		150: "OP_DIV",
+		151: "OP_MOD",
+		152: "OP_LSHIFT",
+		153: "OP_RSHIFT",
 		154: "OP_BOOLAND",
 		155: "OP_BOOLOR",
 		156: "OP_NUMEQUAL",
@@ -223,13 +337,68 @@ func NewBitcoinOpCode() *BitcoinOpCode {
 		183: "OP_NOP8",
 		184: "OP_NOP9",
 		185: "OP_NOP10",
+		186: "OP_CHECKSIGADD",
 	}
 	return &BitcoinOpCode{
 		opCodeNames: opCodeNames,
 		stack:       make([][]byte, 0),
 		altStack:    make([][]byte, 0),
 		cmds:        make([][]byte, 0),
+		dataCmds:    make([]bool, 0),
+		execStack:   make([]bool, 0),
+		// no OP_CODESEPARATOR executed
+		codeSepPosition: 0xffffffff,
+	}
+}
+
+func (b *BitcoinOpCode) popStack() []byte {
+	elem := b.stack[len(b.stack)-1]
+	b.stack = b.stack[0 : len(b.stack)-1]
+	return elem
+}
+
+func (b *BitcoinOpCode) pushBool(val bool) {
+	if val {
+		b.stack = append(b.stack, b.EncodeNum(1))
+	} else {
+		b.stack = append(b.stack, b.EncodeNum(0))
+	}
+}
+
+func (b *BitcoinOpCode) popNum(maxLen int) (int64, bool) {
+	/*
+		operands of the arithmetic op codes are limited to 4 bytes, the
+		result of an operation can overflow to 5 bytes, but it can't be
+		used as operand again
+	*/
+	elem := b.popStack()
+	if len(elem) > maxLen {
+		return 0, false
+	}
+
+	return b.DecodeNum(elem), true
+}
+
+func castToBool(elem []byte) bool {
+	/*
+		any non zero byte makes the element true, except 0x80 at the last byte
+		which is the sign bit, it means negative zero
+	*/
+	for i := 0; i < len(elem); i++ {
+		if elem[i] != 0 {
+			if i == len(elem)-1 && elem[i] == 0x80 {
+				return false
+			}
+			return true
+		}
 	}
+
+	return false
+}
+
+func (b *BitcoinOpCode) opPushNum(num int64) bool {
+	b.stack = append(b.stack, b.EncodeNum(num))
+	return true
 }
 
 func (b *BitcoinOpCode) opDup() bool {
@@ -241,18 +410,352 @@ func (b *BitcoinOpCode) opDup() bool {
 	return true
 }
 
-func (b *BitcoinOpCode) opHash160() bool {
+func (b *BitcoinOpCode) IsExecuting() bool {
+	for _, exec := range b.execStack {
+		if !exec {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (b *BitcoinOpCode) HasUnbalancedConditional() bool {
+	return len(b.execStack) != 0
+}
+
+func (b *BitcoinOpCode) opIf(notIf bool) bool {
+	/*
+		OP_IF, OP_NOTIF pop the top element to decide whether to execute the
+		following branch, if we are inside a branch not executed, the element
+		is not popped and the new branch is not executed either
+	*/
+	if !b.IsExecuting() {
+		b.execStack = append(b.execStack, false)
+		return true
+	}
+
 	if len(b.stack) < 1 {
 		return false
 	}
+	elem := b.popStack()
+	if b.flags&SCRIPT_VERIFY_MINIMALIF != 0 {
+		if len(elem) > 1 || (len(elem) == 1 && elem[0] != 1) {
+			return false
+		}
+	}
 
-	element := b.stack[len(b.stack)-1]
-	b.stack = b.stack[0 : len(b.stack)-1]
-	hash160 := ecc.Hash160(element)
-	b.stack = append(b.stack, hash160)
+	condition := castToBool(elem)
+	if notIf {
+		condition = !condition
+	}
+	b.execStack = append(b.execStack, condition)
+	return true
+}
+
+func (b *BitcoinOpCode) opElse() bool {
+	if len(b.execStack) < 1 {
+		return false
+	}
+
+	b.execStack[len(b.execStack)-1] = !b.execStack[len(b.execStack)-1]
+	return true
+}
+
+func (b *BitcoinOpCode) opEndIf() bool {
+	if len(b.execStack) < 1 {
+		return false
+	}
+
+	b.execStack = b.execStack[0 : len(b.execStack)-1]
+	return true
+}
+
+func (b *BitcoinOpCode) opToAltStack() bool {
+	if len(b.stack) < 1 {
+		return false
+	}
+
+	b.altStack = append(b.altStack, b.popStack())
+	return true
+}
+
+func (b *BitcoinOpCode) opFromAltStack() bool {
+	if len(b.altStack) < 1 {
+		return false
+	}
+
+	elem := b.altStack[len(b.altStack)-1]
+	b.altStack = b.altStack[0 : len(b.altStack)-1]
+	b.stack = append(b.stack, elem)
+	return true
+}
+
+func (b *BitcoinOpCode) opDrop(count int) bool {
+	// OP_DROP, OP_2DROP
+	if len(b.stack) < count {
+		return false
+	}
+
+	b.stack = b.stack[0 : len(b.stack)-count]
+	return true
+}
+
+func (b *BitcoinOpCode) opCopy(count int, depth int) bool {
+	/*
+		copy count elements which are depth elements away from the top on to the
+		top of the stack
+		OP_2DUP: [x1, x2] -> [x1, x2, x1, x2], count 2, depth 0
+		OP_3DUP: [x1, x2, x3] -> [x1, x2, x3, x1, x2, x3], count 3, depth 0
+		OP_OVER: [x1, x2] -> [x1, x2, x1], count 1, depth 1
+		OP_2OVER: [x1, x2, x3, x4] -> [x1, x2, x3, x4, x1, x2], count 2, depth 2
+	*/
+	if len(b.stack) < count+depth {
+		return false
+	}
+
+	begin := len(b.stack) - count - depth
+	for i := 0; i < count; i++ {
+		b.stack = append(b.stack, b.stack[begin+i])
+	}
+	return true
+}
+
+func (b *BitcoinOpCode) opMoveToTop(count int, depth int) bool {
+	/*
+		move count elements which are depth elements away from the top on to the
+		top of the stack
+		OP_SWAP: [x1, x2] -> [x2, x1], count 1, depth 1
+		OP_ROT: [x1, x2, x3] -> [x2, x3, x1], count 1, depth 2
+		OP_2SWAP: [x1, x2, x3, x4] -> [x3, x4, x1, x2], count 2, depth 2
+		OP_2ROT: [x1, x2, x3, x4, x5, x6] -> [x3, x4, x5, x6, x1, x2], count 2, depth 4
+	*/
+	if len(b.stack) < count+depth {
+		return false
+	}
+
+	begin := len(b.stack) - count - depth
+	moved := make([][]byte, count)
+	copy(moved, b.stack[begin:begin+count])
+	b.stack = append(b.stack[0:begin], b.stack[begin+count:]...)
+	b.stack = append(b.stack, moved...)
+	return true
+}
+
+func (b *BitcoinOpCode) opIfDup() bool {
+	if len(b.stack) < 1 {
+		return false
+	}
+
+	if castToBool(b.stack[len(b.stack)-1]) {
+		b.stack = append(b.stack, b.stack[len(b.stack)-1])
+	}
+	return true
+}
+
+func (b *BitcoinOpCode) opDepth() bool {
+	b.stack = append(b.stack, b.EncodeNum(int64(len(b.stack))))
+	return true
+}
+
+func (b *BitcoinOpCode) opNip() bool {
+	// [x1, x2] -> [x2]
+	if len(b.stack) < 2 {
+		return false
+	}
+
+	top := b.popStack()
+	b.stack[len(b.stack)-1] = top
+	return true
+}
+
+func (b *BitcoinOpCode) opPickOrRoll(roll bool) bool {
+	/*
+		top of the stack is n, copy(OP_PICK) or move(OP_ROLL) the item
+		n elements back in the stack to the top
+	*/
+	if len(b.stack) < 2 {
+		return false
+	}
+
+	n, ok := b.popNum(MAX_SCRIPT_NUM_LENGTH)
+	if !ok || n < 0 || n >= int64(len(b.stack)) {
+		return false
+	}
+
+	if roll {
+		return b.opMoveToTop(1, int(n))
+	}
+	return b.opCopy(1, int(n))
+}
+
+func (b *BitcoinOpCode) opTuck() bool {
+	// [x1, x2] -> [x2, x1, x2]
+	if len(b.stack) < 2 {
+		return false
+	}
+
+	top := b.stack[len(b.stack)-1]
+	b.stack = append(b.stack, top)
+	copy(b.stack[len(b.stack)-2:], b.stack[len(b.stack)-3:len(b.stack)-1])
+	b.stack[len(b.stack)-3] = top
+	return true
+}
+
+func (b *BitcoinOpCode) opSize() bool {
+	if len(b.stack) < 1 {
+		return false
+	}
+
+	b.stack = append(b.stack, b.EncodeNum(int64(len(b.stack[len(b.stack)-1]))))
+	return true
+}
+
+func (b *BitcoinOpCode) opUnaryArithmetic(cmd int) bool {
+	if len(b.stack) < 1 {
+		return false
+	}
+
+	num, ok := b.popNum(MAX_SCRIPT_NUM_LENGTH)
+	if !ok {
+		return false
+	}
+
+	switch cmd {
+	case OP_1ADD:
+		num += 1
+	case OP_1SUB:
+		num -= 1
+	case OP_NEGATE:
+		num = -num
+	case OP_ABS:
+		if num < 0 {
+			num = -num
+		}
+	case OP_NOT:
+		b.pushBool(num == 0)
+		return true
+	case OP_0NOTEQUAL:
+		b.pushBool(num != 0)
+		return true
+	}
+
+	b.stack = append(b.stack, b.EncodeNum(num))
+	return true
+}
+
+func (b *BitcoinOpCode) opBinaryArithmetic(cmd int) bool {
+	/*
+		second element of the stack is a, top element is b,
+		OP_SUB: a - b, OP_LESSTHAN: a < b
+	*/
+	if len(b.stack) < 2 {
+		return false
+	}
+
+	num2, ok := b.popNum(MAX_SCRIPT_NUM_LENGTH)
+	if !ok {
+		return false
+	}
+	num1, ok := b.popNum(MAX_SCRIPT_NUM_LENGTH)
+	if !ok {
+		return false
+	}
+
+	switch cmd {
+	case OP_ADD:
+		b.stack = append(b.stack, b.EncodeNum(num1+num2))
+	case OP_SUB:
+		b.stack = append(b.stack, b.EncodeNum(num1-num2))
+	case OP_BOOLAND:
+		b.pushBool(num1 != 0 && num2 != 0)
+	case OP_BOOLOR:
+		b.pushBool(num1 != 0 || num2 != 0)
+	case OP_NUMEQUAL, OP_NUMEQUALVERIFY:
+		b.pushBool(num1 == num2)
+	case OP_NUMNOTEQUAL:
+		b.pushBool(num1 != num2)
+	case OP_LESSTHAN:
+		b.pushBool(num1 < num2)
+	case OP_GREATERTHAN:
+		b.pushBool(num1 > num2)
+	case OP_LESSTHANOREQUAL:
+		b.pushBool(num1 <= num2)
+	case OP_GREATERTHANOREQUAL:
+		b.pushBool(num1 >= num2)
+	case OP_MIN:
+		if num2 < num1 {
+			num1 = num2
+		}
+		b.stack = append(b.stack, b.EncodeNum(num1))
+	case OP_MAX:
+		if num2 > num1 {
+			num1 = num2
+		}
+		b.stack = append(b.stack, b.EncodeNum(num1))
+	}
+
+	if cmd == OP_NUMEQUALVERIFY {
+		return b.opVerify()
+	}
+	return true
+}
+
+func (b *BitcoinOpCode) opWithin() bool {
+	// [x, min, max] -> push 1 if min <= x < max
+	if len(b.stack) < 3 {
+		return false
+	}
+
+	maxNum, ok := b.popNum(MAX_SCRIPT_NUM_LENGTH)
+	if !ok {
+		return false
+	}
+	minNum, ok := b.popNum(MAX_SCRIPT_NUM_LENGTH)
+	if !ok {
+		return false
+	}
+	x, ok := b.popNum(MAX_SCRIPT_NUM_LENGTH)
+	if !ok {
+		return false
+	}
+
+	b.pushBool(minNum <= x && x < maxNum)
+	return true
+}
+
+func (b *BitcoinOpCode) opHash(cmd int) bool {
+	if len(b.stack) < 1 {
+		return false
+	}
+
+	element := b.popStack()
+	var digest []byte
+	switch cmd {
+	case OP_RIPEMD160:
+		hasher := ripemd160.New()
+		hasher.Write(element)
+		digest = hasher.Sum(nil)
+	case OP_SHA1:
+		h := sha1.Sum(element)
+		digest = h[:]
+	case OP_SHA256:
+		h := sha256.Sum256(element)
+		digest = h[:]
+	case OP_HASH160:
+		digest = ecc.Hash160(element)
+	case OP_HASH256:
+		digest = ecc.Hash256(string(element))
+	}
+
+	b.stack = append(b.stack, digest)
 	return true
 }
 
+func (b *BitcoinOpCode) opHash160() bool {
+	return b.opHash(OP_HASH160)
+}
+
 func (b *BitcoinOpCode) opEqual() bool {
 	if len(b.stack) < 2 {
 		return false
@@ -279,7 +782,7 @@ func (b *BitcoinOpCode) opVerify() bool {
 	elem := b.stack[len(b.stack)-1]
 	b.stack = b.stack[0 : len(b.stack)-1]
 
-	return b.DecodeNum(elem) != 0
+	return castToBool(elem)
 }
 
 func (b *BitcoinOpCode) opEqualVerify() bool {
@@ -295,7 +798,7 @@ func (b *BitcoinOpCode) opCheckSig(zBin []byte) bool {
 		are top two elements of the stack
 
 		notcie!! we need to remove the last byte of the der binary data, becasue
-		this byte is used for hash type
+		this byte is used for hash type, it decides the message to verify
 
 		if the signature verification success , push 1 on the stack, otherwise
 		push 0 on the stack
@@ -309,16 +812,39 @@ func (b *BitcoinOpCode) opCheckSig(zBin []byte) bool {
 	pubKey := b.stack[len(b.stack)-1]
 	b.stack = b.stack[0 : len(b.stack)-1]
 	derSig := b.stack[len(b.stack)-1]
-	derSig = derSig[0 : len(derSig)-1]
 	b.stack = b.stack[0 : len(b.stack)-1]
+	if b.tapscript {
+		success, ok := b.checkSchnorrSig(derSig, pubKey)
+		if !ok {
+			return false
+		}
+		b.pushBool(success)
+		return true
+	}
+	if len(derSig) == 0 {
+		// empty signature is allowed, it just fails the check
+		b.stack = append(b.stack, b.EncodeNum(0))
+		return true
+	}
+	hashType := derSig[len(derSig)-1]
+	derSig = derSig[0 : len(derSig)-1]
 
-	point := ecc.ParseSEC(pubKey)
-	sig := ecc.ParseSigBin(derSig)
+	// signature not in strict der encoding fails the script(BIP66)
+	sig, err := ecc.ParseSigBin(derSig)
+	if err != nil {
+		return false
+	}
+	// invalid public key only fails the check
+	point, err := ecc.ParseSEC(pubKey)
+	if err != nil {
+		b.stack = append(b.stack, b.EncodeNum(0))
+		return true
+	}
 
-	z := new(big.Int)
-	z.SetBytes(zBin)
-	n := ecc.GetBitcoinValueN()
-	zField := ecc.NewFieldElement(n, z)
+	zField, ok := b.signatureHash(zBin, hashType)
+	if !ok {
+		return false
+	}
 	if point.Verify(zField, sig) {
 		b.stack = append(b.stack, b.EncodeNum(1))
 	} else {
@@ -328,10 +854,259 @@ func (b *BitcoinOpCode) opCheckSig(zBin []byte) bool {
 	return true
 }
 
-func (b *BitcoinOpCode) RemoveCmd() []byte {
+func (b *BitcoinOpCode) checkSchnorrSig(sig []byte, pubKey []byte) (bool, bool) {
+	/*
+		signature check in tapscript, returns the result and whether the
+		script can go on:
+		1. empty public key fails the script
+		2. empty signature is a failed check
+		3. 32 bytes public key is checked by BIP340, a signature not empty
+		   but invalid fails the script
+		4. public key of other length is unknown type for upgrade, the check
+		   succeeds for any signature
+		the signature is 64 bytes with SIGHASH_DEFAULT or 65 bytes with the
+		hash type at the end
+	*/
+	if len(pubKey) == 0 {
+		return false, false
+	}
+	if len(sig) == 0 {
+		return false, true
+	}
+	b.validationWeight -= VALIDATION_WEIGHT_PER_SIGOP_PASSED
+	if b.validationWeight < 0 {
+		return false, false
+	}
+	if len(pubKey) != 32 {
+		return true, true
+	}
+
+	hashType := byte(SIGHASH_DEFAULT)
+	if len(sig) == 65 {
+		hashType = sig[64]
+		if hashType == SIGHASH_DEFAULT {
+			return false, false
+		}
+		sig = sig[0:64]
+	} else if len(sig) != 64 {
+		return false, false
+	}
+	if b.tapSigHash == nil {
+		return false, false
+	}
+	msg, ok := b.tapSigHash(hashType, b.codeSepPosition)
+	if !ok || !ecc.VerifySchnorr(pubKey, msg, sig) {
+		return false, false
+	}
+	return true, true
+}
+
+func (b *BitcoinOpCode) opCheckSigAdd() bool {
+	/*
+		stack is [sig, n, pubkey], push n + 1 if the signature is valid, n if
+		the signature is empty, it replaces OP_CHECKMULTISIG in tapscript:
+		<pubkey1> OP_CHECKSIG <pubkey2> OP_CHECKSIGADD ... <m> OP_NUMEQUAL
+	*/
+	if !b.tapscript || len(b.stack) < 3 {
+		return false
+	}
+	pubKey := b.popStack()
+	n, ok := b.popNum(MAX_SCRIPT_NUM_LENGTH)
+	if !ok {
+		return false
+	}
+	sig := b.popStack()
+	success, ok := b.checkSchnorrSig(sig, pubKey)
+	if !ok {
+		return false
+	}
+	if success {
+		n += 1
+	}
+	b.stack = append(b.stack, b.EncodeNum(n))
+	return true
+}
+
+func (b *BitcoinOpCode) opCheckSigVerify(zBin []byte) bool {
+	return b.opCheckSig(zBin) && b.opVerify()
+}
+
+func (b *BitcoinOpCode) popElements(count int) [][]byte {
+	/*
+		pop count elements from the stack, keep them in the order they
+		are pushed
+	*/
+	elements := make([][]byte, count)
+	copy(elements, b.stack[len(b.stack)-count:])
+	b.stack = b.stack[0 : len(b.stack)-count]
+	return elements
+}
+
+func (b *BitcoinOpCode) opCheckMultiSig(zBin []byte) bool {
+	/*
+		m of n multisig, the stack looks like:
+		[dummy, sig1, ..., sigm, m, pubkey1, ..., pubkeyn, n]
+
+		notice there is one more element at the bottom, it is a bug of the
+		original implementation which pops one more element than needed,
+		the dummy element is required to be empty(OP_0) to fix malleability
+
+		signatures need to be in the same order as the public keys they
+		are matched with, each public key can only be used once
+	*/
+	// tapscript uses OP_CHECKSIGADD instead
+	if b.tapscript || len(b.stack) < 1 {
+		return false
+	}
+	n, ok := b.popNum(MAX_SCRIPT_NUM_LENGTH)
+	if !ok || n < 0 || n > MAX_PUBKEYS_PER_MULTISIG {
+		return false
+	}
+	if !b.countOps(int(n)) {
+		return false
+	}
+	if len(b.stack) < int(n)+1 {
+		return false
+	}
+	pubKeys := b.popElements(int(n))
+
+	m, ok := b.popNum(MAX_SCRIPT_NUM_LENGTH)
+	if !ok || m < 0 || m > n {
+		return false
+	}
+	// m signatures and the dummy element
+	if len(b.stack) < int(m)+1 {
+		return false
+	}
+	derSigs := b.popElements(int(m))
+	dummy := b.popStack()
+	if b.flags&SCRIPT_VERIFY_NULLDUMMY != 0 && len(dummy) != 0 {
+		return false
+	}
+
+	// message of each signature depends on its hash type byte
+	zFields := make([]*ecc.FieldElement, len(derSigs))
+	for i, derSig := range derSigs {
+		if len(derSig) > 0 {
+			if zFields[i], ok = b.signatureHash(zBin, derSig[len(derSig)-1]); !ok {
+				return false
+			}
+		}
+	}
+
+	success := true
+	sigIdx := 0
+	keyIdx := 0
+	for success && sigIdx < len(derSigs) {
+		derSig := derSigs[sigIdx]
+		if len(derSig) > 0 {
+			// remove the hash type byte at the end
+			sig, err := ecc.ParseSigBin(derSig[0 : len(derSig)-1])
+			if err != nil {
+				return false
+			}
+			point, err := ecc.ParseSEC(pubKeys[keyIdx])
+			if err == nil && point.Verify(zFields[sigIdx], sig) {
+				sigIdx += 1
+			}
+		}
+		keyIdx += 1
+
+		// more signatures left than public keys, no way to match all of them
+		if len(derSigs)-sigIdx > len(pubKeys)-keyIdx {
+			success = false
+		}
+	}
+
+	b.pushBool(success)
+	return true
+}
+
+func (b *BitcoinOpCode) opCheckMultiSigVerify(zBin []byte) bool {
+	return b.opCheckMultiSig(zBin) && b.opVerify()
+}
+
+func (b *BitcoinOpCode) opCheckLockTimeVerify() bool {
+	/*
+		BIP65, the top element is the locktime the output can be spent after,
+		the spending transaction needs a lockTime at least that value in the
+		same unit(block height or timestamp), and the input can't be final,
+		otherwise lockTime of the transaction is ignored
+	*/
+	if len(b.stack) < 1 || b.lockTime == nil || b.sequence == nil {
+		return false
+	}
+
+	elem := b.stack[len(b.stack)-1]
+	if len(elem) > LOCKTIME_NUM_LENGTH {
+		return false
+	}
+	lockTime := b.DecodeNum(elem)
+	if lockTime < 0 {
+		return false
+	}
+
+	txLockTime := b.lockTime.Int64()
+	if (lockTime < LOCKTIME_THRESHOLD) != (txLockTime < LOCKTIME_THRESHOLD) {
+		return false
+	}
+	if lockTime > txLockTime {
+		return false
+	}
+
+	return b.sequence.Int64() != SEQUENCE_FINAL
+}
+
+func (b *BitcoinOpCode) opCheckSequenceVerify() bool {
+	/*
+		BIP112, the top element is the relative locktime of the output, compare
+		it with the sequence of the spending input as defined in BIP68
+	*/
+	if len(b.stack) < 1 || b.txVersion == nil || b.sequence == nil {
+		return false
+	}
+
+	elem := b.stack[len(b.stack)-1]
+	if len(elem) > LOCKTIME_NUM_LENGTH {
+		return false
+	}
+	sequence := b.DecodeNum(elem)
+	if sequence < 0 {
+		return false
+	}
+	if sequence&SEQUENCE_LOCKTIME_DISABLE_FLAG != 0 {
+		// relative locktime is disabled, behave as OP_NOP
+		return true
+	}
+
+	if b.txVersion.Int64() < 2 {
+		return false
+	}
+	txSequence := b.sequence.Int64()
+	if txSequence&SEQUENCE_LOCKTIME_DISABLE_FLAG != 0 {
+		return false
+	}
+
+	mask := int64(SEQUENCE_LOCKTIME_TYPE_FLAG | SEQUENCE_LOCKTIME_MASK)
+	sequence &= mask
+	txSequence &= mask
+	if (sequence < SEQUENCE_LOCKTIME_TYPE_FLAG) != (txSequence < SEQUENCE_LOCKTIME_TYPE_FLAG) {
+		return false
+	}
+
+	return sequence <= txSequence
+}
+
+func (b *BitcoinOpCode) RemoveCmd() ([]byte, bool) {
+	/*
+		return the first command and whether it is a chunk of data
+	*/
 	cmd := b.cmds[0]
+	isData := b.dataCmds[0]
 	b.cmds = b.cmds[1:]
-	return cmd
+	b.dataCmds = b.dataCmds[1:]
+	b.cmdPosition += 1
+	return cmd, isData
 }
 
 func (b *BitcoinOpCode) HasCmd() bool {
@@ -342,25 +1117,196 @@ func (b *BitcoinOpCode) AppendDataElement(element []byte) {
 	b.stack = append(b.stack, element)
 }
 
+func (b *BitcoinOpCode) SetTransactionContext(version, lockTime, sequence *big.Int) {
+	b.txVersion = version
+	b.lockTime = lockTime
+	b.sequence = sequence
+}
+
+func (b *BitcoinOpCode) countOps(count int) bool {
+	// tapscript limits signature checks by validation weight instead
+	if b.tapscript {
+		return true
+	}
+	b.opCount += count
+	return b.opCount <= MAX_OPS_PER_SCRIPT
+}
+
+func (b *BitcoinOpCode) SetFlags(flags SCRIPT_FLAG) {
+	b.flags = flags
+}
+
+func (b *BitcoinOpCode) SetSigHash(sigHash func(hashType byte) ([]byte, bool)) {
+	b.sigHash = sigHash
+}
+
+func (b *BitcoinOpCode) signatureHash(zBin []byte, hashType byte) (*ecc.FieldElement, bool) {
+	/*
+		the last byte of signature is the hash type, it decides which parts of
+		the transaction are signed, without sigHash we take zBin as the message,
+		the script fails if the message can't be computed, like the previous
+		output is not found
+	*/
+	if b.sigHash != nil {
+		var ok bool
+		zBin, ok = b.sigHash(hashType)
+		if !ok {
+			return nil, false
+		}
+	}
+	z := new(big.Int)
+	z.SetBytes(zBin)
+	return ecc.NewFieldElement(ecc.GetBitcoinValueN(), z), true
+}
+
+func isDisabledOpCode(cmd int) bool {
+	/*
+		these op codes fail the script even they are in a branch not executed
+	*/
+	switch cmd {
+	case OP_CAT, OP_SUBSTR, OP_LEFT, OP_RIGHT, OP_INVERT, OP_AND, OP_OR,
+		OP_XOR, OP_2MUL, OP_2DIV, OP_MUL, OP_DIV, OP_MOD, OP_LSHIFT, OP_RSHIFT,
+		OP_VERIF, OP_VERNOTIF:
+		return true
+	}
+
+	return false
+}
+
+func isOpSuccess(cmd int) bool {
+	/*
+		op codes not defined in tapscript, the script succeeds when any of
+		them appears, new op codes can be added by soft fork with them
+	*/
+	return cmd == 80 || cmd == 98 || (cmd >= 126 && cmd <= 129) ||
+		(cmd >= 131 && cmd <= 134) || (cmd >= 137 && cmd <= 138) ||
+		(cmd >= 141 && cmd <= 142) || (cmd >= 149 && cmd <= 153) ||
+		(cmd >= 187 && cmd <= 254)
+}
+
+func (b *BitcoinOpCode) SetTapscript(validationWeight int64, tapSigHash func(hashType byte, codeSepPosition uint32) ([]byte, bool)) {
+	b.tapscript = true
+	b.validationWeight = validationWeight
+	b.tapSigHash = tapSigHash
+	// argument of OP_IF is required to be minimal in tapscript
+	b.flags |= SCRIPT_VERIFY_MINIMALIF
+}
+
+func isConditionalOpCode(cmd int) bool {
+	return cmd == OP_IF || cmd == OP_NOTIF || cmd == OP_ELSE || cmd == OP_ENDIF
+}
+
 func (b *BitcoinOpCode) ExecuteOperaion(cmd int, z []byte) bool {
 	/*
 		if the operation executed successfuly then return true,
 		otherwise return false
 	*/
+	switch {
+	case cmd == OP_0:
+		return b.opPushNum(0)
+	case cmd == OP_1NEGATE:
+		return b.opPushNum(-1)
+	case cmd >= OP_1 && cmd <= OP_16:
+		return b.opPushNum(int64(cmd - OP_1 + 1))
+	case cmd == OP_NOP || (cmd >= OP_NOP4 && cmd <= OP_NOP10) || cmd == OP_NOP1:
+		return true
+	}
+
 	switch cmd {
-	case OP_CHECKSIG:
-		return b.opCheckSig(z)
+	case OP_IF:
+		return b.opIf(false)
+	case OP_NOTIF:
+		return b.opIf(true)
+	case OP_ELSE:
+		return b.opElse()
+	case OP_ENDIF:
+		return b.opEndIf()
+	case OP_VERIFY:
+		return b.opVerify()
+	case OP_RETURN:
+		return false
+	case OP_TOALTSTACK:
+		return b.opToAltStack()
+	case OP_FROMALTSTACK:
+		return b.opFromAltStack()
+	case OP_2DROP:
+		return b.opDrop(2)
+	case OP_2DUP:
+		return b.opCopy(2, 0)
+	case OP_3DUP:
+		return b.opCopy(3, 0)
+	case OP_2OVER:
+		return b.opCopy(2, 2)
+	case OP_2ROT:
+		return b.opMoveToTop(2, 4)
+	case OP_2SWAP:
+		return b.opMoveToTop(2, 2)
+	case OP_IFDUP:
+		return b.opIfDup()
+	case OP_DEPTH:
+		return b.opDepth()
+	case OP_DROP:
+		return b.opDrop(1)
 	case OP_DUP:
 		return b.opDup()
-	case OP_HASH160:
-		return b.opHash160()
+	case OP_NIP:
+		return b.opNip()
+	case OP_OVER:
+		return b.opCopy(1, 1)
+	case OP_PICK:
+		return b.opPickOrRoll(false)
+	case OP_ROLL:
+		return b.opPickOrRoll(true)
+	case OP_ROT:
+		return b.opMoveToTop(1, 2)
+	case OP_SWAP:
+		return b.opMoveToTop(1, 1)
+	case OP_TUCK:
+		return b.opTuck()
+	case OP_SIZE:
+		return b.opSize()
+	case OP_EQUAL:
+		return b.opEqual()
 	case OP_EQUALVERIFY:
 		return b.opEqualVerify()
+	case OP_1ADD, OP_1SUB, OP_NEGATE, OP_ABS, OP_NOT, OP_0NOTEQUAL:
+		return b.opUnaryArithmetic(cmd)
+	case OP_ADD, OP_SUB, OP_BOOLAND, OP_BOOLOR, OP_NUMEQUAL, OP_NUMEQUALVERIFY,
+		OP_NUMNOTEQUAL, OP_LESSTHAN, OP_GREATERTHAN, OP_LESSTHANOREQUAL,
+		OP_GREATERTHANOREQUAL, OP_MIN, OP_MAX:
+		return b.opBinaryArithmetic(cmd)
+	case OP_WITHIN:
+		return b.opWithin()
+	case OP_RIPEMD160, OP_SHA1, OP_SHA256, OP_HASH160, OP_HASH256:
+		return b.opHash(cmd)
+	case OP_CODESEPARATOR:
+		/*
+			legacy signature message is computed before evaluation, tapscript
+			message commits to the position of the last one executed
+		*/
+		b.codeSepPosition = b.cmdPosition - 1
+		return true
+	case OP_CHECKSIG:
+		return b.opCheckSig(z)
+	case OP_CHECKSIGVERIFY:
+		return b.opCheckSigVerify(z)
+	case OP_CHECKMULTISIG:
+		return b.opCheckMultiSig(z)
+	case OP_CHECKMULTISIGVERIFY:
+		return b.opCheckMultiSigVerify(z)
+	case OP_CHECKSIGADD:
+		return b.opCheckSigAdd()
+	case OP_CHECKLOCKTIMEVERIFY:
+		return b.opCheckLockTimeVerify()
+	case OP_CHECKSEQUENCEVERIFY:
+		return b.opCheckSequenceVerify()
 	default:
-		errStr := fmt.Sprintf("opeation %s not implemented\n", b.opCodeNames[cmd])
-		panic(errStr)
+		/*
+			disabled op codes, reserved op codes, OP_VERIF, OP_VERNOTIF and
+			undefined op codes all make the script fail
+		*/
+		return false
 	}
-
 }
 
 func (b *BitcoinOpCode) EncodeNum(num int64) []byte {
@@ -1,8 +1,15 @@
 package transaction
 
 import (
+	"bufio"
+	"bytes"
+	ecc "elliptic_curve"
+	"encoding/hex"
 	"fmt"
+	"math/big"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestOpCodeMainTest(t *testing.T) {
@@ -19,3 +26,215 @@ func TestOpCodeMainTest(t *testing.T) {
 	fmt.Printf("encode -32896: %x\n", encodeVal)
 	fmt.Printf("decode -32896: %d\n", opCode.DecodeNum(encodeVal))
 }
+
+func newScriptFromHex(t *testing.T, scriptHex string) *ScriptSig {
+	raw, err := hex.DecodeString(scriptHex)
+	assert.Nil(t, err)
+	script := append(EncodeVarint(big.NewInt(int64(len(raw)))), raw...)
+	scriptSig, err := NewScriptSig(bufio.NewReader(bytes.NewReader(script)))
+	assert.Nil(t, err)
+	return scriptSig
+}
+
+func TestExecuteOperation(t *testing.T) {
+	testCases := []struct {
+		name   string
+		script string
+		result bool
+	}{
+		// OP_2 OP_3 OP_ADD OP_5 OP_EQUAL
+		{"add", "5253935587", true},
+		// OP_2 OP_3 OP_SUB OP_1NEGATE OP_NUMEQUAL
+		{"sub", "5253944f9c", true},
+		// 5 bytes operand can't be used in arithmetic: <0102030405> OP_1ADD
+		{"operand too long", "0501020304058b", false},
+		// OP_1 OP_2 OP_3 OP_2 OP_PICK OP_1 OP_EQUAL
+		{"pick", "51525352795187", true},
+		// OP_1 OP_2 OP_3 OP_2 OP_ROLL OP_1 OP_EQUALVERIFY OP_3 OP_EQUALVERIFY OP_2 OP_EQUAL
+		{"roll", "515253527a518853885287", true},
+		// OP_1 OP_2 OP_3 OP_3 OP_PICK, index out of the stack
+		{"pick out of range", "5152535379", false},
+		// OP_1 OP_2 OP_3 OP_ROT OP_1 OP_EQUALVERIFY OP_3 OP_EQUALVERIFY OP_2 OP_EQUAL
+		{"rot", "5152537b518853885287", true},
+		// OP_1 OP_2 OP_TUCK OP_2 OP_EQUALVERIFY OP_1 OP_EQUALVERIFY OP_2 OP_EQUAL
+		{"tuck", "51527d528851885287", true},
+		// OP_1 OP_2 OP_3 OP_4 OP_2SWAP OP_2 OP_EQUALVERIFY OP_1 OP_EQUALVERIFY OP_4 OP_EQUAL
+		{"2swap", "5152535472528851885487", true},
+		// OP_5 OP_2 OP_6 OP_WITHIN
+		{"within", "555256a5", true},
+		// OP_6 OP_2 OP_6 OP_WITHIN
+		{"not within", "565256a5", false},
+		// <aabbcc> OP_SIZE OP_3 OP_EQUALVERIFY
+		{"size", "03aabbcc825388", true},
+		// OP_0 OP_SHA256 <sha256 of empty string> OP_EQUAL
+		{"sha256", "00a820e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b85587", true},
+		// OP_0 OP_SHA1 <sha1 of empty string> OP_EQUAL
+		{"sha1", "00a714da39a3ee5e6b4b0d3255bfef95601890afd8070987", true},
+		// OP_0 OP_RIPEMD160 <ripemd160 of empty string> OP_EQUAL
+		{"ripemd160", "00a6149c1185a5c5e9fc54612808977ee8f548b2258d3187", true},
+		// OP_1 OP_TOALTSTACK OP_0 OP_FROMALTSTACK OP_NIP
+		{"altstack", "516b006c77", true},
+		// OP_FROMALTSTACK with empty alt stack
+		{"empty altstack", "6c", false},
+		// one byte data 0xac is not OP_CHECKSIG: <ac> OP_DROP OP_1
+		{"one byte data", "01ac7551", true},
+		// <80> negative zero is false
+		{"negative zero", "0180", false},
+		// OP_1 OP_RETURN
+		{"return", "516a", false},
+		// OP_1 OP_1 OP_CAT, disabled op code
+		{"disabled", "51517e", false},
+		// OP_1 OP_NOP OP_NOP10
+		{"nop", "5161b9", true},
+	}
+
+	for _, testCase := range testCases {
+		script := newScriptFromHex(t, testCase.script)
+		assert.Equal(t, testCase.result, script.Evaluate([]byte{}), testCase.name)
+	}
+}
+
+func TestLockTimeOperation(t *testing.T) {
+	// <500> OP_CHECKLOCKTIMEVERIFY OP_DROP OP_1
+	script := newScriptFromHex(t, "02f401b17551")
+	assert.False(t, script.Evaluate([]byte{}))
+
+	script = newScriptFromHex(t, "02f401b17551")
+	script.SetTransactionContext(big.NewInt(1), big.NewInt(600), big.NewInt(0xfffffffe))
+	assert.True(t, script.Evaluate([]byte{}))
+
+	// lockTime of the transaction is not reached yet
+	script = newScriptFromHex(t, "02f401b17551")
+	script.SetTransactionContext(big.NewInt(1), big.NewInt(400), big.NewInt(0xfffffffe))
+	assert.False(t, script.Evaluate([]byte{}))
+
+	// final input disables the lock time
+	script = newScriptFromHex(t, "02f401b17551")
+	script.SetTransactionContext(big.NewInt(1), big.NewInt(600), big.NewInt(0xffffffff))
+	assert.False(t, script.Evaluate([]byte{}))
+
+	// <10> OP_CHECKSEQUENCEVERIFY OP_DROP OP_1
+	script = newScriptFromHex(t, "5ab27551")
+	script.SetTransactionContext(big.NewInt(2), big.NewInt(0), big.NewInt(10))
+	assert.True(t, script.Evaluate([]byte{}))
+
+	// version 1 transaction doesn't support relative lock time
+	script = newScriptFromHex(t, "5ab27551")
+	script.SetTransactionContext(big.NewInt(1), big.NewInt(0), big.NewInt(10))
+	assert.False(t, script.Evaluate([]byte{}))
+}
+
+func TestCheckMultiSig(t *testing.T) {
+	z, _ := hex.DecodeString("e71bfa115715d6fd33796948126f40a8cdd39f187e4afb03896795189fe1423c")
+	sig1, _ := hex.DecodeString("3045022100dc92655fe37036f47756db8102e0d7d5e28b3beb83a8fef4f5dc0559bddfb94e02205a36d4e4e6c7fcd16658c50783e00c341609977aed3ad00937bf4ee942a8993701")
+	sig2, _ := hex.DecodeString("3045022100da6bee3c93766232079a01639d07fa869598749729ae323eab8eef53577d611b02207bef15429dcadce2121ea07f233115c6f09034c0be68db99980b9a6c5e75402201")
+	sec1, _ := hex.DecodeString("022626e955ea6ea6d98850c994f9107b036b1334f18ca8830bfff1295d21cfdb70")
+	sec2, _ := hex.DecodeString("03b287eaf122eea69030a0e9feed096bed8045c8b98bec453e1ffac7fbdbd4bb71")
+
+	opCode := NewBitcoinOpCode()
+	opCode.stack = [][]byte{{}, sig1, sig2, {0x02}, sec1, sec2, {0x02}}
+	assert.True(t, opCode.opCheckMultiSig(z))
+	assert.Equal(t, 1, len(opCode.stack))
+	assert.True(t, castToBool(opCode.stack[0]))
+
+	// signatures in different order with the public keys
+	opCode = NewBitcoinOpCode()
+	opCode.stack = [][]byte{{}, sig2, sig1, {0x02}, sec1, sec2, {0x02}}
+	assert.True(t, opCode.opCheckMultiSig(z))
+	assert.False(t, castToBool(opCode.stack[0]))
+
+	// missing dummy element
+	opCode = NewBitcoinOpCode()
+	opCode.stack = [][]byte{sig1, sig2, {0x02}, sec1, sec2, {0x02}}
+	assert.False(t, opCode.opCheckMultiSig(z))
+
+	// dummy element must be empty with NULLDUMMY
+	opCode = NewBitcoinOpCode()
+	opCode.stack = [][]byte{{0x01}, sig1, sig2, {0x02}, sec1, sec2, {0x02}}
+	assert.True(t, opCode.opCheckMultiSig(z))
+	opCode = NewBitcoinOpCode()
+	opCode.SetFlags(SCRIPT_VERIFY_NULLDUMMY)
+	opCode.stack = [][]byte{{0x01}, sig1, sig2, {0x02}, sec1, sec2, {0x02}}
+	assert.False(t, opCode.opCheckMultiSig(z))
+}
+
+func TestBareMultiSigScript(t *testing.T) {
+	z := ecc.Hash256("bare multisig spending transaction")
+	zNum := new(big.Int)
+	zNum.SetBytes(z)
+
+	pubKeys := [][]byte{}
+	sigs := [][]byte{}
+	for i := 1; i <= 3; i++ {
+		key := ecc.NewPrivateKey(big.NewInt(int64(1000 + i)))
+		_, sec := key.GetPublicKey().Sec(true)
+		pubKeys = append(pubKeys, sec)
+		sigs = append(sigs, append(key.Sign(zNum).Der(), SIGHASH_ALL))
+	}
+
+	// 2 of 3, sign with the first and the third key
+	scriptSig := InitScriptSig([][]byte{{OP_0}, sigs[0], sigs[2]})
+	script := scriptSig.Add(P2msScript(2, pubKeys))
+	script.SetFlags(SCRIPT_VERIFY_NULLDUMMY)
+	assert.True(t, script.Evaluate(z))
+
+	// the same key can't be counted twice
+	scriptSig = InitScriptSig([][]byte{{OP_0}, sigs[0], sigs[0]})
+	assert.False(t, scriptSig.Add(P2msScript(2, pubKeys)).Evaluate(z))
+
+	// not enough signatures
+	scriptSig = InitScriptSig([][]byte{{OP_0}, sigs[1], {OP_0}})
+	assert.False(t, scriptSig.Add(P2msScript(2, pubKeys)).Evaluate(z))
+
+	// OP_CHECKMULTISIGVERIFY leaves nothing on the stack
+	pubKeyScript := P2msScript(2, pubKeys)
+	pubKeyScript.bitcoinOpCode.cmds[len(pubKeyScript.bitcoinOpCode.cmds)-1] = []byte{OP_CHECKMULTISIGVERIFY}
+	scriptSig = InitScriptSig([][]byte{{OP_0}, sigs[1], sigs[2]})
+	script = scriptSig.Add(pubKeyScript).Add(InitScriptSig([][]byte{{OP_1}}))
+	assert.True(t, script.Evaluate(z))
+}
+
+func TestCheckSigEncoding(t *testing.T) {
+	z := ecc.Hash256("check signature encoding")
+	zNum := new(big.Int)
+	zNum.SetBytes(z)
+	key := ecc.NewPrivateKey(big.NewInt(int64(2024)))
+	_, sec := key.GetPublicKey().Sec(true)
+	sig := append(key.Sign(zNum).Der(), SIGHASH_ALL)
+
+	opCode := NewBitcoinOpCode()
+	opCode.stack = [][]byte{sig, sec}
+	assert.True(t, opCode.opCheckSig(z))
+	assert.Equal(t, []byte{0x01}, opCode.stack[0])
+
+	// invalid public key only fails the check
+	badKey := append([]byte{0x05}, sec[1:]...)
+	opCode = NewBitcoinOpCode()
+	opCode.stack = [][]byte{sig, badKey}
+	assert.True(t, opCode.opCheckSig(z))
+	assert.False(t, castToBool(opCode.stack[0]))
+
+	// signature not in strict der fails the script
+	badSig := append([]byte{}, sig...)
+	badSig[0] = 0x31
+	opCode = NewBitcoinOpCode()
+	opCode.stack = [][]byte{badSig, sec}
+	assert.False(t, opCode.opCheckSig(z))
+}
+
+func TestCheckSigOverflow(t *testing.T) {
+	// r = n is strict DER but never verifies, NOT turns the false into true
+	z := ecc.Hash256("check signature overflow")
+	key := ecc.NewPrivateKey(big.NewInt(int64(2024)))
+	_, sec := key.GetPublicKey().Sec(true)
+	sig := append([]byte{0x30, 0x26, 0x02, 0x21, 0x00}, ecc.GetBitcoinValueN().Bytes()...)
+	sig = append(sig, 0x02, 0x01, 0x01, SIGHASH_ALL)
+
+	opCode := NewBitcoinOpCode()
+	opCode.stack = [][]byte{sig, sec}
+	assert.True(t, opCode.opCheckSig(z))
+	assert.False(t, castToBool(opCode.stack[0]))
+
+	script := InitScriptSig([][]byte{sig, sec, {OP_CHECKSIG}, {OP_NOT}})
+	assert.True(t, script.Evaluate(z))
+}
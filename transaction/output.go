@@ -23,18 +23,23 @@ func (t *TransactionOutput) String() string {
 	return fmt.Sprintf("amount: %v\n scriptPubKey: %x\n", t.amount, t.scriptPubKey.Serialize())
 }
 
-func NewTransactionOutput(reader *bufio.Reader) *TransactionOutput {
+func NewTransactionOutput(reader *bufio.Reader) (*TransactionOutput, error) {
 	/*
 		amount is in stashi 1/100,000,0000 of one bitcoin
 	*/
-	amountBuf := make([]byte, 8)
-	reader.Read(amountBuf)
+	amountBuf, err := ReadBytes(reader, 8)
+	if err != nil {
+		return nil, fmt.Errorf("read amount: %w", err)
+	}
 	amount := LittleEndianToBigInt(amountBuf, LITTLE_ENDIAN_8_BYTES)
-	script := NewScriptSig(reader)
+	script, err := readTransactionScript(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read scriptPubKey: %w", err)
+	}
 	return &TransactionOutput{
 		amount:       amount,
 		scriptPubKey: script,
-	}
+	}, nil
 }
 
 func (t *TransactionOutput) Serialize() []byte {
@@ -2,12 +2,24 @@ package transaction
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"math/big"
 )
 
 type ScriptSig struct {
 	cmds          [][]byte
 	bitcoinOpCode *BitcoinOpCode
+	raw           []byte // bytes of the parsed script, nil for script we build
+	parseErr      error  // the script bytes can't be parsed into commands
+	/*
+		set when the scriptPubKey is p2sh, the redeem script runs after
+		scriptSig + scriptPubKey on the stack left by the scriptSig
+	*/
+	redeemScript *ScriptSig
+	redeemStack  [][]byte
 }
 
 const (
@@ -16,11 +28,25 @@ const (
 	SCRIPT_DATA_LENGTH_END   = 75
 	OP_PUSHDATA1             = 76
 	OP_PUSHDATA2             = 77
+	OP_PUSHDATA4             = 78
 )
 
 func InitScriptSig(cmds [][]byte) *ScriptSig {
+	/*
+		command with only one byte is taken as operation, use
+		NewScriptSig for script containing one byte chunk of data
+	*/
+	dataCmds := make([]bool, len(cmds))
+	for i, cmd := range cmds {
+		dataCmds[i] = len(cmd) != 1
+	}
+	return initScriptSigWithDataFlags(cmds, dataCmds)
+}
+
+func initScriptSigWithDataFlags(cmds [][]byte, dataCmds []bool) *ScriptSig {
 	bitcoinOpCode := NewBitcoinOpCode()
 	bitcoinOpCode.cmds = cmds
+	bitcoinOpCode.dataCmds = dataCmds
 	return &ScriptSig{
 		bitcoinOpCode: bitcoinOpCode,
 	}
@@ -62,76 +88,443 @@ read one byte, data operation, get length of data, move chunk of data on to stac
 get elements from the stack, do the operation, push the result on to the stack
 */
 
-func NewScriptSig(reader *bufio.Reader) *ScriptSig {
-	cmds := [][]byte{}
+func NewScriptSig(reader *bufio.Reader) (*ScriptSig, error) {
 	/*
 		At the beginning is the total length for script field
 	*/
-	scriptLen := ReadVarint(reader).Int64()
+	raw, err := readScriptBytes(reader)
+	if err != nil {
+		return nil, err
+	}
+	return parseRawScript(raw)
+}
+
+func readScriptBytes(reader *bufio.Reader) ([]byte, error) {
+	scriptLen, err := ReadVarint(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read script length: %w", err)
+	}
+	return ReadBytes(reader, int(scriptLen.Int64()))
+}
+
+func readTransactionScript(reader *bufio.Reader) (*ScriptSig, error) {
+	/*
+		script in transaction is only a chunk of bytes for the consensus, a
+		push going beyond the end of script makes the transaction invalid
+		when the script runs, but the transaction can still be parsed and
+		serialized, we keep the bytes and fail the evaluation
+	*/
+	raw, err := readScriptBytes(reader)
+	if err != nil {
+		return nil, err
+	}
+	script, err := parseRawScript(raw)
+	if errors.Is(err, ErrScriptLengthMismatch) {
+		script = initScriptSigWithDataFlags([][]byte{}, []bool{})
+		script.raw = raw
+		script.parseErr = err
+		return script, nil
+	}
+	return script, err
+}
+
+func parseRawScript(raw []byte) (*ScriptSig, error) {
+	/*
+		parse script without the length prefix, like the redeem script
+		pushed on to the stack by scriptSig of p2sh input
+	*/
+	cmds := [][]byte{}
+	dataCmds := []bool{}
+	scriptLen := int64(len(raw))
 	count := int64(0)
-	current := make([]byte, 1)
-	var current_byte byte
+	/*
+		readData reads the chunk of data for push command, the data can't
+		go beyond the end of script
+	*/
+	readData := func(length int64) ([]byte, error) {
+		if count+length > scriptLen {
+			return nil, fmt.Errorf("%w: push %d bytes with %d bytes left", ErrScriptLengthMismatch,
+				length, scriptLen-count)
+		}
+		data := append([]byte{}, raw[count:count+length]...)
+		count += length
+		return data, nil
+	}
+
 	for count < scriptLen {
-		reader.Read(current)
+		current, err := readData(1)
+		if err != nil {
+			return nil, err
+		}
 		//operation
-		count += 1
-		current_byte = current[0]
+		current_byte := current[0]
+		var length int64
+		isData := true
 		if current_byte >= SCRIPT_DATA_LENGTH_BEGIN &&
 			current_byte <= SCRIPT_DATA_LENGTH_END {
 			//push the following bytes of data onto stack
-			data := make([]byte, current_byte)
-			reader.Read(data)
-			cmds = append(cmds, data)
-			count += int64(current_byte)
+			length = int64(current_byte)
 		} else if current_byte == OP_PUSHDATA1 {
 			/*
 				read the following byte as the length of data
 			*/
-			length := make([]byte, 1)
-			reader.Read(length)
-
-			data := make([]byte, length[0])
-			reader.Read(data)
-			cmds = append(cmds, data)
-			count += int64(length[0] + 1)
+			lenBuf, err := readData(1)
+			if err != nil {
+				return nil, err
+			}
+			length = int64(lenBuf[0])
 		} else if current_byte == OP_PUSHDATA2 {
 			/*
 				read the following 2 bytes as length of data
 			*/
-			lenBuf := make([]byte, 2)
-			reader.Read(lenBuf)
-			length := LittleEndianToBigInt(lenBuf, LITTLE_ENDIAN_2_BYTES)
-			data := make([]byte, length.Int64())
-			reader.Read(data)
-			cmds = append(cmds, data)
-			count += int64(2 + length.Int64())
+			lenBuf, err := readData(2)
+			if err != nil {
+				return nil, err
+			}
+			length = LittleEndianToBigInt(lenBuf, LITTLE_ENDIAN_2_BYTES).Int64()
+		} else if current_byte == OP_PUSHDATA4 {
+			/*
+				read the following 4 bytes as length of data
+			*/
+			lenBuf, err := readData(4)
+			if err != nil {
+				return nil, err
+			}
+			length = LittleEndianToBigInt(lenBuf, LITTLE_ENDIAN_4_BYTES).Int64()
 		} else {
 			//is data processing instruction
+			isData = false
+		}
+
+		if isData {
+			data, err := readData(length)
+			if err != nil {
+				return nil, err
+			}
+			cmds = append(cmds, data)
+		} else {
 			cmds = append(cmds, []byte{current_byte})
 		}
+		dataCmds = append(dataCmds, isData)
+	}
+
+	script := initScriptSigWithDataFlags(cmds, dataCmds)
+	/*
+		keep the original bytes, push of data may not be in the shortest
+		form and serializing from the commands would change the transaction id
+	*/
+	script.raw = append([]byte{}, raw...)
+	return script, nil
+}
+
+func (s *ScriptSig) IsP2pkhScriptPubKey() bool {
+	// OP_DUP OP_HASH160 <20 bytes hash> OP_EQUALVERIFY OP_CHECKSIG
+	cmds := s.bitcoinOpCode.cmds
+	dataCmds := s.bitcoinOpCode.dataCmds
+	return len(cmds) == 5 && !dataCmds[0] && cmds[0][0] == OP_DUP &&
+		!dataCmds[1] && cmds[1][0] == OP_HASH160 &&
+		dataCmds[2] && len(cmds[2]) == 20 &&
+		!dataCmds[3] && cmds[3][0] == OP_EQUALVERIFY &&
+		!dataCmds[4] && cmds[4][0] == OP_CHECKSIG
+}
+
+func (s *ScriptSig) IsP2shScriptPubKey() bool {
+	// OP_HASH160 <20 bytes hash> OP_EQUAL
+	cmds := s.bitcoinOpCode.cmds
+	dataCmds := s.bitcoinOpCode.dataCmds
+	return len(cmds) == 3 && !dataCmds[0] && cmds[0][0] == OP_HASH160 &&
+		dataCmds[1] && len(cmds[1]) == 20 &&
+		!dataCmds[2] && cmds[2][0] == OP_EQUAL
+}
+
+func (s *ScriptSig) IsP2wpkhScriptPubKey() bool {
+	// OP_0 <20 bytes hash>
+	cmds := s.bitcoinOpCode.cmds
+	dataCmds := s.bitcoinOpCode.dataCmds
+	return len(cmds) == 2 && !dataCmds[0] && cmds[0][0] == OP_0 &&
+		dataCmds[1] && len(cmds[1]) == 20
+}
+
+func (s *ScriptSig) IsP2wshScriptPubKey() bool {
+	// OP_0 <32 bytes hash>
+	cmds := s.bitcoinOpCode.cmds
+	dataCmds := s.bitcoinOpCode.dataCmds
+	return len(cmds) == 2 && !dataCmds[0] && cmds[0][0] == OP_0 &&
+		dataCmds[1] && len(cmds[1]) == 32
+}
+
+func (s *ScriptSig) IsP2trScriptPubKey() bool {
+	// OP_1 <32 bytes output key>
+	cmds := s.bitcoinOpCode.cmds
+	dataCmds := s.bitcoinOpCode.dataCmds
+	return len(cmds) == 2 && !dataCmds[0] && cmds[0][0] == OP_1 &&
+		dataCmds[1] && len(cmds[1]) == 32
+}
+
+func (s *ScriptSig) IsPushOnly() bool {
+	// only data and the op codes pushing numbers: OP_0, OP_1NEGATE, OP_1 to OP_16
+	for i, cmd := range s.bitcoinOpCode.cmds {
+		if s.bitcoinOpCode.dataCmds[i] {
+			continue
+		}
+		op := int(cmd[0])
+		if op != OP_0 && op != OP_1NEGATE && (op < OP_1 || op > OP_16) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *ScriptSig) p2shRedeemScript() (*ScriptSig, [][]byte, bool) {
+	/*
+		pay to script hash(BIP16), the scriptSig can only push data, the top
+		of the stack it leaves is the redeem script, which is the serialized
+		script without the length prefix, the elements below it are the
+		input of the redeem script
+	*/
+	if !s.IsPushOnly() {
+		return nil, nil, false
+	}
+	opCode := NewBitcoinOpCode()
+	for i, cmd := range s.bitcoinOpCode.cmds {
+		if s.bitcoinOpCode.dataCmds[i] {
+			opCode.AppendDataElement(cmd)
+		} else if !opCode.ExecuteOperaion(int(cmd[0]), nil) {
+			return nil, nil, false
+		}
+	}
+	if len(opCode.stack) == 0 {
+		return nil, nil, false
+	}
+	redeemScript, err := parseRawScript(opCode.stack[len(opCode.stack)-1])
+	if err != nil {
+		return nil, nil, false
+	}
+	return redeemScript, opCode.stack[0 : len(opCode.stack)-1], true
+}
+
+func (s *ScriptSig) SetRedeemScript(redeemScript *ScriptSig, stack [][]byte) {
+	/*
+		the redeem script of p2sh and the stack the scriptSig leaves below it,
+		scriptSig + scriptPubKey only checks the hash of the redeem script
+	*/
+	s.redeemScript = redeemScript
+	s.redeemStack = stack
+}
+
+func (s *ScriptSig) evaluateP2sh(z []byte, witness [][]byte) bool {
+	/*
+		1. run scriptSig + scriptPubKey, the hash of the redeem script should match
+		2. run the redeem script on the stack left by the scriptSig without the
+		redeem script itself, with the same transaction context, flags and
+		signature message
+		3. if the redeem script is witness program, the scriptSig should be
+		the push of the redeem script only, then the witness is checked
+	*/
+	redeemScript := s.redeemScript
+	s.redeemScript = nil
+	if !s.EvaluateWithWitness(z, nil) {
+		return false
+	}
+	if witness != nil && len(s.redeemStack) != 0 {
+		return false
+	}
+
+	s.bitcoinOpCode.stack = append([][]byte{}, s.redeemStack...)
+	s.bitcoinOpCode.altStack = make([][]byte, 0)
+	s.bitcoinOpCode.opCount = 0
+	s.bitcoinOpCode.cmds = append([][]byte{}, redeemScript.bitcoinOpCode.cmds...)
+	s.bitcoinOpCode.dataCmds = append([]bool{}, redeemScript.bitcoinOpCode.dataCmds...)
+	return s.EvaluateWithWitness(z, witness)
+}
+
+func (s *ScriptSig) isWitnessProgram() bool {
+	// segwit v0 program, OP_0 <20 bytes hash>(p2wpkh) or OP_0 <32 bytes hash>(p2wsh)
+	return s.IsP2wpkhScriptPubKey() || s.IsP2wshScriptPubKey()
+}
+
+func (s *ScriptSig) evaluateWitnessProgram(witness [][]byte) bool {
+	/*
+		p2wpkh: the witness is <signature> <public key>, they are pushed on to
+		the stack and run with OP_DUP OP_HASH160 <20 bytes hash> OP_EQUALVERIFY
+		OP_CHECKSIG
+
+		p2wsh: the last item of witness is the witness script, its sha256 need
+		to be the same as the 32 bytes hash, other items are pushed on to the
+		stack and then the witness script is run
+	*/
+	program := s.bitcoinOpCode.cmds[1]
+	s.bitcoinOpCode.cmds = make([][]byte, 0)
+	s.bitcoinOpCode.dataCmds = make([]bool, 0)
+
+	if len(program) == 20 {
+		if len(witness) != 2 {
+			return false
+		}
+		for _, item := range witness {
+			s.bitcoinOpCode.cmds = append(s.bitcoinOpCode.cmds, item)
+			s.bitcoinOpCode.dataCmds = append(s.bitcoinOpCode.dataCmds, true)
+		}
+		p2pkh := P2pkScript(program)
+		s.bitcoinOpCode.cmds = append(s.bitcoinOpCode.cmds, p2pkh.bitcoinOpCode.cmds...)
+		s.bitcoinOpCode.dataCmds = append(s.bitcoinOpCode.dataCmds, p2pkh.bitcoinOpCode.dataCmds...)
+		return true
 	}
 
-	if count != scriptLen {
-		panic("parsing script field fail")
+	if len(witness) == 0 {
+		return false
 	}
+	witnessScript := witness[len(witness)-1]
+	h256 := sha256.Sum256(witnessScript)
+	if !bytes.Equal(h256[:], program) {
+		return false
+	}
+	for _, item := range witness[0 : len(witness)-1] {
+		s.bitcoinOpCode.cmds = append(s.bitcoinOpCode.cmds, item)
+		s.bitcoinOpCode.dataCmds = append(s.bitcoinOpCode.dataCmds, true)
+	}
+	script, err := parseRawScript(witnessScript)
+	if err != nil {
+		return false
+	}
+	s.bitcoinOpCode.cmds = append(s.bitcoinOpCode.cmds, script.bitcoinOpCode.cmds...)
+	s.bitcoinOpCode.dataCmds = append(s.bitcoinOpCode.dataCmds, script.bitcoinOpCode.dataCmds...)
+	return true
+}
+
+func (s *ScriptSig) SetTransactionContext(version, lockTime, sequence *big.Int) {
+	/*
+		OP_CHECKLOCKTIMEVERIFY and OP_CHECKSEQUENCEVERIFY need to compare with
+		fields of the spending transaction and input
+	*/
+	s.bitcoinOpCode.SetTransactionContext(version, lockTime, sequence)
+}
 
-	return InitScriptSig(cmds)
+func (s *ScriptSig) SetFlags(flags SCRIPT_FLAG) {
+	s.bitcoinOpCode.SetFlags(flags)
+}
+
+func (s *ScriptSig) SetSigHash(sigHash func(hashType byte) ([]byte, bool)) {
+	/*
+		signature message depends on the hash type byte at the end of
+		signature, when it is set, it replaces the message given to Evaluate
+	*/
+	s.bitcoinOpCode.SetSigHash(sigHash)
+}
+
+func (s *ScriptSig) EvaluateTapscript(stack [][]byte, validationWeight int64,
+	tapSigHash func(hashType byte, codeSepPosition uint32) ([]byte, bool)) bool {
+	/*
+		run the tapscript of taproot script path spending, the witness items
+		before the script and control block are the initial stack
+	*/
+	if len(stack) > MAX_STACK_SIZE {
+		return false
+	}
+	for _, item := range stack {
+		if len(item) > MAX_SCRIPT_ELEMENT_SIZE {
+			return false
+		}
+	}
+	s.bitcoinOpCode.stack = append([][]byte{}, stack...)
+	s.bitcoinOpCode.SetTapscript(validationWeight, tapSigHash)
+	return s.EvaluateWithWitness(nil, nil)
+}
+
+func containsOpSuccess(raw []byte) bool {
+	/*
+		scan the script for OP_SUCCESSx before it is parsed, the script
+		succeeds even if the bytes after OP_SUCCESSx can't be parsed
+	*/
+	for i := 0; i < len(raw); {
+		op := int(raw[i])
+		i += 1
+		length := 0
+		switch {
+		case op >= SCRIPT_DATA_LENGTH_BEGIN && op <= SCRIPT_DATA_LENGTH_END:
+			length = op
+		case op == OP_PUSHDATA1 && i+1 <= len(raw):
+			length = int(raw[i])
+			i += 1
+		case op == OP_PUSHDATA2 && i+2 <= len(raw):
+			length = int(LittleEndianToBigInt(raw[i:i+2], LITTLE_ENDIAN_2_BYTES).Int64())
+			i += 2
+		case op == OP_PUSHDATA4 && i+4 <= len(raw):
+			length = int(LittleEndianToBigInt(raw[i:i+4], LITTLE_ENDIAN_4_BYTES).Int64())
+			i += 4
+		case op == OP_PUSHDATA1 || op == OP_PUSHDATA2 || op == OP_PUSHDATA4:
+			return false
+		case isOpSuccess(op):
+			return true
+		}
+		i += length
+	}
+	return false
 }
 
 func (s *ScriptSig) Evaluate(z []byte) bool {
+	return s.EvaluateWithWitness(z, nil)
+}
+
+func (s *ScriptSig) EvaluateWithWitness(z []byte, witness [][]byte) bool {
+	if s.parseErr != nil {
+		return false
+	}
+	if s.redeemScript != nil {
+		return s.evaluateP2sh(z, witness)
+	}
+	/*
+		segwit v0 is decided by the script before running anything, it should
+		be exactly the witness program, so the scriptSig is empty, then the
+		witness replaces the program
+	*/
+	witnessExecuted := witness != nil
+	if witnessExecuted && (!s.isWitnessProgram() || !s.evaluateWitnessProgram(witness)) {
+		return false
+	}
 	for s.bitcoinOpCode.HasCmd() {
-		cmd := s.bitcoinOpCode.RemoveCmd()
-		if len(cmd) == 1 {
+		cmd, isData := s.bitcoinOpCode.RemoveCmd()
+		/*
+			commands in the branch not taken are skipped, but op codes
+			controlling the branches still need to run to keep track of
+			the nesting
+		*/
+		executing := s.bitcoinOpCode.IsExecuting()
+		if !isData {
 			//this is an op code, run it
-			opRes := s.bitcoinOpCode.ExecuteOperaion(int(cmd[0]), z)
+			op := int(cmd[0])
+			if op > OP_16 && !s.bitcoinOpCode.countOps(1) {
+				return false
+			}
+			if isDisabledOpCode(op) {
+				return false
+			}
+			if !executing && !isConditionalOpCode(op) {
+				continue
+			}
+			opRes := s.bitcoinOpCode.ExecuteOperaion(op, z)
 			if !opRes {
 				return false
 			}
 		} else {
-			s.bitcoinOpCode.AppendDataElement(cmd)
+			if len(cmd) > MAX_SCRIPT_ELEMENT_SIZE {
+				return false
+			}
+			if executing {
+				s.bitcoinOpCode.AppendDataElement(cmd)
+			}
+		}
+
+		if len(s.bitcoinOpCode.stack)+len(s.bitcoinOpCode.altStack) > MAX_STACK_SIZE {
+			return false
 		}
 	}
 
+	// every OP_IF and OP_NOTIF need to be closed by OP_ENDIF
+	if s.bitcoinOpCode.HasUnbalancedConditional() {
+		return false
+	}
+
 	/*
 		After runing all the operations in the scripts and the stack is empty
 		then evaluation fail, otherwise we check the top element of the stack,
@@ -140,17 +533,22 @@ func (s *ScriptSig) Evaluate(z []byte) bool {
 	if len(s.bitcoinOpCode.stack) == 0 {
 		return false
 	}
-	if len(s.bitcoinOpCode.stack[0]) == 0 {
+
+	// witness script and tapscript should leave exactly one element on the stack
+	if (witnessExecuted || s.bitcoinOpCode.tapscript) && len(s.bitcoinOpCode.stack) != 1 {
 		return false
 	}
 
-	return true
+	return castToBool(s.bitcoinOpCode.stack[len(s.bitcoinOpCode.stack)-1])
 }
 
 func (s *ScriptSig) rawSerialize() []byte {
+	if s.raw != nil {
+		return append([]byte{}, s.raw...)
+	}
 	result := []byte{}
-	for _, cmd := range s.bitcoinOpCode.cmds {
-		if len(cmd) == 1 {
+	for i, cmd := range s.bitcoinOpCode.cmds {
+		if !s.bitcoinOpCode.dataCmds[i] {
 			//only one byte means its an instruction
 			result = append(result, cmd...)
 		} else {
@@ -163,7 +561,7 @@ func (s *ScriptSig) rawSerialize() []byte {
 				//push the command and then the next byte is the length of the data
 				result = append(result, OP_PUSHDATA1)
 				result = append(result, byte(length))
-			} else if length >= 0x100 && length <= 520 {
+			} else if length >= 0x100 && length < 0x10000 {
 				/*
 					this is OP_PUSHDATA2 command, we push the command
 					and then two byte for the data length but in little endian format
@@ -172,7 +570,10 @@ func (s *ScriptSig) rawSerialize() []byte {
 				lenBuf := BigIntToLittleEndian(big.NewInt(int64(length)), LITTLE_ENDIAN_2_BYTES)
 				result = append(result, lenBuf...)
 			} else {
-				panic("too long an cmd")
+				// OP_PUSHDATA4 with four bytes of length in little endian
+				result = append(result, OP_PUSHDATA4)
+				lenBuf := BigIntToLittleEndian(big.NewInt(int64(length)), LITTLE_ENDIAN_4_BYTES)
+				result = append(result, lenBuf...)
 			}
 
 			//append the chunk of data with given length
@@ -217,6 +618,15 @@ push value onto stack, transfer the value onto byte array and in little edian or
 func (s *ScriptSig) Add(script *ScriptSig) *ScriptSig {
 	cmds := make([][]byte, 0)
 	cmds = append(cmds, s.bitcoinOpCode.cmds...)
-	cmds = append(cmds, s.bitcoinOpCode.cmds...)
-	return InitScriptSig(cmds)
+	cmds = append(cmds, script.bitcoinOpCode.cmds...)
+	dataCmds := make([]bool, 0)
+	dataCmds = append(dataCmds, s.bitcoinOpCode.dataCmds...)
+	dataCmds = append(dataCmds, script.bitcoinOpCode.dataCmds...)
+	result := initScriptSigWithDataFlags(cmds, dataCmds)
+	// script can't be run if any part of it can't be parsed
+	result.parseErr = s.parseErr
+	if script.parseErr != nil {
+		result.parseErr = script.parseErr
+	}
+	return result
 }
@@ -3,11 +3,14 @@ package transaction
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
 	ecc "elliptic_curve"
 	"encoding/hex"
 	"fmt"
 	"math/big"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestEvaluate(t *testing.T) {
@@ -75,9 +78,170 @@ func TestScriptSigMain(t *testing.T) {
 
 	reader := bytes.NewReader(script)
 	bufReader := bufio.NewReader(reader)
-	scriptSig := NewScriptSig(bufReader)
+	scriptSig, err := NewScriptSig(bufReader)
+	assert.Nil(t, err)
 	fmt.Printf("serialize of the script object: %x\n", scriptSig.Serialize())
 
 	evalRes := scriptSig.Evaluate(z.Bytes())
 	fmt.Printf("result of script evaluation is %v\n", evalRes)
 }
+
+func TestEvaluateConditional(t *testing.T) {
+	testCases := []struct {
+		name   string
+		script string
+		flags  SCRIPT_FLAG
+		result bool
+	}{
+		// OP_1 OP_IF OP_2 OP_ELSE OP_3 OP_ENDIF OP_2 OP_EQUAL
+		{"if branch", "5163526753685287", 0, true},
+		// OP_0 OP_IF OP_2 OP_ELSE OP_3 OP_ENDIF OP_3 OP_EQUAL
+		{"else branch", "0063526753685387", 0, true},
+		// OP_0 OP_NOTIF OP_2 OP_ELSE OP_3 OP_ENDIF OP_2 OP_EQUAL
+		{"notif", "0064526753685287", 0, true},
+		// OP_1 OP_IF OP_0 OP_IF OP_RETURN OP_ELSE OP_1 OP_ENDIF OP_ENDIF
+		{"nested", "516300636a67516868", 0, true},
+		// OP_1 OP_IF OP_ELSE OP_ELSE OP_1 OP_ENDIF, the second OP_ELSE flips back
+		{"multiple else", "516367675168", 0, true},
+		// OP_1 OP_IF OP_1
+		{"missing endif", "516351", 0, false},
+		// OP_1 OP_ENDIF
+		{"missing if", "5168", 0, false},
+		// OP_1 OP_ELSE
+		{"else without if", "5167", 0, false},
+		// OP_IF with empty stack
+		{"if without condition", "635168", 0, false},
+		// OP_0 OP_IF OP_RETURN OP_ENDIF OP_1, OP_RETURN is not executed
+		{"return not executed", "00636a6851", 0, true},
+		// OP_0 OP_IF OP_CAT OP_ENDIF OP_1, disabled op code fails even not executed
+		{"disabled not executed", "00637e6851", 0, false},
+		// OP_0 OP_IF OP_VERIF OP_ENDIF OP_1
+		{"verif not executed", "0063656851", 0, false},
+		// <0100> OP_IF OP_1 OP_ENDIF
+		{"non minimal if", "020100635168", 0, true},
+		{"non minimal if with minimalif", "020100635168", SCRIPT_VERIFY_MINIMALIF, false},
+		// <01> OP_IF OP_1 OP_ENDIF
+		{"minimal if", "0101635168", SCRIPT_VERIFY_MINIMALIF, true},
+	}
+
+	for _, testCase := range testCases {
+		script := newScriptFromHex(t, testCase.script)
+		script.SetFlags(testCase.flags)
+		assert.Equal(t, testCase.result, script.Evaluate([]byte{}), testCase.name)
+	}
+}
+
+func TestEvaluateHTLC(t *testing.T) {
+	/*
+		OP_IF
+			OP_SHA256 <hash of preimage> OP_EQUALVERIFY <receiver pubkey>
+		OP_ELSE
+			<lock time> OP_CHECKLOCKTIMEVERIFY OP_DROP <sender pubkey>
+		OP_ENDIF
+		OP_CHECKSIG
+	*/
+	receiver := ecc.NewPrivateKey(big.NewInt(int64(20240801)))
+	sender := ecc.NewPrivateKey(big.NewInt(int64(20240802)))
+	_, receiverSec := receiver.GetPublicKey().Sec(true)
+	_, senderSec := sender.GetPublicKey().Sec(true)
+	preimage := []byte("htlc preimage")
+	preimageHash := sha256.Sum256(preimage)
+	lockTime := NewBitcoinOpCode().EncodeNum(700000)
+	htlc := func() *ScriptSig {
+		return InitScriptSig([][]byte{{OP_IF}, {OP_SHA256}, preimageHash[:],
+			{OP_EQUALVERIFY}, receiverSec, {OP_ELSE}, lockTime, {OP_CHECKLOCKTIMEVERIFY},
+			{OP_DROP}, senderSec, {OP_ENDIF}, {OP_CHECKSIG}})
+	}
+
+	z := ecc.Hash256("htlc spending transaction")
+	zNum := new(big.Int)
+	zNum.SetBytes(z)
+	receiverSig := append(receiver.Sign(zNum).Der(), SIGHASH_ALL)
+	senderSig := append(sender.Sign(zNum).Der(), SIGHASH_ALL)
+
+	// receiver claims with the preimage: <sig> <preimage> OP_1
+	claim := InitScriptSig([][]byte{receiverSig, preimage, {OP_1}}).Add(htlc())
+	assert.True(t, claim.Evaluate(z))
+
+	// wrong preimage
+	claim = InitScriptSig([][]byte{receiverSig, []byte("wrong preimage"), {OP_1}}).Add(htlc())
+	assert.False(t, claim.Evaluate(z))
+
+	// sender refunds after the timeout: <sig> OP_0
+	refund := InitScriptSig([][]byte{senderSig, {OP_0}}).Add(htlc())
+	refund.SetTransactionContext(big.NewInt(1), big.NewInt(700001), big.NewInt(0xfffffffe))
+	assert.True(t, refund.Evaluate(z))
+
+	// sender can't refund before the timeout
+	refund = InitScriptSig([][]byte{senderSig, {OP_0}}).Add(htlc())
+	refund.SetTransactionContext(big.NewInt(1), big.NewInt(699999), big.NewInt(0xfffffffe))
+	assert.False(t, refund.Evaluate(z))
+
+	// receiver can't take the refund branch
+	refund = InitScriptSig([][]byte{receiverSig, {OP_0}}).Add(htlc())
+	refund.SetTransactionContext(big.NewInt(1), big.NewInt(700001), big.NewInt(0xfffffffe))
+	assert.False(t, refund.Evaluate(z))
+}
+
+func TestEvaluateP2sh(t *testing.T) {
+	z := ecc.Hash256("p2sh spending transaction")
+	zNum := new(big.Int)
+	zNum.SetBytes(z)
+
+	pubKeys := [][]byte{}
+	sigs := [][]byte{}
+	for i := 1; i <= 3; i++ {
+		key := ecc.NewPrivateKey(big.NewInt(int64(2000 + i)))
+		_, sec := key.GetPublicKey().Sec(true)
+		pubKeys = append(pubKeys, sec)
+		sigs = append(sigs, append(key.Sign(zNum).Der(), SIGHASH_ALL))
+	}
+	redeemScript := P2msScript(2, pubKeys).rawSerialize()
+	scriptPubKey := P2shScript(ecc.Hash160(redeemScript))
+	assert.True(t, scriptPubKey.IsP2shScriptPubKey())
+	assert.False(t, scriptPubKey.IsP2pkhScriptPubKey())
+
+	// p2sh is decided by the scriptPubKey, the redeem script runs after it
+	evaluateP2sh := func(scriptSig *ScriptSig) bool {
+		redeem, stack, ok := scriptSig.p2shRedeemScript()
+		if !ok {
+			return false
+		}
+		script := scriptSig.Add(scriptPubKey)
+		script.SetRedeemScript(redeem, stack)
+		return script.Evaluate(z)
+	}
+
+	// OP_0 <sig1> <sig2> <redeem script>
+	assert.True(t, evaluateP2sh(InitScriptSig([][]byte{{OP_0}, sigs[0], sigs[1], redeemScript})))
+
+	// redeem script is checked after its hash matches
+	scriptSig := InitScriptSig([][]byte{{OP_0}, sigs[0], {OP_0}, redeemScript})
+	assert.True(t, scriptSig.Add(scriptPubKey).Evaluate(z))
+	assert.False(t, evaluateP2sh(scriptSig))
+
+	// redeem script doesn't match the hash
+	otherRedeemScript := P2msScript(1, pubKeys).rawSerialize()
+	assert.False(t, evaluateP2sh(InitScriptSig([][]byte{{OP_0}, sigs[0], otherRedeemScript})))
+
+	// scriptSig of p2sh can only push data
+	assert.False(t, evaluateP2sh(InitScriptSig([][]byte{{OP_0}, sigs[0], sigs[1], {OP_NOP}, redeemScript})))
+}
+
+func TestEvaluateHashLock(t *testing.T) {
+	/*
+		<x> OP_HASH160 <hash160 of x> OP_EQUAL looks like the end of p2sh, but
+		it is not p2sh and x is only data, not run as a script
+	*/
+	z := ecc.Hash256("hash lock")
+	preimage := []byte{OP_RETURN, OP_RETURN}
+	hashLock := InitScriptSig([][]byte{{OP_HASH160}, ecc.Hash160(preimage), {OP_EQUAL}})
+	assert.True(t, InitScriptSig([][]byte{preimage}).Add(hashLock).Evaluate(z))
+	assert.False(t, InitScriptSig([][]byte{{OP_RETURN}}).Add(hashLock).Evaluate(z))
+
+	// the same hash lock as the witness script of p2wsh
+	witnessScript := hashLock.rawSerialize()
+	h256 := sha256.Sum256(witnessScript)
+	assert.True(t, P2wshScript(h256[:]).EvaluateWithWitness(z, [][]byte{preimage, witnessScript}))
+	assert.False(t, P2wshScript(h256[:]).EvaluateWithWitness(z, [][]byte{{OP_RETURN}, witnessScript}))
+}
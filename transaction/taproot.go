@@ -0,0 +1,370 @@
+package transaction
+
+import (
+	"bytes"
+	"crypto/sha256"
+	ecc "elliptic_curve"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+/*
+Taproot(BIP341) output is OP_1 <32 bytes output key Q>, Q = P + t*G where
+P is the internal key and t = hash_TapTweak(P || merkle root of scripts).
+It can be spent in two ways:
+1. key path: witness is only a schnorr signature for Q
+2. script path: witness is [stack items..., script, control block], the
+control block has P and the merkle path from the script to the root, we
+check the script is committed in Q, then run the script as tapscript(BIP342)
+*/
+
+const (
+	TAPROOT_LEAF_TAPSCRIPT          = 0xc0
+	TAPROOT_LEAF_MASK               = 0xfe
+	TAPROOT_CONTROL_BASE_SIZE       = 33
+	TAPROOT_CONTROL_NODE_SIZE       = 32
+	TAPROOT_CONTROL_MAX_NODE_COUNT  = 128
+	TAPROOT_ANNEX_TAG               = 0x50
+	TAPROOT_NO_CODESEPARATOR        = 0xffffffff
+	TAG_TAP_LEAF                    = "TapLeaf"
+	TAG_TAP_BRANCH                  = "TapBranch"
+	TAG_TAP_TWEAK                   = "TapTweak"
+	TAG_TAP_SIGHASH                 = "TapSighash"
+	TAPROOT_SIGHASH_EPOCH           = 0x00
+	TAPROOT_KEY_VERSION             = 0x00
+	TAPROOT_SCRIPT_PATH_EXT_FLAG    = 1
+	TAPROOT_ANNEX_PRESENT_SPEND_BIT = 1
+)
+
+var ErrInvalidControlBlock = errors.New("invalid taproot control block")
+
+func TapLeafHash(script []byte, leafVersion byte) []byte {
+	// hash_TapLeaf(leaf version || script with length prefix)
+	leaf := append([]byte{leafVersion}, EncodeVarint(big.NewInt(int64(len(script))))...)
+	leaf = append(leaf, script...)
+	return ecc.TaggedHash(TAG_TAP_LEAF, leaf)
+}
+
+func TapBranchHash(a, b []byte) []byte {
+	// children are sorted, then the path doesn't need to tell left or right
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+	return ecc.TaggedHash(TAG_TAP_BRANCH, a, b)
+}
+
+func TapTweakHash(internalKey []byte, merkleRoot []byte) []byte {
+	// merkle root is nil for output without scripts
+	return ecc.TaggedHash(TAG_TAP_TWEAK, internalKey, merkleRoot)
+}
+
+func TaprootOutputKey(internalKey []byte, merkleRoot []byte) ([]byte, bool, error) {
+	/*
+		returns the x only output key and whether its y is odd, the parity
+		goes into the control block for script path spending
+	*/
+	P, err := ecc.ParseXOnly(internalKey)
+	if err != nil {
+		return nil, false, err
+	}
+	Q, err := ecc.TweakPublicKey(P, TapTweakHash(internalKey, merkleRoot))
+	if err != nil {
+		return nil, false, err
+	}
+	return Q.XOnly(), !Q.HasEvenY(), nil
+}
+
+type ControlBlock struct {
+	LeafVersion  byte
+	OutputKeyOdd bool
+	InternalKey  []byte
+	Path         [][]byte
+}
+
+func ParseControlBlock(controlBlock []byte) (*ControlBlock, error) {
+	/*
+		1 byte of leaf version and parity of output key, 32 bytes internal
+		key, then at most 128 hashes of the merkle path
+	*/
+	size := len(controlBlock)
+	if size < TAPROOT_CONTROL_BASE_SIZE ||
+		(size-TAPROOT_CONTROL_BASE_SIZE)%TAPROOT_CONTROL_NODE_SIZE != 0 ||
+		(size-TAPROOT_CONTROL_BASE_SIZE)/TAPROOT_CONTROL_NODE_SIZE > TAPROOT_CONTROL_MAX_NODE_COUNT {
+		return nil, fmt.Errorf("%w: size %d", ErrInvalidControlBlock, size)
+	}
+	path := make([][]byte, 0)
+	for i := TAPROOT_CONTROL_BASE_SIZE; i < size; i += TAPROOT_CONTROL_NODE_SIZE {
+		path = append(path, controlBlock[i:i+TAPROOT_CONTROL_NODE_SIZE])
+	}
+	return &ControlBlock{
+		LeafVersion:  controlBlock[0] & TAPROOT_LEAF_MASK,
+		OutputKeyOdd: controlBlock[0]&1 == 1,
+		InternalKey:  controlBlock[1:TAPROOT_CONTROL_BASE_SIZE],
+		Path:         path,
+	}, nil
+}
+
+func (c *ControlBlock) Serialize() []byte {
+	first := c.LeafVersion
+	if c.OutputKeyOdd {
+		first |= 1
+	}
+	result := append([]byte{first}, c.InternalKey...)
+	for _, node := range c.Path {
+		result = append(result, node...)
+	}
+	return result
+}
+
+func (c *ControlBlock) MerkleRoot(leafHash []byte) []byte {
+	// hash the leaf with each node on the path up to the root
+	root := leafHash
+	for _, node := range c.Path {
+		root = TapBranchHash(root, node)
+	}
+	return root
+}
+
+func (c *ControlBlock) Commits(outputKey []byte, script []byte) bool {
+	// the script and the internal key give the output key with the same parity
+	root := c.MerkleRoot(TapLeafHash(script, c.LeafVersion))
+	key, odd, err := TaprootOutputKey(c.InternalKey, root)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(key, outputKey) && odd == c.OutputKeyOdd
+}
+
+func sha256Bytes(data []byte) []byte {
+	h := sha256.Sum256(data)
+	return h[:]
+}
+
+func isValidTaprootHashType(hashType byte) bool {
+	return hashType == SIGHASH_DEFAULT || hashType == SIGHASH_ALL ||
+		hashType == SIGHASH_NONE || hashType == SIGHASH_SINGLE ||
+		hashType == SIGHASH_ALL|SIGHASH_ANYONECANPAY ||
+		hashType == SIGHASH_NONE|SIGHASH_ANYONECANPAY ||
+		hashType == SIGHASH_SINGLE|SIGHASH_ANYONECANPAY
+}
+
+func (t *Transaction) SignHashTaproot(inputIdx int, hashType byte, annex []byte, leafHash []byte, codeSepPosition uint32) ([]byte, error) {
+	/*
+		BIP341 signature message, hash_TapSighash(epoch || SigMsg):
+		hash type | version | lock time |
+		sha_prevouts | sha_amounts | sha_scriptpubkeys | sha_sequences (not ANYONECANPAY) |
+		sha_outputs (not NONE or SINGLE) | spend type |
+		prevout, amount, scriptPubKey and sequence of this input (ANYONECANPAY) or input index |
+		sha_annex (annex present) | sha_single_output (SINGLE) |
+		tapleaf hash | key version | OP_CODESEPARATOR position (script path)
+
+		different from BIP143, the hashes are single sha256, and the amounts and
+		scriptPubKeys of all inputs are signed, a signer knows exactly what it
+		spends without checking every previous transaction
+	*/
+	if !isValidTaprootHashType(hashType) {
+		return nil, fmt.Errorf("invalid taproot hash type %x", hashType)
+	}
+	if err := t.checkInputIndex(inputIdx); err != nil {
+		return nil, err
+	}
+	baseType := hashType & 3
+	anyoneCanPay := hashType&SIGHASH_ANYONECANPAY != 0
+	if baseType == SIGHASH_SINGLE && inputIdx >= len(t.txOutputs) {
+		return nil, fmt.Errorf("no output for SIGHASH_SINGLE input %d", inputIdx)
+	}
+
+	msg := []byte{TAPROOT_SIGHASH_EPOCH, hashType}
+	msg = append(msg, BigIntToLittleEndian(t.version, LITTLE_ENDIAN_4_BYTES)...)
+	msg = append(msg, BigIntToLittleEndian(t.lockTime, LITTLE_ENDIAN_4_BYTES)...)
+
+	if !anyoneCanPay {
+		prevouts := make([]byte, 0)
+		amounts := make([]byte, 0)
+		scriptPubKeys := make([]byte, 0)
+		sequences := make([]byte, 0)
+		for _, txInput := range t.txInputs {
+			prevOutput, err := txInput.previousOutput(t.testnet)
+			if err != nil {
+				return nil, err
+			}
+			prevouts = append(prevouts, reverseByteSlice(txInput.previousTransactionID)...)
+			prevouts = append(prevouts, BigIntToLittleEndian(txInput.previousTransactionIndex, LITTLE_ENDIAN_4_BYTES)...)
+			amounts = append(amounts, BigIntToLittleEndian(prevOutput.amount, LITTLE_ENDIAN_8_BYTES)...)
+			scriptPubKeys = append(scriptPubKeys, prevOutput.scriptPubKey.Serialize()...)
+			sequences = append(sequences, BigIntToLittleEndian(txInput.sequence, LITTLE_ENDIAN_4_BYTES)...)
+		}
+		msg = append(msg, sha256Bytes(prevouts)...)
+		msg = append(msg, sha256Bytes(amounts)...)
+		msg = append(msg, sha256Bytes(scriptPubKeys)...)
+		msg = append(msg, sha256Bytes(sequences)...)
+	}
+	if baseType != SIGHASH_NONE && baseType != SIGHASH_SINGLE {
+		outputs := make([]byte, 0)
+		for _, txOutput := range t.txOutputs {
+			outputs = append(outputs, txOutput.Serialize()...)
+		}
+		msg = append(msg, sha256Bytes(outputs)...)
+	}
+
+	spendType := byte(0)
+	if leafHash != nil {
+		spendType |= TAPROOT_SCRIPT_PATH_EXT_FLAG << 1
+	}
+	if annex != nil {
+		spendType |= TAPROOT_ANNEX_PRESENT_SPEND_BIT
+	}
+	msg = append(msg, spendType)
+
+	txInput := t.txInputs[inputIdx]
+	if anyoneCanPay {
+		prevOutput, err := txInput.previousOutput(t.testnet)
+		if err != nil {
+			return nil, err
+		}
+		msg = append(msg, reverseByteSlice(txInput.previousTransactionID)...)
+		msg = append(msg, BigIntToLittleEndian(txInput.previousTransactionIndex, LITTLE_ENDIAN_4_BYTES)...)
+		msg = append(msg, BigIntToLittleEndian(prevOutput.amount, LITTLE_ENDIAN_8_BYTES)...)
+		msg = append(msg, prevOutput.scriptPubKey.Serialize()...)
+		msg = append(msg, BigIntToLittleEndian(txInput.sequence, LITTLE_ENDIAN_4_BYTES)...)
+	} else {
+		msg = append(msg, BigIntToLittleEndian(big.NewInt(int64(inputIdx)), LITTLE_ENDIAN_4_BYTES)...)
+	}
+	if annex != nil {
+		annexWithLength := append(EncodeVarint(big.NewInt(int64(len(annex)))), annex...)
+		msg = append(msg, sha256Bytes(annexWithLength)...)
+	}
+	if baseType == SIGHASH_SINGLE {
+		msg = append(msg, sha256Bytes(t.txOutputs[inputIdx].Serialize())...)
+	}
+	if leafHash != nil {
+		msg = append(msg, leafHash...)
+		msg = append(msg, TAPROOT_KEY_VERSION)
+		msg = append(msg, BigIntToLittleEndian(big.NewInt(int64(codeSepPosition)), LITTLE_ENDIAN_4_BYTES)...)
+	}
+
+	return ecc.TaggedHash(TAG_TAP_SIGHASH, msg), nil
+}
+
+func splitAnnex(witness [][]byte) ([][]byte, []byte) {
+	// the last item starting with 0x50 is annex if there are at least two items
+	if len(witness) >= 2 {
+		last := witness[len(witness)-1]
+		if len(last) > 0 && last[0] == TAPROOT_ANNEX_TAG {
+			return witness[0 : len(witness)-1], last
+		}
+	}
+	return witness, nil
+}
+
+func (t *Transaction) verifyTaproot(inputIdx int, outputKey []byte) bool {
+	txInput := t.txInputs[inputIdx]
+	// native witness program, scriptSig must be empty
+	if len(txInput.scriptSig.rawSerialize()) != 0 {
+		return false
+	}
+	witness, annex := splitAnnex(txInput.witness)
+	if len(witness) == 0 {
+		return false
+	}
+
+	if len(witness) == 1 {
+		// key path, 64 bytes signature with SIGHASH_DEFAULT or 65 bytes with hash type
+		sig := witness[0]
+		hashType := byte(SIGHASH_DEFAULT)
+		if len(sig) == 65 {
+			hashType = sig[64]
+			if hashType == SIGHASH_DEFAULT {
+				return false
+			}
+			sig = sig[0:64]
+		} else if len(sig) != 64 {
+			return false
+		}
+		msg, err := t.SignHashTaproot(inputIdx, hashType, annex, nil, TAPROOT_NO_CODESEPARATOR)
+		if err != nil {
+			return false
+		}
+		return ecc.VerifySchnorr(outputKey, msg, sig)
+	}
+
+	// script path
+	script := witness[len(witness)-2]
+	controlBlock, err := ParseControlBlock(witness[len(witness)-1])
+	if err != nil {
+		return false
+	}
+	if !controlBlock.Commits(outputKey, script) {
+		return false
+	}
+	if controlBlock.LeafVersion != TAPROOT_LEAF_TAPSCRIPT {
+		// unknown leaf version is left for future upgrade
+		return true
+	}
+	if containsOpSuccess(script) {
+		return true
+	}
+	tapscript, err := parseRawScript(script)
+	if err != nil {
+		return false
+	}
+
+	leafHash := TapLeafHash(script, controlBlock.LeafVersion)
+	tapSigHash := func(hashType byte, codeSepPosition uint32) ([]byte, bool) {
+		msg, err := t.SignHashTaproot(inputIdx, hashType, annex, leafHash, codeSepPosition)
+		return msg, err == nil
+	}
+	validationWeight := int64(len(txInput.SerializeWitness()) + VALIDATION_WEIGHT_OFFSET)
+	tapscript.SetTransactionContext(t.version, t.lockTime, txInput.sequence)
+	return tapscript.EvaluateTapscript(witness[0:len(witness)-2], validationWeight, tapSigHash)
+}
+
+func (t *Transaction) SignTaprootKeyPath(inputIdx int, privateKey *ecc.PrivateKey, merkleRoot []byte, hashType byte) bool {
+	/*
+		sign with the private key tweaked by the merkle root of scripts, which
+		is nil for output without scripts, the signature is the only witness item
+	*/
+	tweaked, err := privateKey.TweakAdd(TapTweakHash(privateKey.GetPublicKey().XOnly(), merkleRoot))
+	if err != nil {
+		return false
+	}
+	msg, err := t.SignHashTaproot(inputIdx, hashType, nil, nil, TAPROOT_NO_CODESEPARATOR)
+	if err != nil {
+		return false
+	}
+	sig, err := tweaked.SignSchnorr(msg, sha256Bytes(msg))
+	if err != nil {
+		return false
+	}
+
+	txInput := t.txInputs[inputIdx]
+	txInput.SetScript(InitScriptSig([][]byte{}))
+	txInput.SetWitness([][]byte{appendTaprootHashType(sig.Serialize(), hashType)})
+	return t.VerifyInput(inputIdx)
+}
+
+func (t *Transaction) SignTaprootScriptPath(inputIdx int, privateKey *ecc.PrivateKey, script []byte, leafVersion byte, hashType byte) ([]byte, error) {
+	/*
+		signature for a key in the tapscript, the caller puts it with other
+		stack items, the script and the control block into the witness
+	*/
+	leafHash := TapLeafHash(script, leafVersion)
+	msg, err := t.SignHashTaproot(inputIdx, hashType, nil, leafHash, TAPROOT_NO_CODESEPARATOR)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := privateKey.SignSchnorr(msg, sha256Bytes(msg))
+	if err != nil {
+		return nil, err
+	}
+	return appendTaprootHashType(sig.Serialize(), hashType), nil
+}
+
+func appendTaprootHashType(sig []byte, hashType byte) []byte {
+	// SIGHASH_DEFAULT is not appended, the signature is 64 bytes
+	if hashType == SIGHASH_DEFAULT {
+		return sig
+	}
+	return append(sig, hashType)
+}
@@ -0,0 +1,174 @@
+package transaction
+
+import (
+	ecc "elliptic_curve"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaprootOutputKey(t *testing.T) {
+	// BIP341 wallet test vector, key path only output
+	internalKey, _ := hex.DecodeString("d6889cb081036e0faefa3a35157ad71086b123b2b144b649798b494c300a961d")
+	outputKey, _, err := TaprootOutputKey(internalKey, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "53a1f6e454df1aa2776a2814a721372d6258050de330b3c6d10ee8f4e0dda343", fmt.Sprintf("%x", outputKey))
+
+	// with one leaf script
+	internalKey, _ = hex.DecodeString("187791b6f712a8ea41c8ecdd0ee77fab3e85263b37e1ec18a3651926b3a6cf27")
+	script, _ := hex.DecodeString("20d85a959b0290bf19bb89ed43c916be835475d013da4b362117393e25a48229b8ac")
+	leafHash := TapLeafHash(script, TAPROOT_LEAF_TAPSCRIPT)
+	assert.Equal(t, "5b75adecf53548f3ec6ad7d78383bf84cc57b55a3127c72b9a2481752dd88b21", fmt.Sprintf("%x", leafHash))
+	outputKey, _, err = TaprootOutputKey(internalKey, leafHash)
+	assert.Nil(t, err)
+	assert.Equal(t, "147c9c57132f6e7ecddba9800bb0c4449251c92a1e60371ee77557b6620f3ea3", fmt.Sprintf("%x", outputKey))
+}
+
+func taprootSpendingTx(outputKey []byte) *Transaction {
+	prevTx := make([]byte, 32)
+	prevTx[0] = 0x01
+	txInput := InitTransactionInput(prevTx, big.NewInt(int64(0)))
+	txInput.SetScript(InitScriptSig([][]byte{}))
+	txInput.SetPreviousOutput(InitTransactionOutPut(big.NewInt(int64(10000)), P2trScript(outputKey)))
+	h160 := ecc.Hash160([]byte("destination"))
+	txOutput := InitTransactionOutPut(big.NewInt(int64(9000)), P2wpkhScript(h160))
+	return InitTransaction(big.NewInt(int64(2)), []*TransactionInput{txInput},
+		[]*TransactionOutput{txOutput}, big.NewInt(int64(0)), true)
+}
+
+func TestTaprootKeyPath(t *testing.T) {
+	privateKey := ecc.NewPrivateKey(big.NewInt(int64(8675309)))
+	outputKey, _, err := TaprootOutputKey(privateKey.GetPublicKey().XOnly(), nil)
+	assert.Nil(t, err)
+
+	for _, hashType := range []byte{SIGHASH_DEFAULT, SIGHASH_ALL, SIGHASH_SINGLE | SIGHASH_ANYONECANPAY} {
+		transaction := taprootSpendingTx(outputKey)
+		assert.True(t, transaction.SignTaprootKeyPath(0, privateKey, nil, hashType))
+		assert.True(t, transaction.IsSegwit())
+		assert.True(t, transaction.Verify())
+		fmt.Printf("taproot key path witness: %x\n", transaction.txInputs[0].witness[0])
+
+		// annex is committed by the signature
+		witness := transaction.txInputs[0].witness
+		transaction.txInputs[0].SetWitness([][]byte{witness[0], {TAPROOT_ANNEX_TAG, 0x01}})
+		assert.False(t, transaction.VerifyInput(0))
+	}
+
+	// signed by the untweaked key
+	transaction := taprootSpendingTx(outputKey)
+	msg, err := transaction.SignHashTaproot(0, SIGHASH_DEFAULT, nil, nil, TAPROOT_NO_CODESEPARATOR)
+	assert.Nil(t, err)
+	sig, err := privateKey.SignSchnorr(msg, make([]byte, 32))
+	assert.Nil(t, err)
+	transaction.txInputs[0].SetWitness([][]byte{sig.Serialize()})
+	assert.False(t, transaction.VerifyInput(0))
+
+	// 65 bytes signature can't have SIGHASH_DEFAULT, and invalid hash type
+	assert.True(t, transaction.SignTaprootKeyPath(0, privateKey, nil, SIGHASH_DEFAULT))
+	transaction.txInputs[0].SetWitness([][]byte{append(transaction.txInputs[0].witness[0], SIGHASH_DEFAULT)})
+	assert.False(t, transaction.VerifyInput(0))
+	assert.False(t, transaction.SignTaprootKeyPath(0, privateKey, nil, 0x04))
+}
+
+func TestTaprootScriptPath(t *testing.T) {
+	internalKey := ecc.NewPrivateKey(big.NewInt(int64(2024)))
+	keyA := ecc.NewPrivateKey(big.NewInt(int64(1111)))
+	keyB := ecc.NewPrivateKey(big.NewInt(int64(2222)))
+
+	/*
+		three leaves:
+		A: <A> OP_CHECKSIG
+		B: <A> OP_CHECKSIG <B> OP_CHECKSIGADD OP_2 OP_NUMEQUAL
+		C: OP_SUCCESS80
+		root = branch(branch(A, B), C)
+	*/
+	scriptA := InitScriptSig([][]byte{keyA.GetPublicKey().XOnly(), {OP_CHECKSIG}}).rawSerialize()
+	scriptB := InitScriptSig([][]byte{keyA.GetPublicKey().XOnly(), {OP_CHECKSIG},
+		keyB.GetPublicKey().XOnly(), {OP_CHECKSIGADD}, {OP_2}, {OP_NUMEQUAL}}).rawSerialize()
+	scriptC := []byte{OP_RESERVED}
+	leafA := TapLeafHash(scriptA, TAPROOT_LEAF_TAPSCRIPT)
+	leafB := TapLeafHash(scriptB, TAPROOT_LEAF_TAPSCRIPT)
+	leafC := TapLeafHash(scriptC, TAPROOT_LEAF_TAPSCRIPT)
+	branchAB := TapBranchHash(leafA, leafB)
+	root := TapBranchHash(branchAB, leafC)
+
+	outputKey, odd, err := TaprootOutputKey(internalKey.GetPublicKey().XOnly(), root)
+	assert.Nil(t, err)
+	controlBlock := func(path ...[]byte) []byte {
+		return (&ControlBlock{
+			LeafVersion:  TAPROOT_LEAF_TAPSCRIPT,
+			OutputKeyOdd: odd,
+			InternalKey:  internalKey.GetPublicKey().XOnly(),
+			Path:         path,
+		}).Serialize()
+	}
+
+	// leaf A with one signature
+	transaction := taprootSpendingTx(outputKey)
+	sigA, err := transaction.SignTaprootScriptPath(0, keyA, scriptA, TAPROOT_LEAF_TAPSCRIPT, SIGHASH_DEFAULT)
+	assert.Nil(t, err)
+	transaction.txInputs[0].SetWitness([][]byte{sigA, scriptA, controlBlock(leafB, leafC)})
+	assert.True(t, transaction.Verify())
+
+	// signature for other leaf
+	sigOther, err := transaction.SignTaprootScriptPath(0, keyA, scriptB, TAPROOT_LEAF_TAPSCRIPT, SIGHASH_DEFAULT)
+	assert.Nil(t, err)
+	transaction.txInputs[0].SetWitness([][]byte{sigOther, scriptA, controlBlock(leafB, leafC)})
+	assert.False(t, transaction.VerifyInput(0))
+
+	// wrong path and wrong parity
+	transaction.txInputs[0].SetWitness([][]byte{sigA, scriptA, controlBlock(leafC, leafB)})
+	assert.False(t, transaction.VerifyInput(0))
+	badControl := controlBlock(leafB, leafC)
+	badControl[0] ^= 1
+	transaction.txInputs[0].SetWitness([][]byte{sigA, scriptA, badControl})
+	assert.False(t, transaction.VerifyInput(0))
+	transaction.txInputs[0].SetWitness([][]byte{sigA, scriptA, controlBlock(leafB, leafC)[0:40]})
+	assert.False(t, transaction.VerifyInput(0))
+
+	// leaf B with 2 of 2 by OP_CHECKSIGADD, stack is the reverse order of keys
+	sigA, err = transaction.SignTaprootScriptPath(0, keyA, scriptB, TAPROOT_LEAF_TAPSCRIPT, SIGHASH_ALL)
+	assert.Nil(t, err)
+	sigB, err := transaction.SignTaprootScriptPath(0, keyB, scriptB, TAPROOT_LEAF_TAPSCRIPT, SIGHASH_DEFAULT)
+	assert.Nil(t, err)
+	transaction.txInputs[0].SetWitness([][]byte{sigB, sigA, scriptB, controlBlock(leafA, leafC)})
+	assert.True(t, transaction.VerifyInput(0))
+
+	// empty signature counts as not signed, only 1 of 2
+	transaction.txInputs[0].SetWitness([][]byte{{}, sigA, scriptB, controlBlock(leafA, leafC)})
+	assert.False(t, transaction.VerifyInput(0))
+
+	// invalid signature is not allowed to be non empty
+	transaction.txInputs[0].SetWitness([][]byte{sigA, sigA, scriptB, controlBlock(leafA, leafC)})
+	assert.False(t, transaction.VerifyInput(0))
+
+	// OP_SUCCESS leaf is always valid, with or without annex
+	transaction.txInputs[0].SetWitness([][]byte{scriptC, controlBlock(branchAB)})
+	assert.True(t, transaction.VerifyInput(0))
+	transaction.txInputs[0].SetWitness([][]byte{scriptC, controlBlock(branchAB), {TAPROOT_ANNEX_TAG}})
+	assert.True(t, transaction.VerifyInput(0))
+
+	// the annex is in the signature message of script path
+	transaction.txInputs[0].SetWitness([][]byte{sigB, sigA, scriptB, controlBlock(leafA, leafC), {TAPROOT_ANNEX_TAG}})
+	assert.False(t, transaction.VerifyInput(0))
+}
+
+func TestParseControlBlock(t *testing.T) {
+	internalKey := ecc.NewPrivateKey(big.NewInt(int64(2024))).GetPublicKey().XOnly()
+	node := ecc.TaggedHash(TAG_TAP_LEAF, []byte("node"))
+	raw := append(append([]byte{TAPROOT_LEAF_TAPSCRIPT | 1}, internalKey...), node...)
+	controlBlock, err := ParseControlBlock(raw)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(TAPROOT_LEAF_TAPSCRIPT), controlBlock.LeafVersion)
+	assert.True(t, controlBlock.OutputKeyOdd)
+	assert.Equal(t, 1, len(controlBlock.Path))
+	assert.Equal(t, raw, controlBlock.Serialize())
+
+	_, err = ParseControlBlock(raw[0:32])
+	assert.ErrorIs(t, err, ErrInvalidControlBlock)
+	_, err = ParseControlBlock(raw[0:34])
+	assert.ErrorIs(t, err, ErrInvalidControlBlock)
+}
@@ -9,15 +9,34 @@ import (
 )
 
 const (
-	SIGHASH_ALL = 1
+	// taproot only, sign everything like SIGHASH_ALL with 64 bytes signature
+	SIGHASH_DEFAULT      = 0
+	SIGHASH_ALL          = 1
+	SIGHASH_NONE         = 2
+	SIGHASH_SINGLE       = 3
+	SIGHASH_ANYONECANPAY = 0x80
+	// lower bits of hash type without the ANYONECANPAY modifier
+	SIGHASH_BASE_MASK = 0x1f
 )
 
+const (
+	// segwit transaction has marker 0x00 and flag 0x01 following the version
+	SEGWIT_MARKER = 0x00
+	SEGWIT_FLAG   = 0x01
+)
+
+// OP_RETURN, push 36 bytes, then the 4 bytes tag of the witness commitment
+var WITNESS_COMMITMENT_HEADER = []byte{OP_RETURN, 0x24, 0xaa, 0x21, 0xa9, 0xed}
+
+const WITNESS_COMMITMENT_SCRIPT_LENGTH = 38
+
 type Transaction struct {
 	version   *big.Int
 	txInputs  []*TransactionInput
 	txOutputs []*TransactionOutput
 	lockTime  *big.Int
 	testnet   bool
+	segwit    bool
 }
 
 func InitTransaction(version *big.Int, txInputs []*TransactionInput, txOutputs []*TransactionOutput, lockTime *big.Int, testnet bool) *Transaction {
@@ -30,6 +49,13 @@ func InitTransaction(version *big.Int, txInputs []*TransactionInput, txOutputs [
 	}
 }
 
+func (t *Transaction) SetFetcher(fetcher TransactionFetcher) {
+	// all inputs get their previous transactions from the given fetcher
+	for _, txInput := range t.txInputs {
+		txInput.SetFetcher(fetcher)
+	}
+}
+
 func (t *Transaction) String() string {
 	txIns := ""
 	for i := 0; i < len(t.txInputs); i++ {
@@ -49,17 +75,43 @@ func (t *Transaction) String() string {
 	)
 }
 
-func (t *Transaction) SerializeWithSign(inputIdx int) []byte {
+func (t *Transaction) SerializeWithSign(inputIdx int, redeemScript *ScriptSig, hashType byte) ([]byte, error) {
 	/*
 		constract signature message for the giving input indicate by input index,
 		we need to change the given scriptsig with the scriptpubkey from the
 		output of previous transaction, and the do hash256 on the binary transaction
 		data
+
+		scriptSig of other inputs are set to empty, if the input is spending
+		p2sh output, the redeem script is used instead of the scriptpubkey
+
+		the hash type decides which part of the transaction is signed:
+		SIGHASH_ALL: all inputs and outputs
+		SIGHASH_NONE: no outputs, sequence of other inputs are set to 0 then
+		they can be updated by others
+		SIGHASH_SINGLE: only the output with the same index as the input, outputs
+		before it are set to amount -1 with empty script, sequence of other inputs
+		are set to 0
+		SIGHASH_ANYONECANPAY: combined with above, only the current input is signed,
+		others can add more inputs
 	*/
+	if err := t.checkInputIndex(inputIdx); err != nil {
+		return nil, err
+	}
+	baseType := hashType & SIGHASH_BASE_MASK
+	anyoneCanPay := hashType&SIGHASH_ANYONECANPAY != 0
+	if baseType == SIGHASH_SINGLE && inputIdx >= len(t.txOutputs) {
+		// no output to sign, there is no message, SignHash uses 1 as the hash
+		return nil, fmt.Errorf("%w: input %d with %d outputs", ErrSighashSingleNoOutput, inputIdx, len(t.txOutputs))
+	}
+
 	signBinary := make([]byte, 0)
 	signBinary = append(signBinary, BigIntToLittleEndian(t.version, LITTLE_ENDIAN_4_BYTES)...)
 
 	inputCount := big.NewInt(int64(len(t.txInputs)))
+	if anyoneCanPay {
+		inputCount = big.NewInt(int64(1))
+	}
 	signBinary = append(signBinary, EncodeVarint(inputCount)...)
 
 	/*
@@ -68,44 +120,353 @@ func (t *Transaction) SerializeWithSign(inputIdx int) []byte {
 	*/
 	for i := 0; i < len(t.txInputs); i++ {
 		if i == inputIdx {
-			t.txInputs[i].ReplaceWithScriptPubKey(t.testnet)
-			signBinary = append(signBinary, t.txInputs[i].Serialize()...)
-		} else {
-			signBinary = append(signBinary, t.txInputs[i].Serialize()...)
+			scriptCode := redeemScript
+			if scriptCode == nil {
+				var err error
+				if scriptCode, err = t.txInputs[i].scriptPubKey(t.testnet); err != nil {
+					return nil, err
+				}
+			}
+			signBinary = append(signBinary, t.txInputs[i].serializeWithScript(scriptCode, t.txInputs[i].sequence)...)
+		} else if !anyoneCanPay {
+			sequence := t.txInputs[i].sequence
+			if baseType == SIGHASH_NONE || baseType == SIGHASH_SINGLE {
+				sequence = big.NewInt(int64(0))
+			}
+			signBinary = append(signBinary, t.txInputs[i].serializeWithScript(InitScriptSig([][]byte{}), sequence)...)
 		}
 	}
 
-	outputCount := big.NewInt(int64(len(t.txOutputs)))
-	signBinary = append(signBinary, EncodeVarint(outputCount)...)
-	for i := 0; i < len(t.txOutputs); i++ {
-		signBinary = append(signBinary, t.txOutputs[i].Serialize()...)
+	switch baseType {
+	case SIGHASH_NONE:
+		signBinary = append(signBinary, EncodeVarint(big.NewInt(int64(0)))...)
+	case SIGHASH_SINGLE:
+		signBinary = append(signBinary, EncodeVarint(big.NewInt(int64(inputIdx+1)))...)
+		for i := 0; i < inputIdx; i++ {
+			// amount -1 is 0xffffffffffffffff, followed by empty script
+			signBinary = append(signBinary, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00)
+		}
+		signBinary = append(signBinary, t.txOutputs[inputIdx].Serialize()...)
+	default:
+		outputCount := big.NewInt(int64(len(t.txOutputs)))
+		signBinary = append(signBinary, EncodeVarint(outputCount)...)
+		for i := 0; i < len(t.txOutputs); i++ {
+			signBinary = append(signBinary, t.txOutputs[i].Serialize()...)
+		}
 	}
 
 	signBinary = append(signBinary, BigIntToLittleEndian(t.lockTime, LITTLE_ENDIAN_4_BYTES)...)
-	signBinary = append(signBinary, BigIntToLittleEndian(big.NewInt(int64(SIGHASH_ALL)),
+	signBinary = append(signBinary, BigIntToLittleEndian(big.NewInt(int64(hashType)),
 		LITTLE_ENDIAN_4_BYTES)...)
 
-	return signBinary
+	return signBinary, nil
 }
 
-func (t *Transaction) SignHash(inputIdx int) []byte {
-	signBinary := t.SerializeWithSign(inputIdx)
+func (t *Transaction) SignHash(inputIdx int, redeemScript *ScriptSig, hashType byte) ([]byte, error) {
+	/*
+		SIGHASH_SINGLE with the input index out of the range of outputs, the
+		message is 1 instead of failure, this is a bug in the original client
+		and kept for compatibility. SerializeWithSign returns
+		ErrSighashSingleNoOutput for it, the 1 hash is returned here explicitly
+		before serializing
+	*/
+	if err := t.checkInputIndex(inputIdx); err != nil {
+		return nil, err
+	}
+	if hashType&SIGHASH_BASE_MASK == SIGHASH_SINGLE && inputIdx >= len(t.txOutputs) {
+		one := make([]byte, 32)
+		one[0] = 0x01
+		return one, nil
+	}
+
+	signBinary, err := t.SerializeWithSign(inputIdx, redeemScript, hashType)
+	if err != nil {
+		return nil, err
+	}
 	h256 := ecc.Hash256(string(signBinary))
-	return h256
+	return h256, nil
+}
+
+func (t *Transaction) getHashPrevouts() []byte {
+	// hash256 of all the previous transaction ids and indices of inputs
+	allPrevouts := make([]byte, 0)
+	for _, txInput := range t.txInputs {
+		allPrevouts = append(allPrevouts, reverseByteSlice(txInput.previousTransactionID)...)
+		allPrevouts = append(allPrevouts, BigIntToLittleEndian(txInput.previousTransactionIndex, LITTLE_ENDIAN_4_BYTES)...)
+	}
+	return ecc.Hash256(string(allPrevouts))
+}
+
+func (t *Transaction) getHashSequence() []byte {
+	// hash256 of the sequence of all inputs
+	allSequence := make([]byte, 0)
+	for _, txInput := range t.txInputs {
+		allSequence = append(allSequence, BigIntToLittleEndian(txInput.sequence, LITTLE_ENDIAN_4_BYTES)...)
+	}
+	return ecc.Hash256(string(allSequence))
+}
+
+func (t *Transaction) getHashOutputs() []byte {
+	// hash256 of all the serialized outputs
+	allOutputs := make([]byte, 0)
+	for _, txOutput := range t.txOutputs {
+		allOutputs = append(allOutputs, txOutput.Serialize()...)
+	}
+	return ecc.Hash256(string(allOutputs))
+}
+
+func (t *Transaction) serializeBip143(inputIdx int, scriptCode *ScriptSig, amount *big.Int, hashType byte) []byte {
+	/*
+		BIP143 signature message for segwit v0 input:
+		version | hashPrevouts | hashSequence | previous tx id and index of this input |
+		scriptCode | amount of previous output | sequence of this input | hashOutputs |
+		lock time | hash type
+
+		hashPrevouts, hashSequence, hashOutputs are the same for all inputs, a
+		signer can compute them once to avoid the quadratic hashing of legacy
+		signature message, and committing the amount let offline signer know
+		the fee it is paying. They are computed on every call here, inputs and
+		outputs may be changed between calls
+
+		for ANYONECANPAY, NONE and SINGLE, the hashes for the part of transaction
+		not signed are set to 32 bytes of zero
+	*/
+	baseType := hashType & SIGHASH_BASE_MASK
+	anyoneCanPay := hashType&SIGHASH_ANYONECANPAY != 0
+	zeroHash := make([]byte, 32)
+
+	hashPrevouts := zeroHash
+	if !anyoneCanPay {
+		hashPrevouts = t.getHashPrevouts()
+	}
+	hashSequence := zeroHash
+	if !anyoneCanPay && baseType != SIGHASH_SINGLE && baseType != SIGHASH_NONE {
+		hashSequence = t.getHashSequence()
+	}
+	hashOutputs := zeroHash
+	if baseType != SIGHASH_SINGLE && baseType != SIGHASH_NONE {
+		hashOutputs = t.getHashOutputs()
+	} else if baseType == SIGHASH_SINGLE && inputIdx < len(t.txOutputs) {
+		hashOutputs = ecc.Hash256(string(t.txOutputs[inputIdx].Serialize()))
+	}
+
+	txInput := t.txInputs[inputIdx]
+	signBinary := make([]byte, 0)
+	signBinary = append(signBinary, BigIntToLittleEndian(t.version, LITTLE_ENDIAN_4_BYTES)...)
+	signBinary = append(signBinary, hashPrevouts...)
+	signBinary = append(signBinary, hashSequence...)
+	signBinary = append(signBinary, reverseByteSlice(txInput.previousTransactionID)...)
+	signBinary = append(signBinary, BigIntToLittleEndian(txInput.previousTransactionIndex, LITTLE_ENDIAN_4_BYTES)...)
+	signBinary = append(signBinary, scriptCode.Serialize()...)
+	signBinary = append(signBinary, BigIntToLittleEndian(amount, LITTLE_ENDIAN_8_BYTES)...)
+	signBinary = append(signBinary, BigIntToLittleEndian(txInput.sequence, LITTLE_ENDIAN_4_BYTES)...)
+	signBinary = append(signBinary, hashOutputs...)
+	signBinary = append(signBinary, BigIntToLittleEndian(t.lockTime, LITTLE_ENDIAN_4_BYTES)...)
+	signBinary = append(signBinary, BigIntToLittleEndian(big.NewInt(int64(hashType)),
+		LITTLE_ENDIAN_4_BYTES)...)
+
+	return signBinary
+}
+
+func (t *Transaction) SignHashBip143(inputIdx int, redeemScript *ScriptSig, witnessScript *ScriptSig, hashType byte) ([]byte, error) {
+	/*
+		scriptCode for p2wpkh is OP_DUP OP_HASH160 <20 bytes hash> OP_EQUALVERIFY OP_CHECKSIG,
+		the hash is from scriptPubKey, or the redeem script for p2sh-p2wpkh,
+		for p2wsh and p2sh-p2wsh the scriptCode is the witness script
+	*/
+	if err := t.checkInputIndex(inputIdx); err != nil {
+		return nil, err
+	}
+	txInput := t.txInputs[inputIdx]
+	var scriptCode *ScriptSig
+	if witnessScript != nil {
+		scriptCode = witnessScript
+	} else if redeemScript != nil {
+		scriptCode = P2pkScript(redeemScript.bitcoinOpCode.cmds[1])
+	} else {
+		scriptPubKey, err := txInput.scriptPubKey(t.testnet)
+		if err != nil {
+			return nil, err
+		}
+		scriptCode = P2pkScript(scriptPubKey.bitcoinOpCode.cmds[1])
+	}
+
+	amount, err := txInput.Value(t.testnet)
+	if err != nil {
+		return nil, err
+	}
+	signBinary := t.serializeBip143(inputIdx, scriptCode, amount, hashType)
+	return ecc.Hash256(string(signBinary)), nil
 }
 
 func (t *Transaction) VerifyInput(inputIdx int) bool {
-	verifyScript := t.GetScript(inputIdx, t.testnet)
-	z := t.SignHash(inputIdx)
-	return verifyScript.Evaluate(z)
+	if t.checkInputIndex(inputIdx) != nil {
+		return false
+	}
+	txInput := t.txInputs[inputIdx]
+	if txInput.isNullOutpoint() {
+		// only coinbase spends the null outpoint, there is nothing to fetch
+		return t.IsCoinbase() && t.verifyCoinbase()
+	}
+	scriptPubKey, err := txInput.scriptPubKey(t.testnet)
+	if err != nil {
+		return false
+	}
+	if scriptPubKey.IsP2trScriptPubKey() {
+		// segwit v1, the scriptPubKey is OP_1 with the output key
+		return t.verifyTaproot(inputIdx, scriptPubKey.bitcoinOpCode.cmds[1])
+	}
+	/*
+		if the previous output is p2sh, the scriptSig only pushes data and the
+		last element is the redeem script, it replaces the scriptpubkey for
+		the signature message, if the redeem script or scriptPubKey is witness
+		program, the message is computed by BIP143 and the witness is used
+		for evaluation
+	*/
+	var redeemScript *ScriptSig
+	var redeemStack [][]byte
+	if scriptPubKey.IsP2shScriptPubKey() {
+		var ok bool
+		redeemScript, redeemStack, ok = txInput.scriptSig.p2shRedeemScript()
+		if !ok {
+			return false
+		}
+	}
+
+	witnessProgram := scriptPubKey
+	if redeemScript != nil {
+		witnessProgram = redeemScript
+	}
+	if witnessProgram.isWitnessProgram() {
+		/*
+			segwit v0 is decided by the scriptPubKey or the redeem script,
+			the scriptSig is empty for native witness program, it is the push
+			of the redeem script only for p2sh wrapped one
+		*/
+		scriptSig := txInput.scriptSig.rawSerialize()
+		expected := []byte{}
+		if redeemScript != nil {
+			redeem := initScriptSigWithDataFlags([][]byte{redeemScript.rawSerialize()}, []bool{true})
+			expected = redeem.rawSerialize()
+		}
+		if !bytes.Equal(scriptSig, expected) {
+			return false
+		}
+	} else if len(txInput.witness) != 0 {
+		// witness of legacy input would be ignored, it is not allowed
+		return false
+	}
+
+	/*
+		the message to be signed depends on the hash type attached to the
+		signature, it is computed when the signature is checked
+	*/
+	var sigHash func(hashType byte) ([]byte, bool)
+	var witness [][]byte
+	if witnessProgram.IsP2wpkhScriptPubKey() {
+		sigHash = func(hashType byte) ([]byte, bool) {
+			z, err := t.SignHashBip143(inputIdx, redeemScript, nil, hashType)
+			return z, err == nil
+		}
+		witness = append([][]byte{}, txInput.witness...)
+	} else if witnessProgram.IsP2wshScriptPubKey() {
+		if len(txInput.witness) == 0 {
+			return false
+		}
+		witnessScript, err := parseRawScript(txInput.witness[len(txInput.witness)-1])
+		if err != nil {
+			return false
+		}
+		sigHash = func(hashType byte) ([]byte, bool) {
+			z, err := t.SignHashBip143(inputIdx, nil, witnessScript, hashType)
+			return z, err == nil
+		}
+		witness = append([][]byte{}, txInput.witness...)
+	} else {
+		sigHash = func(hashType byte) ([]byte, bool) {
+			z, err := t.SignHash(inputIdx, redeemScript, hashType)
+			return z, err == nil
+		}
+	}
+
+	verifyScript := txInput.scriptSig.Add(scriptPubKey)
+	verifyScript.SetTransactionContext(t.version, t.lockTime, txInput.sequence)
+	verifyScript.SetFlags(SCRIPT_VERIFY_NULLDUMMY)
+	verifyScript.SetSigHash(sigHash)
+	if redeemScript != nil {
+		verifyScript.SetRedeemScript(redeemScript, redeemStack)
+	}
+	return verifyScript.EvaluateWithWitness(nil, witness)
+}
+
+func (t *Transaction) SignInput(inputIdx int, privateKey *ecc.PrivateKey) bool {
+	/*
+		sign the input spending p2pkh or p2wpkh output of the private key with
+		SIGHASH_ALL, the signature and compressed sec public key are put into
+		the scriptSig for p2pkh or the witness for p2wpkh, then verify the input
+	*/
+	return t.signInput(inputIdx, privateKey, true)
+}
+
+func (t *Transaction) signInput(inputIdx int, privateKey *ecc.PrivateKey, compressed bool) bool {
+	if t.checkInputIndex(inputIdx) != nil {
+		return false
+	}
+	txInput := t.txInputs[inputIdx]
+	if txInput.isNullOutpoint() {
+		// coinbase input spends nothing, there is no output to sign for
+		return false
+	}
+	scriptPubKey, err := txInput.scriptPubKey(t.testnet)
+	if err != nil {
+		return false
+	}
+	isP2wpkh := scriptPubKey.IsP2wpkhScriptPubKey()
+
+	var z []byte
+	if isP2wpkh {
+		z, err = t.SignHashBip143(inputIdx, nil, nil, SIGHASH_ALL)
+	} else {
+		z, err = t.SignHash(inputIdx, nil, SIGHASH_ALL)
+	}
+	if err != nil {
+		return false
+	}
+	zMsg := new(big.Int)
+	zMsg.SetBytes(z)
+	der := privateKey.Sign(zMsg).Der()
+	// append the hash type at the end of signature
+	sig := append(der, byte(SIGHASH_ALL))
+	_, sec := privateKey.GetPublicKey().Sec(compressed)
+
+	if isP2wpkh {
+		txInput.SetScript(InitScriptSig([][]byte{}))
+		txInput.SetWitness([][]byte{sig, sec})
+	} else {
+		txInput.SetScript(InitScriptSig([][]byte{sig, sec}))
+	}
+
+	return t.VerifyInput(inputIdx)
 }
 
 func (t *Transaction) Verify() bool {
 	/*
+		coinbase has no previous output, only its scriptSig is checked,
+		otherwise:
 		1. verify fee
 		2. verify each transaction input
 	*/
-	if t.Fee().Cmp(big.NewInt(int64(0))) < 0 {
+	if t.IsCoinbase() {
+		return t.verifyCoinbase()
+	}
+	for _, txInput := range t.txInputs {
+		// null outpoint out of coinbase spends nothing, it can't be fetched
+		if txInput.isNullOutpoint() {
+			return false
+		}
+	}
+	fee, err := t.Fee()
+	if err != nil || fee.Cmp(big.NewInt(int64(0))) < 0 {
 		return false
 	}
 
@@ -118,22 +479,36 @@ func (t *Transaction) Verify() bool {
 	return true
 }
 
-func ParseTransaction(binary []byte) *Transaction {
-	transaction := &Transaction{}
+func ParseTransaction(binary []byte) (*Transaction, error) {
 	reader := bytes.NewReader(binary)
-	bufReader := bufio.NewReader(reader)
+	return ReadTransaction(bufio.NewReader(reader))
+}
 
-	verBuf := make([]byte, 4)
-	bufReader.Read(verBuf)
+func ReadTransaction(bufReader *bufio.Reader) (*Transaction, error) {
+	/*
+		read one transaction from the reader and leave the reader right after
+		it, transactions in a block follow each other without any separator
+	*/
+	transaction := &Transaction{}
+	verBuf, err := ReadBytes(bufReader, 4)
+	if err != nil {
+		return nil, fmt.Errorf("read version: %w", err)
+	}
 
 	version := LittleEndianToBigInt(verBuf, LITTLE_ENDIAN_4_BYTES)
-	fmt.Printf("transaction version: %x\n", version)
 	transaction.version = version
 
-	inputs := getInputCount(bufReader)
+	inputs, segwit, err := getInputCount(bufReader)
+	if err != nil {
+		return nil, err
+	}
+	transaction.segwit = segwit
 	transactionInputs := []*TransactionInput{}
 	for i := 0; i < int(inputs.Int64()); i++ {
-		input := NewTransactionInput(bufReader)
+		input, err := NewTransactionInput(bufReader)
+		if err != nil {
+			return nil, fmt.Errorf("read input %d: %w", i, err)
+		}
 		transactionInputs = append(transactionInputs, input)
 	}
 	transaction.txInputs = transactionInputs
@@ -160,62 +535,214 @@ func ParseTransaction(binary []byte) *Transaction {
 	*/
 
 	// read output connts
-	outputs := ReadVarint(bufReader)
+	outputs, err := ReadVarint(bufReader)
+	if err != nil {
+		return nil, fmt.Errorf("read output count: %w", err)
+	}
 	transactionOutputs := []*TransactionOutput{}
 	for i := 0; i < int(outputs.Int64()); i++ {
-		output := NewTransactionOutput(bufReader)
+		output, err := NewTransactionOutput(bufReader)
+		if err != nil {
+			return nil, fmt.Errorf("read output %d: %w", i, err)
+		}
 		transactionOutputs = append(transactionOutputs, output)
 	}
 	transaction.txOutputs = transactionOutputs
 
+	/*
+		witness of segwit transaction is after the outputs, each input has its
+		witness field, it begins with the count of items, each item is in the
+		format of varint length followed by the data
+	*/
+	if segwit {
+		for i := 0; i < len(transaction.txInputs); i++ {
+			transaction.txInputs[i].witness, err = ReadWitness(bufReader)
+			if err != nil {
+				return nil, fmt.Errorf("read witness %d: %w", i, err)
+			}
+		}
+	}
+
 	// get last four byte for lock time
-	lockTimeBytes := make([]byte, 4)
-	bufReader.Read(lockTimeBytes)
+	lockTimeBytes, err := ReadBytes(bufReader, 4)
+	if err != nil {
+		return nil, fmt.Errorf("read lock time: %w", err)
+	}
 	transaction.lockTime = LittleEndianToBigInt(lockTimeBytes, LITTLE_ENDIAN_4_BYTES)
 
-	return transaction
+	return transaction, nil
 }
 
-func getInputCount(bufReader *bufio.Reader) *big.Int {
+func getInputCount(bufReader *bufio.Reader) (*big.Int, bool, error) {
 	/*
 		if the first byte of input is 0, then witness transaction,
 		we need to skip the first two bytes(0x00, 0x01)
 	*/
-	firstByte, err := bufReader.Peek(1)
+	segwit := false
+	firstByte, err := bufReader.Peek(2)
 	if err != nil {
-		panic(err)
+		return nil, false, fmt.Errorf("read input count: %w", ErrTruncatedInput)
 	}
-	if firstByte[0] == 0x00 {
+	if firstByte[0] == SEGWIT_MARKER {
+		if firstByte[1] != SEGWIT_FLAG {
+			return nil, false, fmt.Errorf("%w: segwit flag should be 0x01 but got %x", ErrBadSegwitFlag, firstByte[1])
+		}
 		// skip the first two bytes
-		skipBuf := make([]byte, 2)
-		_, err = bufReader.Read(skipBuf)
-		if err != nil {
-			panic(err)
+		if _, err := ReadBytes(bufReader, 2); err != nil {
+			return nil, false, err
+		}
+		segwit = true
+	}
+
+	count, err := ReadVarint(bufReader)
+	if err != nil {
+		return nil, false, fmt.Errorf("read input count: %w", err)
+	}
+	return count, segwit, nil
+}
+
+func (t *Transaction) IsSegwit() bool {
+	/*
+		transaction is serialized with witness if any of its inputs has
+		witness data
+	*/
+	for _, txInput := range t.txInputs {
+		if len(txInput.witness) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *Transaction) Serialize() []byte {
+	/*
+		legacy transaction:
+		version | input count | inputs | output count | outputs | lock time
+
+		segwit transaction(BIP144):
+		version | 0x00 0x01 | input count | inputs | output count | outputs |
+		witness of each input | lock time
+	*/
+	if !t.IsSegwit() {
+		return t.SerializeLegacy()
+	}
+
+	result := make([]byte, 0)
+	result = append(result, BigIntToLittleEndian(t.version, LITTLE_ENDIAN_4_BYTES)...)
+	result = append(result, SEGWIT_MARKER, SEGWIT_FLAG)
+	result = append(result, t.serializeInputsAndOutputs()...)
+	for _, txInput := range t.txInputs {
+		result = append(result, txInput.SerializeWitness()...)
+	}
+	result = append(result, BigIntToLittleEndian(t.lockTime, LITTLE_ENDIAN_4_BYTES)...)
+	return result
+}
+
+func (t *Transaction) SerializeLegacy() []byte {
+	/*
+		serialize without marker, flag and witness, the transaction id is
+		computed on it, then changing the witness would not change the id
+	*/
+	result := make([]byte, 0)
+	result = append(result, BigIntToLittleEndian(t.version, LITTLE_ENDIAN_4_BYTES)...)
+	result = append(result, t.serializeInputsAndOutputs()...)
+	result = append(result, BigIntToLittleEndian(t.lockTime, LITTLE_ENDIAN_4_BYTES)...)
+	return result
+}
+
+func (t *Transaction) serializeInputsAndOutputs() []byte {
+	result := make([]byte, 0)
+	result = append(result, EncodeVarint(big.NewInt(int64(len(t.txInputs))))...)
+	for _, txInput := range t.txInputs {
+		result = append(result, txInput.Serialize()...)
+	}
+	result = append(result, EncodeVarint(big.NewInt(int64(len(t.txOutputs))))...)
+	for _, txOutput := range t.txOutputs {
+		result = append(result, txOutput.Serialize()...)
+	}
+	return result
+}
+
+func (t *Transaction) Hash() []byte {
+	/*
+		hash256 of the legacy serialization, it is displayed in little endian
+		format, that is why we need to reverse it
+	*/
+	return reverseByteSlice(ecc.Hash256(string(t.SerializeLegacy())))
+}
+
+func (t *Transaction) ID() string {
+	return fmt.Sprintf("%x", t.Hash())
+}
+
+func (t *Transaction) WitnessHash() []byte {
+	// wtxid, hash256 of the serialization including witness
+	return reverseByteSlice(ecc.Hash256(string(t.Serialize())))
+}
+
+func (t *Transaction) WitnessID() string {
+	return fmt.Sprintf("%x", t.WitnessHash())
+}
+
+func (t *Transaction) Inputs() []*TransactionInput {
+	return t.txInputs
+}
+
+func (t *Transaction) Outputs() []*TransactionOutput {
+	return t.txOutputs
+}
+
+func (t *Transaction) WitnessCommitment() ([]byte, bool) {
+	/*
+		coinbase of a block with segwit transactions commits to their wtxids
+		by an output of OP_RETURN with 36 bytes of data:
+		6a24aa21a9ed | 32 bytes commitment
+		it is the last such output if there are more than one
+	*/
+	for i := len(t.txOutputs) - 1; i >= 0; i-- {
+		script := t.txOutputs[i].scriptPubKey.rawSerialize()
+		if len(script) >= WITNESS_COMMITMENT_SCRIPT_LENGTH && bytes.Equal(script[0:len(WITNESS_COMMITMENT_HEADER)], WITNESS_COMMITMENT_HEADER) {
+			return script[len(WITNESS_COMMITMENT_HEADER):WITNESS_COMMITMENT_SCRIPT_LENGTH], true
 		}
 	}
+	return nil, false
+}
 
-	count := ReadVarint(bufReader)
-	fmt.Printf("input count is: %x\n", count)
-	return count
+func (t *Transaction) checkInputIndex(idx int) error {
+	if idx < 0 || idx >= len(t.txInputs) {
+		return fmt.Errorf("%w: input %d of %d", ErrInputIndex, idx, len(t.txInputs))
+	}
+	return nil
 }
 
-func (t *Transaction) GetScript(idx int, testnet bool) *ScriptSig {
-	if idx < 0 || idx > len(t.txInputs) {
-		panic("invalid idx for transaction input")
+func (t *Transaction) GetScript(idx int, testnet bool) (*ScriptSig, error) {
+	if err := t.checkInputIndex(idx); err != nil {
+		return nil, err
 	}
 
 	txInputs := t.txInputs[idx]
 	return txInputs.Script(testnet)
 }
 
-func (t *Transaction) Fee() *big.Int {
-	// amount of input - amount of output > 0
+func (t *Transaction) Fee() (*big.Int, error) {
+	/*
+		amount of input - amount of output > 0
+		coinbase has no input amount, it pays no fee but collects the fees
+		of the block, we don't fetch the null previous transaction for it
+	*/
+	if t.IsCoinbase() {
+		return big.NewInt(0), nil
+	}
 	inputSum := big.NewInt(int64(0))
 	outputSum := big.NewInt(int64(0))
 
 	for i := 0; i < len(t.txInputs); i++ {
 		addOP := new(big.Int)
-		value := t.txInputs[i].Value(t.testnet)
+		value, err := t.txInputs[i].Value(t.testnet)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
 		inputSum = addOP.Add(inputSum, value)
 	}
 
@@ -225,5 +752,5 @@ func (t *Transaction) Fee() *big.Int {
 	}
 
 	opSub := new(big.Int)
-	return opSub.Sub(inputSum, outputSum)
+	return opSub.Sub(inputSum, outputSum), nil
 }
@@ -1,11 +1,17 @@
 package transaction
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
 	ecc "elliptic_curve"
 	"encoding/hex"
 	"fmt"
 	"math/big"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetInputCount(t *testing.T) {
@@ -15,7 +21,8 @@ func TestGetInputCount(t *testing.T) {
 	if err != nil {
 		panic(err)
 	}
-	ParseTransaction(binary)
+	_, err = ParseTransaction(binary)
+	assert.Nil(t, err)
 
 	//segwit transaction
 	binaryStr = "01000000000102197393122da5beff963907ff11e4041af10780c868188aad754cc73e3cc35cd9010000001716001462c61a14835b032d5acbe190291d80d0cc5ca28e00000000feae2204104ffe542f30a20012a5b8e2b54a6f61f592520b511801b2237b5ed80100000017160014b30be91e50402cda780c56a3e1c350b1086c80af000000000200a3e111000000001976a914e60c9ac5f72d1d620287a0fc35656bceae5e2ab988ac525d35130000000017a9144795995aff558cc538669ebfecffbe5c9837d5ca870247304402207dd1e7c6c596041276b5285dd3747f586ad819a24acdf0ad60b1faa82af00d3b022046a22dd57df4b72ac165e05b4a6cf8dbecfcfad8f16ae7353df56638ebbf5d1f012103a1a226c5047672af98b2e673751dc69f0140b957753d9c1a789c243100292c6f024730440220670625143c3dfc7a862659a79cbf4ad0f84ff1509bd052cfbfbcdba7adf501f9022015f14a6ee1ae7a8f9fec1070d8a97195422b76a317286c816392cb150d7eb76d012102c910a40bf5726168acc5a8318b0505375e877d4d74448f32ef48156794e657f900000000"
@@ -24,7 +31,29 @@ func TestGetInputCount(t *testing.T) {
 	if err != nil {
 		panic(err)
 	}
-	ParseTransaction(binary)
+	_, err = ParseTransaction(binary)
+	assert.Nil(t, err)
+}
+
+/*
+newBookTransaction parses the legacy transaction explained in TestTransactionMain,
+it spends output 0 of d1c789a9c60383bf715f3f6ad9d14b91fe55f3deb369fe5d9280cb1a01793f81
+paying 42505594 satoshi to p2pkh of a802fc56c704ce87c42d7c92eb75e7896bdc41ae.
+The raw previous transaction is not kept in the repo, the fetcher can't be
+loaded with it and the id check of FetchTransaction can't be passed by another
+one, so the spent output is set directly and an empty MemoryFetcher makes
+sure nothing goes to the network
+*/
+func newBookTransaction(t *testing.T) *Transaction {
+	binary, err := hex.DecodeString("0100000001813f79011acb80925dfe69b3def355fe914bd1d96a3f5f71bf8303c6a989c7d1000000006b483045022100ed81ff192e75a3fd2304004dcadb746fa5e24c5031ccfcf21320b0277457c98f02207a986d955c6e0cb35d446a89d3f56100f4d7f67801c31967743a9c8e10615bed01210349fc4e631e3624a545de3f89f5d8684c7b8138bd94bdd531d2e213bf016b278afeffffff02a135ef01000000001976a914bc3b654dca7e56b04dca18f2566cdaf02e8d9ada88ac99c39800000000001976a9141c4bc762dd5423e332166702cb75f40df79fea1288ac19430600")
+	require.NoError(t, err)
+	transaction, err := ParseTransaction(binary)
+	require.NoError(t, err)
+	h160, err := hex.DecodeString("a802fc56c704ce87c42d7c92eb75e7896bdc41ae")
+	require.NoError(t, err)
+	transaction.SetFetcher(NewMemoryFetcher())
+	transaction.txInputs[0].SetPreviousOutput(InitTransactionOutPut(big.NewInt(int64(42505594)), P2pkScript(h160)))
+	return transaction
 }
 
 /*
@@ -63,23 +92,18 @@ func TestTransactionMain(t *testing.T) {
 		4. sequence feffffff out of date 4 bytes
 
 	*/
-	//legacy transaction
-	binaryStr := "0100000001813f79011acb80925dfe69b3def355fe914bd1d96a3f5f71bf8303c6a989c7d1000000006b483045022100ed81ff192e75a3fd2304004dcadb746fa5e24c5031ccfcf21320b0277457c98f02207a986d955c6e0cb35d446a89d3f56100f4d7f67801c31967743a9c8e10615bed01210349fc4e631e3624a545de3f89f5d8684c7b8138bd94bdd531d2e213bf016b278afeffffff02a135ef01000000001976a914bc3b654dca7e56b04dca18f2566cdaf02e8d9ada88ac99c39800000000001976a9141c4bc762dd5423e332166702cb75f40df79fea1288ac19430600"
-	binary, err := hex.DecodeString(binaryStr)
-	if err != nil {
-		panic(err)
-	}
-	transaction := ParseTransaction(binary)
-	script := transaction.GetScript(0, false)
+	transaction := newBookTransaction(t)
+	script, err := transaction.GetScript(0, false)
+	require.NoError(t, err)
 	// this is not our transaction and we don't have its message and private key
 	modifiedTx, err := hex.DecodeString("0100000001813f79011acb80925dfe69b3def355fe914bd1d96a3f5f71bf8303c6a989c7d1000000001976a914a802fc56c704ce87c42d7c92eb75e7896bdc41ae88acfeffffff02a135ef01000000001976a914bc3b654dca7e56b04dca18f2566cdaf02e8d9ada88ac99c39800000000001976a9141c4bc762dd5423e332166702cb75f40df79fea1288ac1943060001000000")
-	if err != nil {
-		panic(err)
-	}
+	require.NoError(t, err)
 	hash256 := ecc.Hash256(string(modifiedTx))
 	fmt.Printf("hash256 of modified transaction is: %x\n", hash256)
-	script.Evaluate([]byte{})
-
+	z, err := transaction.SignHash(0, nil, SIGHASH_ALL)
+	require.NoError(t, err)
+	assert.Equal(t, hash256, z)
+	assert.True(t, script.Evaluate(hash256))
 }
 
 /*
@@ -108,24 +132,18 @@ ac99c39800000000001976a9141c4bc762dd5423e332166702cb75f40df79fea1288ac19430600 [
 */
 
 func TestTransactionVerify(t *testing.T) {
-	binaryStr := "0100000001813f79011acb80925dfe69b3def355fe914bd1d96a3f5f71bf8303c6a989c7d1000000006b483045022100ed81ff192e75a3fd2304004dcadb746fa5e24c5031ccfcf21320b0277457c98f02207a986d955c6e0cb35d446a89d3f56100f4d7f67801c31967743a9c8e10615bed01210349fc4e631e3624a545de3f89f5d8684c7b8138bd94bdd531d2e213bf016b278afeffffff02a135ef01000000001976a914bc3b654dca7e56b04dca18f2566cdaf02e8d9ada88ac99c39800000000001976a9141c4bc762dd5423e332166702cb75f40df79fea1288ac19430600"
-	binary, err := hex.DecodeString(binaryStr)
-	if err != nil {
-		panic(err)
-	}
-	transaction := ParseTransaction(binary)
+	transaction := newBookTransaction(t)
 	res := transaction.Verify()
 	fmt.Printf("The evaluation result is %v\n", res)
+	assert.True(t, res)
 }
 
 func TestFee(t *testing.T) {
-	binaryStr := "0100000001813f79011acb80925dfe69b3def355fe914bd1d96a3f5f71bf8303c6a989c7d1000000006b483045022100ed81ff192e75a3fd2304004dcadb746fa5e24c5031ccfcf21320b0277457c98f02207a986d955c6e0cb35d446a89d3f56100f4d7f67801c31967743a9c8e10615bed01210349fc4e631e3624a545de3f89f5d8684c7b8138bd94bdd531d2e213bf016b278afeffffff02a135ef01000000001976a914bc3b654dca7e56b04dca18f2566cdaf02e8d9ada88ac99c39800000000001976a9141c4bc762dd5423e332166702cb75f40df79fea1288ac19430600"
-	binary, err := hex.DecodeString(binaryStr)
-	if err != nil {
-		panic(err)
-	}
-	transaction := ParseTransaction(binary)
-	fmt.Printf("Fee of teh transaction is %v\n", transaction.Fee())
+	transaction := newBookTransaction(t)
+	fee, err := transaction.Fee()
+	require.NoError(t, err)
+	fmt.Printf("Fee of teh transaction is %v\n", fee)
+	assert.Equal(t, big.NewInt(int64(40000)), fee)
 }
 
 func TestGetWalletAddres(t *testing.T) {
@@ -138,7 +156,8 @@ func TestGetWalletAddres(t *testing.T) {
 	pubKey := privateKey.GetPublicKey()
 	fmt.Printf("your wallet address: %s\n", pubKey.Address(true, true))
 	walletAddress := pubKey.Address(true, true)
-	res := ecc.DecodeBase58(walletAddress)
+	res, err := ecc.DecodeBase58(walletAddress)
+	assert.Nil(t, err)
 	fmt.Printf("decode result is %x\n", res)
 }
 
@@ -155,15 +174,24 @@ func TestCreateTransactionInstance(t *testing.T) {
 		send back 0.0001 to myself, and send 0.00009756 as fee to miners
 	*/
 	changeAmount := big.NewInt(int64(0.0001 * STASHI_PRE_BITCOIN))
-	changeH160 := ecc.DecodeBase58("a")
+	changeH160, err := ecc.DecodeBase58("a")
+	if err != nil {
+		panic(err)
+	}
 	changeScript := P2pkScript(changeH160)
 	changeOut := InitTransactionOutPut(changeAmount, changeScript)
 
 	transaction := InitTransaction(big.NewInt(int64(1)), []*TransactionInput{txInput}, []*TransactionOutput{changeOut}, big.NewInt(int64(0)), true)
 	fmt.Printf("%s\n", transaction)
 
+	p := new(big.Int)
+	p.SetBytes(ReverseByteSlice(ecc.Hash256("your secret string here")))
+	privateKey := ecc.NewPrivateKey(p)
+	pubKey := privateKey.GetPublicKey()
+
 	// sign the first transaction
-	z := transaction.SignHash(0)
+	z, err := transaction.SignHash(0, nil, SIGHASH_ALL)
+	assert.Nil(t, err)
 	zMsg := new(big.Int)
 	zMsg.SetBytes(z)
 	der := privateKey.Sign(zMsg).Der()
@@ -173,6 +201,556 @@ func TestCreateTransactionInstance(t *testing.T) {
 	scriptSig := InitScriptSig([][]byte{sig, sec})
 	txInput.SetScript(scriptSig)
 
-	rawTx := transaction.SerializeWithSign(-1)
+	rawTx := transaction.Serialize()
 	fmt.Printf("raw tx: %x\n", rawTx)
 }
+
+func TestP2shSignHash(t *testing.T) {
+	/*
+		2 of 2 p2sh multisig spending, the redeem script replaces the scriptSig
+		when computing the signature message
+	*/
+	sig1 := "3045022100dc92655fe37036f47756db8102e0d7d5e28b3beb83a8fef4f5dc0559bddfb94e02205a36d4e4e6c7fcd16658c50783e00c341609977aed3ad00937bf4ee942a8993701"
+	sig2 := "3045022100da6bee3c93766232079a01639d07fa869598749729ae323eab8eef53577d611b02207bef15429dcadce2121ea07f233115c6f09034c0be68db99980b9a6c5e75402201"
+	redeem := "5221022626e955ea6ea6d98850c994f9107b036b1334f18ca8830bfff1295d21cfdb702103b287eaf122eea69030a0e9feed096bed8045c8b98bec453e1ffac7fbdbd4bb7152ae"
+	binaryStr := "0100000001868278ed6ddfb6c1ed3ad5f8181eb0c7a385aa0836f01d5e4789e6bd304d87221a000000" +
+		"db0048" + sig1 + "48" + sig2 + "47" + redeem +
+		"ffffffff04d3b11400000000001976a914904a49878c0adfc3aa05de7afad2cc15f483a56a88ac7f400900000000001976a914418327e3f3dda4cf5b9089325a4b95abdfa0334088ac722c0c00000000001976a914ba35042cfe9fc66fd35ac2224eebdafd1028ad2788acdc4ace020000000017a91474d691da1574e6b3c192ecfb52cc8984ee7b6c568700000000"
+	binary, err := hex.DecodeString(binaryStr)
+	assert.Nil(t, err)
+	transaction, err := ParseTransaction(binary)
+	assert.Nil(t, err)
+
+	redeemBin, err := hex.DecodeString(redeem)
+	assert.Nil(t, err)
+	redeemScript, err := parseRawScript(redeemBin)
+	assert.Nil(t, err)
+	z, err := transaction.SignHash(0, redeemScript, SIGHASH_ALL)
+	assert.Nil(t, err)
+	assert.Equal(t, "e71bfa115715d6fd33796948126f40a8cdd39f187e4afb03896795189fe1423c", fmt.Sprintf("%x", z))
+
+	// evaluate the scriptSig with the p2sh scriptPubKey of the previous output
+	scriptPubKey := P2shScript(ecc.Hash160(redeemBin))
+	scriptSig := transaction.txInputs[0].scriptSig
+	p2shRedeem, stack, ok := scriptSig.p2shRedeemScript()
+	assert.True(t, ok)
+	assert.Equal(t, redeemBin, p2shRedeem.rawSerialize())
+	script := scriptSig.Add(scriptPubKey)
+	script.SetRedeemScript(p2shRedeem, stack)
+	assert.True(t, script.Evaluate(z))
+}
+
+func TestVerifyP2shInput(t *testing.T) {
+	/*
+		p2sh is decided by the scriptPubKey of the previous output, the
+		redeem script is 1 of 1 multisig or p2wpkh
+	*/
+	privateKey := ecc.NewPrivateKey(big.NewInt(int64(8675309)))
+	_, sec := privateKey.GetPublicKey().Sec(true)
+	sign := func(z []byte, err error) []byte {
+		assert.Nil(t, err)
+		zMsg := new(big.Int)
+		zMsg.SetBytes(z)
+		return append(privateKey.Sign(zMsg).Der(), SIGHASH_ALL)
+	}
+	newTransaction := func(redeemScript *ScriptSig) *Transaction {
+		prevTx := make([]byte, 32)
+		prevTx[0] = 0x01
+		txInput := InitTransactionInput(prevTx, big.NewInt(int64(0)))
+		txInput.SetScript(InitScriptSig([][]byte{}))
+		scriptPubKey := P2shScript(ecc.Hash160(redeemScript.rawSerialize()))
+		txInput.SetPreviousOutput(InitTransactionOutPut(big.NewInt(int64(10000)), scriptPubKey))
+		txOutput := InitTransactionOutPut(big.NewInt(int64(9000)), P2pkScript(ecc.Hash160(sec)))
+		return InitTransaction(big.NewInt(int64(1)), []*TransactionInput{txInput},
+			[]*TransactionOutput{txOutput}, big.NewInt(int64(0)), true)
+	}
+
+	multisig := P2msScript(1, [][]byte{sec})
+	transaction := newTransaction(multisig)
+	sig := sign(transaction.SignHash(0, multisig, SIGHASH_ALL))
+	transaction.txInputs[0].SetScript(InitScriptSig([][]byte{{OP_0}, sig, multisig.rawSerialize()}))
+	assert.True(t, transaction.VerifyInput(0))
+	// scriptSig of p2sh can only push data
+	transaction.txInputs[0].SetScript(InitScriptSig([][]byte{{OP_0}, sig, {OP_NOP}, multisig.rawSerialize()}))
+	assert.False(t, transaction.VerifyInput(0))
+
+	p2wpkh := P2wpkhScript(ecc.Hash160(sec))
+	transaction = newTransaction(p2wpkh)
+	sig = sign(transaction.SignHashBip143(0, p2wpkh, nil, SIGHASH_ALL))
+	transaction.txInputs[0].SetScript(InitScriptSig([][]byte{p2wpkh.rawSerialize()}))
+	transaction.txInputs[0].SetWitness([][]byte{sig, sec})
+	assert.True(t, transaction.VerifyInput(0))
+	// nested witness program allows nothing but the redeem script in scriptSig
+	transaction.txInputs[0].SetScript(InitScriptSig([][]byte{sig, p2wpkh.rawSerialize()}))
+	assert.False(t, transaction.VerifyInput(0))
+}
+
+func TestVerifyWitnessProgram(t *testing.T) {
+	/*
+		segwit v0 is decided by the scriptPubKey, a scriptSig leaving the
+		program on the stack can't skip the witness
+	*/
+	privateKey := ecc.NewPrivateKey(big.NewInt(int64(8675309)))
+	_, sec := privateKey.GetPublicKey().Sec(true)
+	h160 := ecc.Hash160(sec)
+	witnessScript := P2msScript(1, [][]byte{sec})
+	h256 := sha256.Sum256(witnessScript.rawSerialize())
+
+	for _, scriptPubKey := range []*ScriptSig{P2wpkhScript(h160), P2wshScript(h256[:])} {
+		prevTx := make([]byte, 32)
+		prevTx[0] = 0x01
+		txInput := InitTransactionInput(prevTx, big.NewInt(int64(0)))
+		txInput.SetScript(InitScriptSig([][]byte{}))
+		txInput.SetPreviousOutput(InitTransactionOutPut(big.NewInt(int64(10000)), scriptPubKey))
+		txOutput := InitTransactionOutPut(big.NewInt(int64(9000)), P2pkScript(h160))
+		transaction := InitTransaction(big.NewInt(int64(1)), []*TransactionInput{txInput},
+			[]*TransactionOutput{txOutput}, big.NewInt(int64(0)), true)
+
+		// OP_1 with empty witness leaves 1 under the program
+		txInput.SetScript(InitScriptSig([][]byte{{OP_1}}))
+		assert.False(t, transaction.VerifyInput(0))
+		assert.False(t, transaction.Verify())
+
+		// empty witness
+		txInput.SetScript(InitScriptSig([][]byte{}))
+		assert.False(t, transaction.VerifyInput(0))
+
+		// valid witness with non-empty scriptSig
+		scriptCode := P2pkScript(h160)
+		if scriptPubKey.IsP2wshScriptPubKey() {
+			scriptCode = witnessScript
+		}
+		z := ecc.Hash256(string(transaction.serializeBip143(0, scriptCode, big.NewInt(int64(10000)), SIGHASH_ALL)))
+		zMsg := new(big.Int)
+		zMsg.SetBytes(z)
+		sig := append(privateKey.Sign(zMsg).Der(), SIGHASH_ALL)
+		if scriptPubKey.IsP2wpkhScriptPubKey() {
+			txInput.SetWitness([][]byte{sig, sec})
+		} else {
+			txInput.SetWitness([][]byte{{}, sig, witnessScript.rawSerialize()})
+		}
+		assert.True(t, transaction.VerifyInput(0))
+		txInput.SetScript(InitScriptSig([][]byte{{OP_1}}))
+		assert.False(t, transaction.VerifyInput(0))
+	}
+
+	// legacy script leaving OP_0 <20 bytes> is not a witness program
+	script := InitScriptSig([][]byte{{OP_0}, h160}).Add(InitScriptSig([][]byte{{OP_NOP}}))
+	assert.False(t, script.EvaluateWithWitness(nil, [][]byte{}))
+	script = InitScriptSig([][]byte{{OP_0}, h160}).Add(InitScriptSig([][]byte{{OP_NOP}}))
+	assert.True(t, script.Evaluate(nil))
+}
+
+func TestSerializeRoundTrip(t *testing.T) {
+	/*
+		parse then serialize mainnet transactions should give back the
+		same bytes, the id is hash256 of the bytes in reverse order
+	*/
+	tests := []struct {
+		name      string
+		binaryStr string
+		id        string
+	}{
+		{
+			"legacy p2pkh",
+			"0100000001813f79011acb80925dfe69b3def355fe914bd1d96a3f5f71bf8303c6a989c7d1000000006b483045022100ed81ff192e75a3fd2304004dcadb746fa5e24c5031ccfcf21320b0277457c98f02207a986d955c6e0cb35d446a89d3f56100f4d7f67801c31967743a9c8e10615bed01210349fc4e631e3624a545de3f89f5d8684c7b8138bd94bdd531d2e213bf016b278afeffffff02a135ef01000000001976a914bc3b654dca7e56b04dca18f2566cdaf02e8d9ada88ac99c39800000000001976a9141c4bc762dd5423e332166702cb75f40df79fea1288ac19430600",
+			"452c629d67e41baec3ac6f04fe744b4b9617f8f859c63b3002f8684e7a4fee03",
+		},
+		{
+			// coinbase of the genesis block, the scriptSig is not a normal script
+			"genesis coinbase",
+			"01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000",
+			"4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b",
+		},
+		{
+			// first bitcoin payment from Satoshi to Hal Finney in block 170, p2pk
+			"block 170 p2pk",
+			"0100000001c997a5e56e104102fa209c6a852dd90660a20b2d9c352423edce25857fcd3704000000004847304402204e45e16932b8af514961a1d3a1a25fdf3f4f7732e9d624c6c61548ab5fb8cd410220181522ec8eca07de4860a4acdd12909d831cc56cbbac4622082221a8768d1d0901ffffffff0200ca9a3b00000000434104ae1a62fe09c5f51b13905f07f06b99a2f7159b2225f374cd378d71302fa28414e7aab37397f554a7df5f142c21c1b7303b8a0626f1baded5c72a704f7e6cd84cac00286bee0000000043410411db93e1dcdb8a016b49840f8c53bc1eb68a382e97b1482ecad7b148a6909a5cb2e0eaddfb84ccf9744464f82e160bfa9b8b64f9d4c03f999b8643f656b412a3ac00000000",
+			"f4184fc596403b9d638783cf57adfe4c75c605f6356fbc91338530e9831e9e16",
+		},
+	}
+
+	for _, test := range tests {
+		binary, err := hex.DecodeString(test.binaryStr)
+		assert.Nil(t, err)
+		transaction, err := ParseTransaction(binary)
+		assert.Nil(t, err, test.name)
+		assert.False(t, transaction.IsSegwit())
+		assert.Equal(t, test.binaryStr, fmt.Sprintf("%x", transaction.Serialize()), test.name)
+		assert.Equal(t, test.id, transaction.ID(), test.name)
+		// witness id is the same as id for legacy transaction
+		assert.Equal(t, transaction.ID(), transaction.WitnessID())
+		fmt.Printf("%s: %s\n", test.name, transaction.ID())
+	}
+}
+
+func TestSerializeNonMinimalPush(t *testing.T) {
+	/*
+		push the 0x48 bytes signature by OP_PUSHDATA1 instead of the single
+		byte length, the script is the same but the bytes are different, we
+		need to keep the bytes or the transaction id changes
+	*/
+	binaryStr := "0100000001813f79011acb80925dfe69b3def355fe914bd1d96a3f5f71bf8303c6a989c7d1000000006c4c483045022100ed81ff192e75a3fd2304004dcadb746fa5e24c5031ccfcf21320b0277457c98f02207a986d955c6e0cb35d446a89d3f56100f4d7f67801c31967743a9c8e10615bed01210349fc4e631e3624a545de3f89f5d8684c7b8138bd94bdd531d2e213bf016b278afeffffff02a135ef01000000001976a914bc3b654dca7e56b04dca18f2566cdaf02e8d9ada88ac99c39800000000001976a9141c4bc762dd5423e332166702cb75f40df79fea1288ac19430600"
+	binary, err := hex.DecodeString(binaryStr)
+	assert.Nil(t, err)
+	transaction, err := ParseTransaction(binary)
+	assert.Nil(t, err)
+	assert.Equal(t, binaryStr, fmt.Sprintf("%x", transaction.Serialize()))
+	assert.NotEqual(t, "452c629d67e41baec3ac6f04fe744b4b9617f8f859c63b3002f8684e7a4fee03", transaction.ID())
+
+	// the commands are the same as the minimal one
+	scriptSig := transaction.txInputs[0].scriptSig
+	assert.Equal(t, 2, len(scriptSig.bitcoinOpCode.cmds))
+	assert.Equal(t, 0x48, len(scriptSig.bitcoinOpCode.cmds[0]))
+
+	// script built from commands uses the shortest push
+	rebuilt := initScriptSigWithDataFlags(scriptSig.bitcoinOpCode.cmds, scriptSig.bitcoinOpCode.dataCmds)
+	assert.Equal(t, byte(0x48), rebuilt.rawSerialize()[0])
+}
+
+func TestParseSegwitTransaction(t *testing.T) {
+	// two p2sh-p2wpkh inputs
+	binaryStr := "01000000000102197393122da5beff963907ff11e4041af10780c868188aad754cc73e3cc35cd9010000001716001462c61a14835b032d5acbe190291d80d0cc5ca28e00000000feae2204104ffe542f30a20012a5b8e2b54a6f61f592520b511801b2237b5ed80100000017160014b30be91e50402cda780c56a3e1c350b1086c80af000000000200a3e111000000001976a914e60c9ac5f72d1d620287a0fc35656bceae5e2ab988ac525d35130000000017a9144795995aff558cc538669ebfecffbe5c9837d5ca870247304402207dd1e7c6c596041276b5285dd3747f586ad819a24acdf0ad60b1faa82af00d3b022046a22dd57df4b72ac165e05b4a6cf8dbecfcfad8f16ae7353df56638ebbf5d1f012103a1a226c5047672af98b2e673751dc69f0140b957753d9c1a789c243100292c6f024730440220670625143c3dfc7a862659a79cbf4ad0f84ff1509bd052cfbfbcdba7adf501f9022015f14a6ee1ae7a8f9fec1070d8a97195422b76a317286c816392cb150d7eb76d012102c910a40bf5726168acc5a8318b0505375e877d4d74448f32ef48156794e657f900000000"
+	binary, err := hex.DecodeString(binaryStr)
+	assert.Nil(t, err)
+	transaction, err := ParseTransaction(binary)
+	assert.Nil(t, err)
+
+	assert.True(t, transaction.IsSegwit())
+	assert.Equal(t, 2, len(transaction.txInputs))
+	assert.Equal(t, 2, len(transaction.txOutputs))
+	for _, txInput := range transaction.txInputs {
+		witness := txInput.Witness()
+		assert.Equal(t, 2, len(witness))
+		// signature and compressed public key
+		assert.Equal(t, 71, len(witness[0]))
+		assert.Equal(t, 33, len(witness[1]))
+	}
+	assert.Equal(t, "02c910a40bf5726168acc5a8318b0505375e877d4d74448f32ef48156794e657f9",
+		fmt.Sprintf("%x", transaction.txInputs[1].Witness()[1]))
+
+	// serialization with witness should give back the same bytes
+	assert.Equal(t, binaryStr, fmt.Sprintf("%x", transaction.Serialize()))
+
+	// legacy serialization drops marker, flag and witness
+	legacy := transaction.SerializeLegacy()
+	assert.Equal(t, len(binary)-2-len(transaction.txInputs[0].SerializeWitness())-
+		len(transaction.txInputs[1].SerializeWitness()), len(legacy))
+	assert.NotEqual(t, transaction.ID(), transaction.WitnessID())
+	fmt.Printf("txid: %s, wtxid: %s\n", transaction.ID(), transaction.WitnessID())
+
+	// changing witness changes wtxid but not txid
+	id := transaction.ID()
+	wid := transaction.WitnessID()
+	transaction.txInputs[0].SetWitness([][]byte{})
+	assert.Equal(t, id, transaction.ID())
+	assert.NotEqual(t, wid, transaction.WitnessID())
+}
+
+func TestBip143SignHash(t *testing.T) {
+	// native p2wpkh example from BIP143, the second input is spending 6 btc p2wpkh output
+	binaryStr := "0100000002fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f0000000000eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac11000000"
+	binary, err := hex.DecodeString(binaryStr)
+	assert.Nil(t, err)
+	transaction, err := ParseTransaction(binary)
+	assert.Nil(t, err)
+
+	h160, err := hex.DecodeString("1d0f172a0ecb48aee1be1f2687d2963ae33f71a1")
+	assert.Nil(t, err)
+	amount := big.NewInt(int64(6 * STASHI_PRE_BITCOIN))
+	z := ecc.Hash256(string(transaction.serializeBip143(1, P2pkScript(h160), amount, SIGHASH_ALL)))
+	assert.Equal(t, "96b827c8483d4e9b96712b6713a7b68d6e8003a781feba36c31143470b4efd37", fmt.Sprintf("%x", transaction.getHashPrevouts()))
+	assert.Equal(t, "52b0a642eea2fb7ae638c36f6252b6750293dbe574a806984b8e4d8548339a3b", fmt.Sprintf("%x", transaction.getHashSequence()))
+	assert.Equal(t, "863ef3e1a92afbfdb97f31ad0fc7683ee943e9abcf2501590ff8f6551f47e5e5", fmt.Sprintf("%x", transaction.getHashOutputs()))
+	assert.Equal(t, "c37af31116d1b27caf68aae9e3ac82f1477929014d5b917657d0eb49478cb670", fmt.Sprintf("%x", z))
+
+	// p2sh-p2wpkh example from BIP143, spending 10 btc
+	binaryStr = "0100000001db6b1b20aa0fd7b23880be2ecbd4a98130974cf4748fb66092ac4d3ceb1a54770100000000feffffff02b8b4eb0b000000001976a914a457b684d7f0d539a46a45bbc043f35b59d0d96388ac0008af2f000000001976a914fd270b1ee6abcaea97fea7ad0402e8bd8ad6d77c88ac92040000"
+	binary, err = hex.DecodeString(binaryStr)
+	assert.Nil(t, err)
+	transaction, err = ParseTransaction(binary)
+	assert.Nil(t, err)
+
+	h160, err = hex.DecodeString("79091972186c449eb1ded22b78e40d009bdf0089")
+	assert.Nil(t, err)
+	amount = big.NewInt(int64(10 * STASHI_PRE_BITCOIN))
+	z = ecc.Hash256(string(transaction.serializeBip143(0, P2pkScript(h160), amount, SIGHASH_ALL)))
+	assert.Equal(t, "b0287b4a252ac05af83d2dcef00ba313af78a3e9c329afa216eb3aa2a7b4613a", fmt.Sprintf("%x", transaction.getHashPrevouts()))
+	assert.Equal(t, "18606b350cd8bf565266bc352f0caddcf01e8fa789dd8a15386327cf8cabe198", fmt.Sprintf("%x", transaction.getHashSequence()))
+	assert.Equal(t, "de984f44532e2173ca0d64314fcefe6d30da6f8cf27bafa706da61df8a226c83", fmt.Sprintf("%x", transaction.getHashOutputs()))
+	assert.Equal(t, "64f3b0f4dd2bb3aa1ce8566d220cc74dda9df97d8490cc81d89d735c92e59fb6", fmt.Sprintf("%x", z))
+
+	// changing the sequence or the outputs after signing changes the message
+	transaction.txInputs[0].sequence = big.NewInt(0xffffffff)
+	assert.NotEqual(t, "18606b350cd8bf565266bc352f0caddcf01e8fa789dd8a15386327cf8cabe198", fmt.Sprintf("%x", transaction.getHashSequence()))
+	transaction.txOutputs = transaction.txOutputs[0:1]
+	assert.NotEqual(t, "de984f44532e2173ca0d64314fcefe6d30da6f8cf27bafa706da61df8a226c83", fmt.Sprintf("%x", transaction.getHashOutputs()))
+	assert.NotEqual(t, z, ecc.Hash256(string(transaction.serializeBip143(0, P2pkScript(h160), amount, SIGHASH_ALL))))
+}
+
+func TestEvaluateP2wpkh(t *testing.T) {
+	// signed native p2wpkh example from BIP143
+	binaryStr := "01000000000102fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f00000000494830450221008b9d1dc26ba6a9cb62127b02742fa9d754cd3bebf337f7a55d114c8e5cdd30be022040529b194ba3f9281a99f2b1c0a19c0489bc22ede944ccf4ecbab4cc618ef3ed01eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac000247304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d5447a12fb1366d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d43c212a8caed02de67eebee0121025476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee635711000000"
+	binary, err := hex.DecodeString(binaryStr)
+	assert.Nil(t, err)
+	transaction, err := ParseTransaction(binary)
+	assert.Nil(t, err)
+	assert.Equal(t, binaryStr, fmt.Sprintf("%x", transaction.Serialize()))
+
+	h160, err := hex.DecodeString("1d0f172a0ecb48aee1be1f2687d2963ae33f71a1")
+	assert.Nil(t, err)
+	amount := big.NewInt(int64(6 * STASHI_PRE_BITCOIN))
+	z := ecc.Hash256(string(transaction.serializeBip143(1, P2pkScript(h160), amount, SIGHASH_ALL)))
+
+	txInput := transaction.txInputs[1]
+	script := txInput.scriptSig.Add(P2wpkhScript(h160))
+	assert.True(t, script.EvaluateWithWitness(z, txInput.Witness()))
+
+	// missing witness should fail
+	script = txInput.scriptSig.Add(P2wpkhScript(h160))
+	assert.False(t, script.EvaluateWithWitness(z, [][]byte{}))
+}
+
+func TestEvaluateP2wsh(t *testing.T) {
+	/*
+		spend 1 of 1 multisig witness script, signature is created on the
+		BIP143 message with the witness script as scriptCode
+	*/
+	p := new(big.Int)
+	p.SetBytes(ReverseByteSlice(ecc.Hash256("p2wsh secret")))
+	privateKey := ecc.NewPrivateKey(p)
+	_, sec := privateKey.GetPublicKey().Sec(true)
+	witnessScript := P2msScript(1, [][]byte{sec})
+	witnessScriptBin := witnessScript.rawSerialize()
+	h256 := sha256.Sum256(witnessScriptBin)
+
+	prevTx, err := hex.DecodeString("d1c789a9c60383bf715f3f6ad9d14b91fe55f3deb369fe5d9280cb1a01793f81")
+	assert.Nil(t, err)
+	txInput := InitTransactionInput(prevTx, big.NewInt(int64(0)))
+	txInput.SetScript(InitScriptSig([][]byte{}))
+	txOutput := InitTransactionOutPut(big.NewInt(int64(9000)), P2pkScript(ecc.Hash160(sec)))
+	transaction := InitTransaction(big.NewInt(int64(2)), []*TransactionInput{txInput},
+		[]*TransactionOutput{txOutput}, big.NewInt(int64(0)), true)
+
+	z := ecc.Hash256(string(transaction.serializeBip143(0, witnessScript, big.NewInt(int64(10000)), SIGHASH_ALL)))
+	zMsg := new(big.Int)
+	zMsg.SetBytes(z)
+	sig := append(privateKey.Sign(zMsg).Der(), byte(SIGHASH_ALL))
+	// dummy element for OP_CHECKMULTISIG bug
+	txInput.SetWitness([][]byte{{}, sig, witnessScriptBin})
+	assert.True(t, transaction.IsSegwit())
+
+	script := txInput.scriptSig.Add(P2wshScript(h256[:]))
+	script.SetFlags(SCRIPT_VERIFY_NULLDUMMY)
+	assert.True(t, script.EvaluateWithWitness(z, txInput.Witness()))
+
+	// witness script not matching the hash should fail
+	otherScript := P2msScript(1, [][]byte{sec, sec})
+	txInput.SetWitness([][]byte{{}, sig, otherScript.rawSerialize()})
+	script = txInput.scriptSig.Add(P2wshScript(h256[:]))
+	assert.False(t, script.EvaluateWithWitness(z, txInput.Witness()))
+}
+
+func TestSignHashTypes(t *testing.T) {
+	key := ecc.NewPrivateKey(big.NewInt(int64(8675309)))
+	_, sec := key.GetPublicKey().Sec(true)
+	scriptCode := P2pkScript(ecc.Hash160(sec))
+
+	prevTx, err := hex.DecodeString("d1c789a9c60383bf715f3f6ad9d14b91fe55f3deb369fe5d9280cb1a01793f81")
+	assert.Nil(t, err)
+	newTransaction := func() *Transaction {
+		txInputs := []*TransactionInput{InitTransactionInput(prevTx, big.NewInt(int64(0))),
+			InitTransactionInput(prevTx, big.NewInt(int64(1)))}
+		for _, txInput := range txInputs {
+			txInput.SetScript(InitScriptSig([][]byte{}))
+		}
+		txOutputs := []*TransactionOutput{InitTransactionOutPut(big.NewInt(int64(1000)), scriptCode),
+			InitTransactionOutPut(big.NewInt(int64(2000)), scriptCode)}
+		return InitTransaction(big.NewInt(int64(1)), txInputs, txOutputs, big.NewInt(int64(0)), true)
+	}
+
+	hashTypes := []byte{SIGHASH_ALL, SIGHASH_NONE, SIGHASH_SINGLE,
+		SIGHASH_ALL | SIGHASH_ANYONECANPAY, SIGHASH_NONE | SIGHASH_ANYONECANPAY,
+		SIGHASH_SINGLE | SIGHASH_ANYONECANPAY}
+	original := map[byte][]byte{}
+	for _, hashType := range hashTypes {
+		z, err := newTransaction().SignHash(0, scriptCode, hashType)
+		assert.Nil(t, err)
+		original[hashType] = z
+	}
+	// hash type is committed in the message
+	for i := 0; i < len(hashTypes); i++ {
+		for j := i + 1; j < len(hashTypes); j++ {
+			assert.NotEqual(t, original[hashTypes[i]], original[hashTypes[j]])
+		}
+	}
+
+	// changing second output only affects ALL
+	for _, hashType := range hashTypes {
+		transaction := newTransaction()
+		transaction.txOutputs[1].amount = big.NewInt(int64(3000))
+		z, err := transaction.SignHash(0, scriptCode, hashType)
+		assert.Nil(t, err)
+		if hashType&SIGHASH_BASE_MASK == SIGHASH_ALL {
+			assert.NotEqual(t, original[hashType], z)
+		} else {
+			assert.Equal(t, original[hashType], z)
+		}
+	}
+
+	// changing first output affects ALL and SINGLE
+	for _, hashType := range hashTypes {
+		transaction := newTransaction()
+		transaction.txOutputs[0].amount = big.NewInt(int64(3000))
+		z, err := transaction.SignHash(0, scriptCode, hashType)
+		assert.Nil(t, err)
+		if hashType&SIGHASH_BASE_MASK == SIGHASH_NONE {
+			assert.Equal(t, original[hashType], z)
+		} else {
+			assert.NotEqual(t, original[hashType], z)
+		}
+	}
+
+	// changing sequence of other input only affects ALL without ANYONECANPAY
+	for _, hashType := range hashTypes {
+		transaction := newTransaction()
+		transaction.txInputs[1].sequence = big.NewInt(int64(0))
+		z, err := transaction.SignHash(0, scriptCode, hashType)
+		assert.Nil(t, err)
+		if hashType == SIGHASH_ALL {
+			assert.NotEqual(t, original[hashType], z)
+		} else {
+			assert.Equal(t, original[hashType], z)
+		}
+	}
+
+	// adding input only keeps the message with ANYONECANPAY
+	for _, hashType := range hashTypes {
+		transaction := newTransaction()
+		transaction.txInputs = append(transaction.txInputs, InitTransactionInput(prevTx, big.NewInt(int64(2))))
+		transaction.txInputs[2].SetScript(InitScriptSig([][]byte{}))
+		z, err := transaction.SignHash(0, scriptCode, hashType)
+		assert.Nil(t, err)
+		if hashType&SIGHASH_ANYONECANPAY != 0 {
+			assert.Equal(t, original[hashType], z)
+		} else {
+			assert.NotEqual(t, original[hashType], z)
+		}
+	}
+
+	// SIGHASH_SINGLE without matching output signs the value 1
+	transaction := newTransaction()
+	transaction.txOutputs = transaction.txOutputs[0:1]
+	z, err := transaction.SignHash(1, scriptCode, SIGHASH_SINGLE)
+	assert.Nil(t, err)
+	assert.Equal(t, "0100000000000000000000000000000000000000000000000000000000000000", fmt.Sprintf("%x", z))
+	// there is no message to serialize for it
+	msg, err := transaction.SerializeWithSign(1, scriptCode, SIGHASH_SINGLE)
+	assert.ErrorIs(t, err, ErrSighashSingleNoOutput)
+	assert.Nil(t, msg)
+}
+
+func TestBip143SignHashTypes(t *testing.T) {
+	binaryStr := "0100000002fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f0000000000eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac11000000"
+	binary, err := hex.DecodeString(binaryStr)
+	assert.Nil(t, err)
+	h160, err := hex.DecodeString("1d0f172a0ecb48aee1be1f2687d2963ae33f71a1")
+	assert.Nil(t, err)
+	amount := big.NewInt(int64(6 * STASHI_PRE_BITCOIN))
+
+	message := func(transaction *Transaction, hashType byte) []byte {
+		return transaction.serializeBip143(1, P2pkScript(h160), amount, hashType)
+	}
+	zeroHash := make([]byte, 32)
+
+	transaction, err := ParseTransaction(binary)
+	assert.Nil(t, err)
+	msg := message(transaction, SIGHASH_ALL|SIGHASH_ANYONECANPAY)
+	// hashPrevouts and hashSequence are zero
+	assert.Equal(t, zeroHash, msg[4:36])
+	assert.Equal(t, zeroHash, msg[36:68])
+
+	msg = message(transaction, SIGHASH_NONE)
+	assert.Equal(t, transaction.getHashPrevouts(), msg[4:36])
+	assert.Equal(t, zeroHash, msg[36:68])
+	// hashOutputs is before lock time and hash type
+	assert.Equal(t, zeroHash, msg[len(msg)-40:len(msg)-8])
+	assert.Equal(t, []byte{SIGHASH_NONE, 0, 0, 0}, msg[len(msg)-4:])
+
+	msg = message(transaction, SIGHASH_SINGLE)
+	assert.Equal(t, ecc.Hash256(string(transaction.txOutputs[1].Serialize())), msg[len(msg)-40:len(msg)-8])
+
+	// no output for the input, hashOutputs is zero
+	transaction.txOutputs = transaction.txOutputs[0:1]
+	msg = message(transaction, SIGHASH_SINGLE)
+	assert.Equal(t, zeroHash, msg[len(msg)-40:len(msg)-8])
+}
+
+func TestCheckSigHashType(t *testing.T) {
+	/*
+		opCheckSig computes the message by the hash type at the end of
+		signature
+	*/
+	key := ecc.NewPrivateKey(big.NewInt(int64(8675309)))
+	_, sec := key.GetPublicKey().Sec(true)
+	sigHash := func(hashType byte) ([]byte, bool) {
+		return ecc.Hash256(fmt.Sprintf("message with hash type %d", hashType)), true
+	}
+
+	for _, hashType := range []byte{SIGHASH_ALL, SIGHASH_NONE, SIGHASH_SINGLE | SIGHASH_ANYONECANPAY} {
+		z := new(big.Int)
+		msg, _ := sigHash(hashType)
+		z.SetBytes(msg)
+		sig := append(key.Sign(z).Der(), hashType)
+
+		script := InitScriptSig([][]byte{sig, sec, {OP_CHECKSIG}})
+		script.SetSigHash(sigHash)
+		assert.True(t, script.Evaluate(nil))
+
+		// signature with the hash type changed is invalid
+		sig[len(sig)-1] = SIGHASH_ALL | SIGHASH_ANYONECANPAY
+		script = InitScriptSig([][]byte{sig, sec, {OP_CHECKSIG}})
+		script.SetSigHash(sigHash)
+		assert.False(t, script.Evaluate(nil))
+	}
+}
+
+func TestParseTransactionError(t *testing.T) {
+	binaryStr := "01000000000102197393122da5beff963907ff11e4041af10780c868188aad754cc73e3cc35cd9010000001716001462c61a14835b032d5acbe190291d80d0cc5ca28e00000000feae2204104ffe542f30a20012a5b8e2b54a6f61f592520b511801b2237b5ed80100000017160014b30be91e50402cda780c56a3e1c350b1086c80af000000000200a3e111000000001976a914e60c9ac5f72d1d620287a0fc35656bceae5e2ab988ac525d35130000000017a9144795995aff558cc538669ebfecffbe5c9837d5ca870247304402207dd1e7c6c596041276b5285dd3747f586ad819a24acdf0ad60b1faa82af00d3b022046a22dd57df4b72ac165e05b4a6cf8dbecfcfad8f16ae7353df56638ebbf5d1f012103a1a226c5047672af98b2e673751dc69f0140b957753d9c1a789c243100292c6f024730440220670625143c3dfc7a862659a79cbf4ad0f84ff1509bd052cfbfbcdba7adf501f9022015f14a6ee1ae7a8f9fec1070d8a97195422b76a317286c816392cb150d7eb76d012102c910a40bf5726168acc5a8318b0505375e877d4d74448f32ef48156794e657f900000000"
+	binary, err := hex.DecodeString(binaryStr)
+	assert.Nil(t, err)
+
+	// cutting the transaction at any place gives error instead of panic
+	for i := 0; i < len(binary); i++ {
+		_, err := ParseTransaction(binary[0:i])
+		assert.ErrorIs(t, err, ErrTruncatedInput, "cut at %d", i)
+	}
+
+	// segwit marker followed by wrong flag
+	badFlag := append([]byte{}, binary...)
+	badFlag[5] = 0x02
+	_, err = ParseTransaction(badFlag)
+	assert.ErrorIs(t, err, ErrBadSegwitFlag)
+
+	// input count not in the shortest form
+	badCount := append([]byte{}, binary[0:6]...)
+	badCount = append(badCount, 0xfd, 0x02, 0x00)
+	badCount = append(badCount, binary[7:]...)
+	_, err = ParseTransaction(badCount)
+	assert.ErrorIs(t, err, ErrBadVarint)
+
+	// scriptSig of first input is 0x17 bytes, make the push inside it 0x17 bytes
+	badScript := append([]byte{}, binary...)
+	badScript[44] = 0x17
+	_, err = NewScriptSig(bufio.NewReader(bytes.NewReader(badScript[43:])))
+	assert.ErrorIs(t, err, ErrScriptLengthMismatch)
+	fmt.Printf("parse error: %v\n", err)
+
+	// the transaction is still parsed with the script bytes kept, but it can't run
+	transaction, err := ParseTransaction(badScript)
+	assert.Nil(t, err)
+	assert.Equal(t, badScript, transaction.Serialize())
+	scriptSig := transaction.txInputs[0].scriptSig
+	assert.ErrorIs(t, scriptSig.parseErr, ErrScriptLengthMismatch)
+	assert.False(t, scriptSig.Add(P2shScript(make([]byte, 20))).Evaluate(nil))
+}
@@ -5,40 +5,179 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
-type TransactionFetcher struct{}
+/*
+TransactionFetcher gets the raw binary of a transaction by its id, the
+previous transaction of an input is needed for its amount and scriptPubKey
+*/
+type TransactionFetcher interface {
+	Fetch(txID string, testnet bool) ([]byte, error)
+}
+
+func NewTransactionInputFetch() TransactionFetcher {
+	return NewEsploraFetcher("https://blockstream.info/api", "https://blockstream.info/testnet/api")
+}
+
+/*
+FetchTransaction gets the transaction from the fetcher and makes sure
+the id of the returned transaction is the one we ask for, we can't trust
+the amount and scriptPubKey returned by others without this check
+*/
+func FetchTransaction(fetcher TransactionFetcher, txID string, testnet bool) (*Transaction, error) {
+	raw, err := fetcher.Fetch(txID, testnet)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := ParseTransaction(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse transaction %s: %w", txID, err)
+	}
+	if tx.ID() != txID {
+		return nil, fmt.Errorf("fetched transaction id %s is not the requested %s", tx.ID(), txID)
+	}
+	tx.SetFetcher(fetcher)
+	return tx, nil
+}
+
+// MemoryFetcher keeps the transactions in a map, used for offline and testing
+type MemoryFetcher struct {
+	transactions map[string][]byte
+}
+
+func NewMemoryFetcher() *MemoryFetcher {
+	return &MemoryFetcher{
+		transactions: make(map[string][]byte),
+	}
+}
+
+func (m *MemoryFetcher) Add(raw []byte) (string, error) {
+	// the transaction is keyed by its id
+	tx, err := ParseTransaction(raw)
+	if err != nil {
+		return "", err
+	}
+	m.transactions[tx.ID()] = raw
+	return tx.ID(), nil
+}
+
+func (m *MemoryFetcher) AddTransaction(tx *Transaction) string {
+	m.transactions[tx.ID()] = tx.Serialize()
+	return tx.ID()
+}
+
+func (m *MemoryFetcher) Fetch(txID string, testnet bool) ([]byte, error) {
+	raw, ok := m.transactions[txID]
+	if !ok {
+		return nil, fmt.Errorf("transaction %s not found", txID)
+	}
+	return append([]byte{}, raw...), nil
+}
+
+/*
+DiskCacheFetcher reads transaction from <dir>/<txid>.hex, testnet transactions
+are in the testnet sub directory, if it is not in the directory and a fallback
+fetcher is given, the transaction is fetched from the fallback and saved
+*/
+type DiskCacheFetcher struct {
+	dir      string
+	fallback TransactionFetcher
+}
+
+func NewDiskCacheFetcher(dir string, fallback TransactionFetcher) *DiskCacheFetcher {
+	return &DiskCacheFetcher{
+		dir:      dir,
+		fallback: fallback,
+	}
+}
+
+func (d *DiskCacheFetcher) path(txID string, testnet bool) string {
+	if testnet {
+		return filepath.Join(d.dir, "testnet", txID+".hex")
+	}
+	return filepath.Join(d.dir, txID+".hex")
+}
+
+func (d *DiskCacheFetcher) Fetch(txID string, testnet bool) ([]byte, error) {
+	path := d.path(txID, testnet)
+	content, err := os.ReadFile(path)
+	if err == nil {
+		return hex.DecodeString(strings.TrimSpace(string(content)))
+	}
+	if !os.IsNotExist(err) || d.fallback == nil {
+		return nil, fmt.Errorf("read cached transaction %s: %w", txID, err)
+	}
+
+	raw, err := d.fallback.Fetch(txID, testnet)
+	if err != nil {
+		return nil, err
+	}
+	// only save the transaction with the right id
+	tx, err := ParseTransaction(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse transaction %s: %w", txID, err)
+	}
+	if tx.ID() != txID {
+		return nil, fmt.Errorf("fetched transaction is not %s", txID)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(raw)), 0o644); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
 
-func NewTransactionInputFetch() *TransactionFetcher {
-	return &TransactionFetcher{}
+/*
+EsploraFetcher gets transaction from http api compatible with Esplora,
+GET <url>/tx/<txid>/hex returns the transaction in hex
+*/
+type EsploraFetcher struct {
+	mainnetURL string
+	testnetURL string
+	client     *http.Client
 }
 
-func (t *TransactionFetcher) getURL(testnet bool) string {
+func NewEsploraFetcher(mainnetURL, testnetURL string) *EsploraFetcher {
+	return &EsploraFetcher{
+		mainnetURL: strings.TrimSuffix(mainnetURL, "/"),
+		testnetURL: strings.TrimSuffix(testnetURL, "/"),
+		client:     http.DefaultClient,
+	}
+}
+
+func (e *EsploraFetcher) SetClient(client *http.Client) {
+	e.client = client
+}
+
+func (e *EsploraFetcher) getURL(testnet bool) string {
 	if testnet {
-		return "https://blockstream.info/testnet/api/tx"
+		return e.testnetURL
 	}
 
-	return "https://blockstream.info/api/tx"
+	return e.mainnetURL
 }
 
-func (t *TransactionFetcher) Fetch(txID string, testnet bool) []byte {
-	url := fmt.Sprintf("%s/%s/hex", t.getURL(testnet), txID)
-	fmt.Printf("fetching url: %s\n", url)
-	resp, err := http.Get(url)
+func (e *EsploraFetcher) Fetch(txID string, testnet bool) ([]byte, error) {
+	url := fmt.Sprintf("%s/tx/%s/hex", e.getURL(testnet), txID)
+	resp, err := e.client.Get(url)
 	if err != nil {
-		panic(fmt.Sprintf("fetch transaction err: %v\n", err))
+		return nil, fmt.Errorf("fetch transaction err: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		panic(fmt.Sprintf("read body err: %v\n", err))
+		return nil, fmt.Errorf("read body err: %w", err)
 	}
-	fmt.Printf("response: %s\n", string(body))
-	buf, err := hex.DecodeString(string(body))
-	if err != nil {
-		panic(err)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch transaction %s status %d: %s", txID, resp.StatusCode, string(body))
 	}
 
-	return buf
+	return hex.DecodeString(strings.TrimSpace(string(body)))
 }
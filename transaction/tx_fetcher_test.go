@@ -0,0 +1,191 @@
+package transaction
+
+import (
+	ecc "elliptic_curve"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newFundingTransaction(sec []byte) *Transaction {
+	/*
+		previous transaction paying 10000 satoshi to p2pkh and 20000 satoshi
+		to p2wpkh of the given public key
+	*/
+	prevTx, err := hex.DecodeString("d1c789a9c60383bf715f3f6ad9d14b91fe55f3deb369fe5d9280cb1a01793f81")
+	if err != nil {
+		panic(err)
+	}
+	txInput := InitTransactionInput(prevTx, big.NewInt(int64(0)))
+	txInput.SetScript(InitScriptSig([][]byte{}))
+	h160 := ecc.Hash160(sec)
+	txOutputs := []*TransactionOutput{
+		InitTransactionOutPut(big.NewInt(int64(10000)), P2pkScript(h160)),
+		InitTransactionOutPut(big.NewInt(int64(20000)), P2wpkhScript(h160)),
+	}
+	return InitTransaction(big.NewInt(int64(1)), []*TransactionInput{txInput}, txOutputs, big.NewInt(int64(0)), true)
+}
+
+func TestMemoryFetcherVerify(t *testing.T) {
+	privateKey := ecc.NewPrivateKey(big.NewInt(int64(8675309)))
+	_, sec := privateKey.GetPublicKey().Sec(true)
+	fetcher := NewMemoryFetcher()
+	prevID := fetcher.AddTransaction(newFundingTransaction(sec))
+
+	prevTx, err := hex.DecodeString(prevID)
+	assert.Nil(t, err)
+	txInputs := []*TransactionInput{InitTransactionInput(prevTx, big.NewInt(int64(0))),
+		InitTransactionInput(prevTx, big.NewInt(int64(1)))}
+	for _, txInput := range txInputs {
+		txInput.SetScript(InitScriptSig([][]byte{}))
+	}
+	txOutput := InitTransactionOutPut(big.NewInt(int64(25000)), P2pkScript(ecc.Hash160(sec)))
+	transaction := InitTransaction(big.NewInt(int64(1)), txInputs, []*TransactionOutput{txOutput}, big.NewInt(int64(0)), true)
+	transaction.SetFetcher(fetcher)
+	fee, err := transaction.Fee()
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(int64(5000)), fee)
+
+	sign := func(z []byte, err error) []byte {
+		assert.Nil(t, err)
+		zMsg := new(big.Int)
+		zMsg.SetBytes(z)
+		return append(privateKey.Sign(zMsg).Der(), byte(SIGHASH_ALL))
+	}
+	// p2pkh input signs legacy message, p2wpkh input signs BIP143 message
+	legacySig := sign(transaction.SignHash(0, nil, SIGHASH_ALL))
+	witnessSig := sign(transaction.SignHashBip143(1, nil, nil, SIGHASH_ALL))
+	txInputs[0].SetScript(InitScriptSig([][]byte{legacySig, sec}))
+	txInputs[1].SetWitness([][]byte{witnessSig, sec})
+	assert.True(t, transaction.Verify())
+
+	// parsed transaction needs the fetcher set again
+	parsed, err := ParseTransaction(transaction.Serialize())
+	assert.Nil(t, err)
+	parsed.SetFetcher(fetcher)
+	assert.True(t, parsed.Verify())
+
+	// signature of legacy input is not valid for segwit input
+	txInputs[1].SetWitness([][]byte{legacySig, sec})
+	assert.False(t, transaction.VerifyInput(1))
+}
+
+func TestFetcherMiss(t *testing.T) {
+	/*
+		previous transaction not in the fetcher or the index beyond its
+		outputs gives error, the input can't be verified or signed
+	*/
+	privateKey := ecc.NewPrivateKey(big.NewInt(int64(8675309)))
+	_, sec := privateKey.GetPublicKey().Sec(true)
+	fetcher := NewMemoryFetcher()
+	prevID := fetcher.AddTransaction(newFundingTransaction(sec))
+	prevTx, err := hex.DecodeString(prevID)
+	assert.Nil(t, err)
+	unknownTx := ReverseByteSlice(prevTx)
+
+	for _, txInput := range []*TransactionInput{InitTransactionInput(unknownTx, big.NewInt(int64(0))),
+		InitTransactionInput(prevTx, big.NewInt(int64(2))),
+		InitTransactionInput(prevTx, big.NewInt(int64(0xffffffff)))} {
+		txInput.SetScript(InitScriptSig([][]byte{}))
+		txOutput := InitTransactionOutPut(big.NewInt(int64(5000)), P2pkScript(ecc.Hash160(sec)))
+		transaction := InitTransaction(big.NewInt(int64(1)), []*TransactionInput{txInput},
+			[]*TransactionOutput{txOutput}, big.NewInt(int64(0)), true)
+		transaction.SetFetcher(fetcher)
+
+		_, err = txInput.Value(true)
+		assert.ErrorIs(t, err, ErrPreviousOutput)
+		_, err = transaction.Fee()
+		assert.ErrorIs(t, err, ErrPreviousOutput)
+		_, err = transaction.SignHash(0, nil, SIGHASH_ALL)
+		assert.ErrorIs(t, err, ErrPreviousOutput)
+		_, err = transaction.GetScript(0, true)
+		assert.ErrorIs(t, err, ErrPreviousOutput)
+		assert.False(t, transaction.VerifyInput(0))
+		assert.False(t, transaction.Verify())
+		assert.False(t, transaction.SignInput(0, privateKey))
+
+		// no such input at all
+		_, err = transaction.SignHash(1, nil, SIGHASH_SINGLE)
+		assert.ErrorIs(t, err, ErrInputIndex)
+		_, err = transaction.SignHashBip143(-1, nil, nil, SIGHASH_ALL)
+		assert.ErrorIs(t, err, ErrInputIndex)
+		_, err = transaction.SignHashTaproot(1, SIGHASH_DEFAULT, nil, nil, 0)
+		assert.ErrorIs(t, err, ErrInputIndex)
+		assert.False(t, transaction.VerifyInput(1))
+	}
+}
+
+func TestFetchTransactionCheckID(t *testing.T) {
+	_, sec := ecc.NewPrivateKey(big.NewInt(int64(8675309))).GetPublicKey().Sec(true)
+	fetcher := NewMemoryFetcher()
+	prevID := fetcher.AddTransaction(newFundingTransaction(sec))
+
+	tx, err := FetchTransaction(fetcher, prevID, true)
+	assert.Nil(t, err)
+	assert.Equal(t, prevID, tx.ID())
+
+	_, err = FetchTransaction(fetcher, "d1c789a9c60383bf715f3f6ad9d14b91fe55f3deb369fe5d9280cb1a01793f81", true)
+	assert.NotNil(t, err)
+
+	// returned bytes do not match the requested id
+	fakeID := "0000000000000000000000000000000000000000000000000000000000000001"
+	fetcher.transactions[fakeID] = fetcher.transactions[prevID]
+	_, err = FetchTransaction(fetcher, fakeID, true)
+	assert.NotNil(t, err)
+	fmt.Printf("fetch err: %v\n", err)
+}
+
+func TestDiskCacheFetcher(t *testing.T) {
+	_, sec := ecc.NewPrivateKey(big.NewInt(int64(8675309))).GetPublicKey().Sec(true)
+	memory := NewMemoryFetcher()
+	prevID := memory.AddTransaction(newFundingTransaction(sec))
+	dir := t.TempDir()
+
+	// not in the cache, fetched from fallback and saved
+	fetcher := NewDiskCacheFetcher(dir, memory)
+	raw, err := fetcher.Fetch(prevID, true)
+	assert.Nil(t, err)
+	cached, err := os.ReadFile(filepath.Join(dir, "testnet", prevID+".hex"))
+	assert.Nil(t, err)
+	assert.Equal(t, hex.EncodeToString(raw), string(cached))
+
+	// read from the cache without fallback
+	fetcher = NewDiskCacheFetcher(dir, nil)
+	tx, err := FetchTransaction(fetcher, prevID, true)
+	assert.Nil(t, err)
+	assert.Equal(t, prevID, tx.ID())
+
+	// mainnet transaction is in another place
+	_, err = fetcher.Fetch(prevID, false)
+	assert.NotNil(t, err)
+}
+
+func TestEsploraFetcher(t *testing.T) {
+	_, sec := ecc.NewPrivateKey(big.NewInt(int64(8675309))).GetPublicKey().Sec(true)
+	prev := newFundingTransaction(sec)
+	prevID := prev.ID()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == fmt.Sprintf("/testnet/api/tx/%s/hex", prevID) {
+			fmt.Fprintf(w, "%x\n", prev.Serialize())
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	fetcher := NewEsploraFetcher(server.URL+"/api", server.URL+"/testnet/api/")
+	tx, err := FetchTransaction(fetcher, prevID, true)
+	assert.Nil(t, err)
+	assert.Equal(t, prev.Serialize(), tx.Serialize())
+
+	_, err = fetcher.Fetch(prevID, false)
+	assert.NotNil(t, err)
+}
@@ -3,8 +3,10 @@ package transaction
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/tsuna/endian"
+	"io"
 	"math/big"
 )
 
@@ -12,6 +14,8 @@ type LITTLE_ENDIAN_LENGTH int
 
 const (
 	STASHI_PRE_BITCOIN = 100000000
+	// largest value of varint accepted when parsing, the same as bitcoin core
+	MAX_VARINT_SIZE = 0x02000000
 )
 
 const (
@@ -26,6 +30,53 @@ func P2pkScript(hash160 []byte) *ScriptSig {
 	return InitScriptSig(scriptContent)
 }
 
+func P2shScript(hash160 []byte) *ScriptSig {
+	// OP_HASH160 <20 bytes hash of redeem script> OP_EQUAL
+	scriptContent := [][]byte{[]byte{OP_HASH160}, hash160, []byte{OP_EQUAL}}
+	return InitScriptSig(scriptContent)
+}
+
+func P2wpkhScript(hash160 []byte) *ScriptSig {
+	// OP_0 <20 bytes hash160 of compressed public key>
+	scriptContent := [][]byte{[]byte{OP_0}, hash160}
+	return InitScriptSig(scriptContent)
+}
+
+func P2wshScript(hash256 []byte) *ScriptSig {
+	// OP_0 <32 bytes sha256 of witness script>
+	scriptContent := [][]byte{[]byte{OP_0}, hash256}
+	return InitScriptSig(scriptContent)
+}
+
+func P2trScript(outputKey []byte) *ScriptSig {
+	// OP_1 <32 bytes x only output key>
+	scriptContent := [][]byte{[]byte{OP_1}, outputKey}
+	return InitScriptSig(scriptContent)
+}
+
+func WitnessProgramScript(version byte, program []byte) *ScriptSig {
+	// OP_0 or OP_1 to OP_16 for witness version, then the witness program
+	versionOp := byte(OP_0)
+	if version > 0 {
+		versionOp = byte(OP_1 + version - 1)
+	}
+	return InitScriptSig([][]byte{[]byte{versionOp}, program})
+}
+
+func P2msScript(m int, pubKeys [][]byte) *ScriptSig {
+	/*
+		bare multisig script: OP_m <pubkey1> ... <pubkeyn> OP_n OP_CHECKMULTISIG
+	*/
+	if m < 1 || m > len(pubKeys) || len(pubKeys) > 16 {
+		panic("invalid m of n for multisig script")
+	}
+
+	scriptContent := [][]byte{[]byte{byte(OP_1 + m - 1)}}
+	scriptContent = append(scriptContent, pubKeys...)
+	scriptContent = append(scriptContent, []byte{byte(OP_1 + len(pubKeys) - 1)}, []byte{OP_CHECKMULTISIG})
+	return InitScriptSig(scriptContent)
+}
+
 func BigIntToLittleEndian(v *big.Int, length LITTLE_ENDIAN_LENGTH) []byte {
 	switch length {
 	case LITTLE_ENDIAN_2_BYTES:
@@ -67,7 +118,7 @@ func LittleEndianToBigInt(bytes []byte, length LITTLE_ENDIAN_LENGTH) *big.Int {
 	return nil
 }
 
-func ReadVarint(reader *bufio.Reader) *big.Int {
+func ReadVarint(reader *bufio.Reader) (*big.Int, error) {
 	/*
 		0100000001813f79011acb80925dfe69b3def355fe914bd1d96a3f5f71bf8303c6a989c7d1000000006b483045022100ed81ff192e75a3fd2304004dcadb746fa5e24c5031ccfcf21320b0277457c98f02207a986d955c6e0cb35d446a89d3f56100f4d7f67801c31967743a9c8e10615bed01210349fc4e631e3624a545de3f89f5d8684c7b8138bd94bdd531d2e213bf016b278afeffffff02a135ef01000000001976a914bc3b654dca7e56b04dca18f2566cdaf02e8d9ada88ac99c39800000000001976a9141c4bc762dd5423e332166702cb75f40df79fea1288ac19430600
 
@@ -82,38 +133,72 @@ func ReadVarint(reader *bufio.Reader) *big.Int {
 		read the following 4 bytes as the count of input
 
 		4. if the byte following version is == 0xff, we read the following 8 bytes as count of input
+
+		the value should use the shortest form, otherwise the same transaction
+		has different binary data, and it can't be larger than MAX_VARINT_SIZE
 	*/
 
-	i := make([]byte, 1)
-	reader.Read(i)
+	i, err := ReadBytes(reader, 1)
+	if err != nil {
+		return nil, err
+	}
 	v := new(big.Int)
 	v.SetBytes(i)
 	if v.Cmp(big.NewInt(int64(0xfd))) < 0 {
-		return v
+		return v, nil
 	}
 
+	var minValue int64
+	var value *big.Int
 	if v.Cmp(big.NewInt(int64(0xfd))) == 0 {
-		i1 := make([]byte, 2)
-		reader.Read(i1)
-		return LittleEndianToBigInt(i1, LITTLE_ENDIAN_2_BYTES)
+		i1, err := ReadBytes(reader, 2)
+		if err != nil {
+			return nil, err
+		}
+		minValue = 0xfd
+		value = LittleEndianToBigInt(i1, LITTLE_ENDIAN_2_BYTES)
+	} else if v.Cmp(big.NewInt(int64(0xfe))) == 0 {
+		i1, err := ReadBytes(reader, 4)
+		if err != nil {
+			return nil, err
+		}
+		minValue = 0x10000
+		value = LittleEndianToBigInt(i1, LITTLE_ENDIAN_4_BYTES)
+	} else {
+		i1, err := ReadBytes(reader, 8)
+		if err != nil {
+			return nil, err
+		}
+		minValue = 0x100000000
+		value = new(big.Int).SetUint64(binary.LittleEndian.Uint64(i1))
 	}
 
-	if v.Cmp(big.NewInt(int64(0xfe))) == 0 {
-		i1 := make([]byte, 4)
-		reader.Read(i1)
-		return LittleEndianToBigInt(i1, LITTLE_ENDIAN_4_BYTES)
+	if value.Cmp(big.NewInt(minValue)) < 0 {
+		return nil, fmt.Errorf("%w: non-canonical encoding of %v", ErrBadVarint, value)
 	}
+	if value.Cmp(big.NewInt(MAX_VARINT_SIZE)) > 0 {
+		return nil, fmt.Errorf("%w: value %v too large", ErrBadVarint, value)
+	}
+	return value, nil
+}
 
-	i1 := make([]byte, 8)
-	reader.Read(i1)
-	return LittleEndianToBigInt(i1, LITTLE_ENDIAN_8_BYTES)
+func ReadBytes(reader *bufio.Reader, length int) ([]byte, error) {
+	// read exactly length bytes, not enough data means the input is truncated
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("%w: need %d bytes", ErrTruncatedInput, length)
+		}
+		return nil, err
+	}
+	return buf, nil
 }
 
 func EncodeVarint(v *big.Int) []byte {
 	//if the value < 0xfd, one byte is enough
 	if v.Cmp(big.NewInt(int64(0xfd))) < 0 {
-		vBytes := v.Bytes()
-		return []byte{vBytes[0]}
+		// zero has no bytes in big int
+		return []byte{byte(v.Uint64())}
 	} else if v.Cmp(big.NewInt(int64(0x10000))) < 0 {
 		//if value >= 0xfd and < 0x10000, then need 2 bytes
 		buf := []byte{0xfd}
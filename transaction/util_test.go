@@ -1,6 +1,9 @@
 package transaction
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
 	"fmt"
 	"math/big"
 	"testing"
@@ -22,3 +25,38 @@ func TestConversionBetweenBigEndianAndLittleEndian(t *testing.T) {
 
 	assert.Equal(t, p.Cmp(littleEndianByteToInt64), 0)
 }
+
+func TestReadVarint(t *testing.T) {
+	testCases := []struct {
+		binary string
+		value  int64
+		err    error
+	}{
+		{"00", 0, nil},
+		{"fc", 0xfc, nil},
+		{"fdfd00", 0xfd, nil},
+		{"fe00000100", 0x10000, nil},
+		{"fe00000002", MAX_VARINT_SIZE, nil},
+		// value should be in the shortest form
+		{"fd0100", 0, ErrBadVarint},
+		{"feffff0000", 0, ErrBadVarint},
+		{"ff0000000001000000", 0, ErrBadVarint},
+		{"fe01000002", 0, ErrBadVarint},
+		{"", 0, ErrTruncatedInput},
+		{"fd01", 0, ErrTruncatedInput},
+		{"fe010000", 0, ErrTruncatedInput},
+	}
+
+	for _, testCase := range testCases {
+		binary, err := hex.DecodeString(testCase.binary)
+		assert.Nil(t, err)
+		value, err := ReadVarint(bufio.NewReader(bytes.NewReader(binary)))
+		if testCase.err != nil {
+			assert.ErrorIs(t, err, testCase.err, testCase.binary)
+			continue
+		}
+		assert.Nil(t, err, testCase.binary)
+		assert.Equal(t, testCase.value, value.Int64(), testCase.binary)
+		assert.Equal(t, binary, EncodeVarint(value))
+	}
+}